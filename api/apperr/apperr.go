@@ -0,0 +1,199 @@
+// Package apperr holds the errors the API reports to clients. Each error has a
+// kind, which decides its HTTP status, and a stable code clients can match on;
+// the message is meant for people and may change between versions.
+package apperr
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Kind is the class of an error
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindBadRequest
+	KindValidation
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindMethodNotAllowed
+	KindConflict
+	KindTooLarge
+	KindPreconditionFailed
+)
+
+// Status is the HTTP status code errors of the kind are answered with
+func (k Kind) Status() int {
+	switch k {
+	case KindBadRequest:
+		return http.StatusBadRequest
+	case KindValidation:
+		return http.StatusUnprocessableEntity
+	case KindUnauthorized:
+		return http.StatusUnauthorized
+	case KindForbidden:
+		return http.StatusForbidden
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case KindConflict:
+		return http.StatusConflict
+	case KindTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case KindPreconditionFailed:
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusInternalServerError
+}
+
+// Codes shared by many endpoints. Endpoints add their own for conditions only
+// they report, such as no_available_rooms.
+const (
+	CodeInternal         = "internal_error"
+	CodeMalformedBody    = "malformed_body"
+	CodeValidationFailed = "validation_failed"
+	CodeNotFound         = "not_found"
+	CodeForbidden        = "forbidden"
+	CodeUnauthorized     = "unauthorized"
+	CodeBodyTooLarge     = "body_too_large"
+	CodeDuplicate        = "duplicate"
+)
+
+// FieldError tells what is wrong with one field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an error a client can act on
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Fields  []FieldError
+	Err     error // the cause, logged but never shown to clients
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" && e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status is the HTTP status code the error is answered with
+func (e *Error) Status() int {
+	return e.Kind.Status()
+}
+
+// New returns an error of the given kind
+func New(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+// BadRequest is a request the API cannot make sense of
+func BadRequest(code, message string) *Error {
+	return New(KindBadRequest, code, message)
+}
+
+// Unauthorized is a request without valid credentials
+func Unauthorized(code, message string) *Error {
+	return New(KindUnauthorized, code, message)
+}
+
+// Forbidden is a request the caller is not allowed to make
+func Forbidden(code, message string) *Error {
+	return New(KindForbidden, code, message)
+}
+
+// NotFound is a request for something that does not exist
+func NotFound(code, message string) *Error {
+	return New(KindNotFound, code, message)
+}
+
+// Conflict is a request that clashes with the current state of a resource
+func Conflict(code, message string) *Error {
+	return New(KindConflict, code, message)
+}
+
+// PreconditionFailed is a conditional request, such as one with If-Match, whose
+// condition no longer holds
+func PreconditionFailed(code, message string) *Error {
+	return New(KindPreconditionFailed, code, message)
+}
+
+// Validation is a well formed request with values the API does not accept
+func Validation(message string, fields ...FieldError) *Error {
+	return &Error{Kind: KindValidation, Code: CodeValidationFailed, Message: message, Fields: fields}
+}
+
+// InvalidField is a validation error about a single field of a request
+func InvalidField(field, message string) *Error {
+	return Validation(message, FieldError{Field: field, Message: message})
+}
+
+// bodyTooLarge is the message of the error http.MaxBytesReader returns once a
+// body is read past its limit. Readers such as multipart wrap it as text.
+const bodyTooLarge = "http: request body too large"
+
+// Malformed wraps the error met while reading or decoding a request body. A
+// body read past the limit of the server is too large rather than malformed.
+func Malformed(err error) *Error {
+	if strings.Contains(err.Error(), bodyTooLarge) {
+		return &Error{Kind: KindTooLarge, Code: CodeBodyTooLarge, Message: "Request body is too large", Err: err}
+	}
+	return &Error{Kind: KindBadRequest, Code: CodeMalformedBody, Message: err.Error(), Err: err}
+}
+
+// Invalid wraps the error returned by a Validate method. Errors that already
+// have a kind are returned as they are.
+func Invalid(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{Kind: KindValidation, Code: CodeValidationFailed, Message: err.Error(), Err: err}
+}
+
+// From returns err as an *Error: errors that have a kind as they are, missing
+// records as not found, unique constraint violations as conflicts and anything
+// else as an internal error
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if gorm.IsRecordNotFoundError(err) {
+		return &Error{Kind: KindNotFound, Code: CodeNotFound, Message: "Resource not found", Err: err}
+	}
+	if isUniqueViolation(err) {
+		return &Error{Kind: KindConflict, Code: CodeDuplicate, Message: "A record with the same unique value already exists", Err: err}
+	}
+	return &Error{Kind: KindInternal, Code: CodeInternal, Message: "An unexpected error occurred", Err: err}
+}
+
+// isUniqueViolation reports whether err is a database refusing a row that
+// clashes with a unique index, as when two requests race to claim an email
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505" // unique_violation
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package apperr
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMalformedBodyPastLimitIsTooLarge(t *testing.T) {
+	var upload bytes.Buffer
+	form := multipart.NewWriter(&upload)
+	file, _ := form.CreateFormFile("file", "plan.pdf")
+	file.Write(bytes.Repeat([]byte("x"), 1024))
+	form.Close()
+
+	tests := []struct {
+		name string
+		read func(r *http.Request) error
+	}{
+		{"ReadAll", func(r *http.Request) error {
+			_, err := ioutil.ReadAll(r.Body)
+			return err
+		}},
+		{"multipart", func(r *http.Request) error {
+			reader, err := r.MultipartReader()
+			if err != nil {
+				return err
+			}
+			for {
+				part, err := reader.NextPart()
+				if err != nil {
+					return err
+				}
+				if _, err = ioutil.ReadAll(part); err != nil {
+					return err
+				}
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", bytes.NewReader(upload.Bytes()))
+			r.Header.Set("Content-Type", form.FormDataContentType())
+			r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, 100)
+
+			err := Malformed(test.read(r))
+			if err.Status() != http.StatusRequestEntityTooLarge || err.Code != CodeBodyTooLarge {
+				t.Errorf("got %d %s, want 413 %s", err.Status(), err.Code, CodeBodyTooLarge)
+			}
+		})
+	}
+}
+
+func TestMalformedBodyIsBadRequest(t *testing.T) {
+	var body map[string]interface{}
+	err := Malformed(json.NewDecoder(strings.NewReader("{")).Decode(&body))
+	if err.Status() != http.StatusBadRequest || err.Code != CodeMalformedBody {
+		t.Errorf("got %d %s, want 400 %s", err.Status(), err.Code, CodeMalformedBody)
+	}
+}
+
+// sqliteUniqueViolation inserts the same key twice into a SQLite table
+func sqliteUniqueViolation(t *testing.T) error {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.Exec("CREATE TABLE users (email varchar(100) UNIQUE)"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2 && err == nil; i++ {
+		_, err = db.Exec("INSERT INTO users (email) VALUES ('taken@example.com')")
+	}
+	return err
+}
+
+func TestFrom(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"kind kept", Conflict("email_taken", "taken"), http.StatusConflict, "email_taken"},
+		{"record not found", gorm.ErrRecordNotFound, http.StatusNotFound, CodeNotFound},
+		{"postgres unique violation", &pq.Error{Code: "23505"}, http.StatusConflict, CodeDuplicate},
+		{"postgres other error", &pq.Error{Code: "23503"}, http.StatusInternalServerError, CodeInternal},
+		{"sqlite unique violation", sqliteUniqueViolation(t), http.StatusConflict, CodeDuplicate},
+		{"anything else", errors.New("boom"), http.StatusInternalServerError, CodeInternal},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := From(test.err)
+			if err.Status() != test.status || err.Code != test.code {
+				t.Errorf("got %d %s, want %d %s", err.Status(), err.Code, test.status, test.code)
+			}
+		})
+	}
+}
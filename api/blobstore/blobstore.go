@@ -0,0 +1,100 @@
+// Package blobstore keeps the files users upload, such as house photos, and
+// tells clients where to download them. Files are stored under keys like
+// houses/12/3f9c0a.jpg; the local store keeps them in a directory the API
+// serves, the S3 store in a bucket of any S3-compatible service.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/khelechy/rielzapi/api/tracing"
+	"github.com/khelechy/rielzapi/config"
+)
+
+// ErrInvalidKey is returned for keys that are empty, absolute or climb out of
+// the store with ..
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// BlobStore saves, deletes and links to files
+type BlobStore interface {
+	// Put saves size bytes read from body under key, replacing any file there
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Delete removes the file under key. Deleting a missing file is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL is where clients download the file under key
+	URL(key string) string
+}
+
+// checkKey rejects keys that could reach outside the store
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// joinURL appends a key to a base URL
+func joinURL(base, key string) string {
+	return strings.TrimRight(base, "/") + "/" + key
+}
+
+// Traced records a span, a child of any span in the context passed, for each
+// file saved to or deleted from s
+func Traced(s BlobStore) BlobStore {
+	return tracedStore{next: s}
+}
+
+type tracedStore struct {
+	next BlobStore
+}
+
+func (t tracedStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	ctx, span := tracing.Start(ctx, "BlobStore.Put", t.attributes(key)...)
+	span.SetAttributes(attribute.Int64("blob.size", size), attribute.String("blob.content_type", contentType))
+	err := t.next.Put(ctx, key, body, size, contentType)
+	tracing.End(span, err)
+	return err
+}
+
+func (t tracedStore) Delete(ctx context.Context, key string) error {
+	ctx, span := tracing.Start(ctx, "BlobStore.Delete", t.attributes(key)...)
+	err := t.next.Delete(ctx, key)
+	tracing.End(span, err)
+	return err
+}
+
+func (t tracedStore) URL(key string) string {
+	return t.next.URL(key)
+}
+
+func (t tracedStore) attributes(key string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("blobstore", fmt.Sprintf("%T", t.next)),
+		attribute.String("blob.key", key),
+	}
+}
+
+// FromConfig builds the store selected by the storage settings
+func FromConfig(cfg config.Storage) (BlobStore, error) {
+	switch strings.ToLower(cfg.Driver) {
+	case "", "local":
+		return NewLocal(cfg.Dir, cfg.PublicURL), nil
+	case "s3":
+		return NewS3(S3Options{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PublicURL: cfg.PublicURL,
+		})
+	}
+	return nil, fmt.Errorf("unknown STORAGE_DRIVER %q, expected local or s3", cfg.Driver)
+}
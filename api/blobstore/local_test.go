@@ -0,0 +1,158 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingReader returns some data, then an error, like an upload cut off
+type failingReader struct {
+	data io.Reader
+}
+
+func (f failingReader) Read(p []byte) (int, error) {
+	n, err := f.data.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+// TestLocalPut checks that a file is replaced whole or not at all, and that no
+// temporary files are left behind either way
+func TestLocalPut(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocal(t.TempDir(), "")
+	key := "houses/12/front.jpg"
+
+	if err := store.Put(ctx, key, strings.NewReader("first"), 5, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, key, strings.NewReader("second"), 6, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, key, failingReader{strings.NewReader("thi")}, 5, "image/jpeg"); err == nil {
+		t.Fatal("a failed upload was saved")
+	}
+
+	if got := readFile(t, filepath.Join(store.Dir, "houses", "12", "front.jpg")); got != "second" {
+		t.Errorf("file holds %q, want the last complete upload", got)
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(store.Dir, "houses", "12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		names := []string{}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("directory holds %v, want only front.jpg", names)
+	}
+}
+
+func TestLocalDelete(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocal(t.TempDir(), "")
+	key := "houses/12/front.jpg"
+	if err := store.Put(ctx, key, strings.NewReader("photo"), 5, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(store.Dir, "houses", "12", "front.jpg")); !os.IsNotExist(err) {
+		t.Errorf("file is still there after the delete: %v", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("deleting a missing file returned %v", err)
+	}
+}
+
+func TestLocalURL(t *testing.T) {
+	tests := []struct {
+		publicURL string
+		want      string
+	}{
+		{"", "/media/houses/12/front.jpg"},
+		{"https://cdn.example.com/media", "https://cdn.example.com/media/houses/12/front.jpg"},
+		{"https://cdn.example.com/media/", "https://cdn.example.com/media/houses/12/front.jpg"},
+	}
+	for _, tt := range tests {
+		if got := NewLocal(t.TempDir(), tt.publicURL).URL("houses/12/front.jpg"); got != tt.want {
+			t.Errorf("URL under %q is %q, want %q", tt.publicURL, got, tt.want)
+		}
+	}
+}
+
+func TestLocalInvalidKeys(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocal(t.TempDir(), "")
+	for _, key := range []string{"", "/etc/passwd", "../secret.jpg", "houses/../../secret.jpg", "houses//front.jpg", ".."} {
+		if err := store.Put(ctx, key, strings.NewReader("x"), 1, "image/jpeg"); err != ErrInvalidKey {
+			t.Errorf("Put(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+		if err := store.Delete(ctx, key); err != ErrInvalidKey {
+			t.Errorf("Delete(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+// TestLocalServeHTTP serves saved files from the path their URL gives, mounted
+// under /media as the API does
+func TestLocalServeHTTP(t *testing.T) {
+	store := NewLocal(t.TempDir(), "")
+	if err := store.Put(context.Background(), "houses/12/front.jpg", strings.NewReader("photo"), 5, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(store.Dir, "houses", "12", ".upload-123"), []byte("half"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	handler := http.StripPrefix("/media", store)
+
+	tests := []struct {
+		path string
+		want int
+		body string
+	}{
+		{store.URL("houses/12/front.jpg"), http.StatusOK, "photo"},
+		{"/media/houses/12/back.jpg", http.StatusNotFound, ""},
+		{"/media/houses/12/.upload-123", http.StatusNotFound, ""},
+		{"/media/houses/12", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.want {
+			t.Errorf("GET %s: got status %d, want %d", tt.path, w.Code, tt.want)
+			continue
+		}
+		if tt.want != http.StatusOK {
+			continue
+		}
+		if got := w.Body.String(); got != tt.body {
+			t.Errorf("GET %s: got %q, want %q", tt.path, got, tt.body)
+		}
+		if got := w.Header().Get("Content-Type"); got != "image/jpeg" {
+			t.Errorf("GET %s: got Content-Type %q, want image/jpeg", tt.path, got)
+		}
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
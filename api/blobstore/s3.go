@@ -0,0 +1,82 @@
+package blobstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Options locate a bucket on an S3-compatible service
+type S3Options struct {
+	// Endpoint is the URL of the service, such as https://s3.amazonaws.com or
+	// http://localhost:9000 for MinIO
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PublicURL is the base URL of the files, such as a CDN in front of the
+	// bucket; empty links them to the bucket itself, which must then allow
+	// public reads
+	PublicURL string
+}
+
+// S3 keeps files in a bucket, addressed by path so that MinIO and other
+// services without per-bucket host names work too
+type S3 struct {
+	client    *minio.Client
+	bucket    string
+	publicURL string
+}
+
+// NewS3 connects to the bucket. Nothing is sent until the first file is saved.
+func NewS3(options S3Options) (*S3, error) {
+	endpoint, err := url.Parse(options.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("S3 endpoint %q is not a URL", options.Endpoint)
+	}
+	client, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(options.AccessKey, options.SecretKey, ""),
+		Secure:       endpoint.Scheme == "https",
+		Region:       options.Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	publicURL := options.PublicURL
+	if publicURL == "" {
+		publicURL = joinURL(endpoint.Scheme+"://"+endpoint.Host, url.PathEscape(options.Bucket))
+	}
+	return &S3{client: client, bucket: options.Bucket, publicURL: publicURL}, nil
+}
+
+// Put uploads the file. Keys are never reused, so it may be cached for good.
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	_, err := s.client.PutObject(ctx, s.bucket, key, body, size, minio.PutObjectOptions{
+		ContentType:  contentType,
+		CacheControl: "public, max-age=31536000, immutable",
+	})
+	return err
+}
+
+// Delete removes the file
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+// URL links the file under the public URL or in the bucket
+func (s *S3) URL(key string) string {
+	return joinURL(s.publicURL, key)
+}
@@ -0,0 +1,206 @@
+package blobstore
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// s3StandIn answers the few S3 calls the store makes, keeping objects in memory
+type s3StandIn struct {
+	mu      sync.Mutex
+	objects map[string]s3Object // by path, /bucket/key
+}
+
+type s3Object struct {
+	body         string
+	contentType  string
+	cacheControl string
+}
+
+func newS3StandIn(t *testing.T) (*s3StandIn, *httptest.Server) {
+	standIn := &s3StandIn{objects: map[string]s3Object{}}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+	return standIn, server
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the bucket allows public reads, as the store's URLs need
+	if r.Method != "GET" && !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		s3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	switch r.Method {
+	case "PUT":
+		body, err := readS3Body(r)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.objects[r.URL.Path] = s3Object{body, r.Header.Get("Content-Type"), r.Header.Get("Cache-Control")}
+		w.Header().Set("ETag", `"etag"`)
+	case "DELETE":
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	case "GET":
+		object, ok := s.objects[r.URL.Path]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", object.contentType)
+		io.WriteString(w, object.body)
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *s3StandIn) object(path string) (s3Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[path]
+	return object, ok
+}
+
+// readS3Body reads an upload, which over plain HTTP comes in signed chunks of
+// the form <size in hex>;chunk-signature=<signature>\r\n<data>\r\n
+func readS3Body(r *http.Request) (string, error) {
+	if r.Header.Get("X-Amz-Content-Sha256") != "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+		body, err := ioutil.ReadAll(r.Body)
+		return string(body), err
+	}
+	reader := bufio.NewReader(r.Body)
+	var body strings.Builder
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		size, err := strconv.ParseInt(strings.SplitN(header, ";", 2)[0], 16, 64)
+		if err != nil {
+			return "", err
+		}
+		if _, err = io.CopyN(&body, reader, size); err != nil {
+			return "", err
+		}
+		if _, err = reader.Discard(2); err != nil { // \r\n after the data
+			return "", err
+		}
+		if size == 0 {
+			return body.String(), nil
+		}
+	}
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+func newTestS3(t *testing.T, endpoint, accessKey, publicURL string) *S3 {
+	t.Helper()
+	store, err := NewS3(S3Options{Endpoint: endpoint, Region: "us-east-1", Bucket: "rielz", AccessKey: accessKey, SecretKey: "secret", PublicURL: publicURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// TestS3PutDelete saves a file to the bucket, downloads it from its URL and
+// deletes it again
+func TestS3PutDelete(t *testing.T) {
+	ctx := context.Background()
+	standIn, server := newS3StandIn(t)
+	store := newTestS3(t, server.URL, "access", "")
+	key := "houses/12/front.jpg"
+
+	if err := store.Put(ctx, key, strings.NewReader("photo"), 5, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	object, ok := standIn.object("/rielz/houses/12/front.jpg")
+	if !ok {
+		t.Fatal("file was not saved in the bucket")
+	}
+	if object.body != "photo" || object.contentType != "image/jpeg" {
+		t.Errorf("bucket holds %q as %q, want \"photo\" as image/jpeg", object.body, object.contentType)
+	}
+	if object.cacheControl != "public, max-age=31536000, immutable" {
+		t.Errorf("file is cached with %q, want for good", object.cacheControl)
+	}
+
+	resp, err := http.Get(store.URL(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "photo" {
+		t.Errorf("GET %s: got %d %q, want the photo", store.URL(key), resp.StatusCode, body)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := standIn.object("/rielz/houses/12/front.jpg"); ok {
+		t.Error("file is still in the bucket after the delete")
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("deleting a missing file returned %v", err)
+	}
+}
+
+func TestS3Errors(t *testing.T) {
+	ctx := context.Background()
+	_, server := newS3StandIn(t)
+
+	denied := newTestS3(t, server.URL, "intruder", "")
+	if err := denied.Put(ctx, "houses/12/front.jpg", strings.NewReader("photo"), 5, "image/jpeg"); err == nil {
+		t.Error("upload with the wrong credentials succeeded")
+	}
+	if err := denied.Delete(ctx, "houses/12/front.jpg"); err == nil {
+		t.Error("delete with the wrong credentials succeeded")
+	}
+
+	store := newTestS3(t, server.URL, "access", "")
+	for _, key := range []string{"", "/etc/passwd", "../secret.jpg"} {
+		if err := store.Put(ctx, key, strings.NewReader("x"), 1, "image/jpeg"); err != ErrInvalidKey {
+			t.Errorf("Put(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+		if err := store.Delete(ctx, key); err != ErrInvalidKey {
+			t.Errorf("Delete(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+	}
+
+	if _, err := NewS3(S3Options{Endpoint: "localhost:9000", Bucket: "rielz"}); err == nil {
+		t.Error("endpoint without a scheme was accepted")
+	}
+}
+
+func TestS3URL(t *testing.T) {
+	tests := []struct {
+		endpoint  string
+		publicURL string
+		want      string
+	}{
+		{"http://localhost:9000", "", "http://localhost:9000/rielz/houses/12/front.jpg"},
+		{"https://s3.amazonaws.com/", "", "https://s3.amazonaws.com/rielz/houses/12/front.jpg"},
+		{"https://s3.amazonaws.com", "https://cdn.example.com/", "https://cdn.example.com/houses/12/front.jpg"},
+	}
+	for _, tt := range tests {
+		if got := newTestS3(t, tt.endpoint, "access", tt.publicURL).URL("houses/12/front.jpg"); got != tt.want {
+			t.Errorf("URL for %s under %q is %q, want %q", tt.endpoint, tt.publicURL, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Package commands is the rielz command line: it serves the API and runs the
+// operational tasks that would otherwise need SQL against the database.
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"github.com/khelechy/rielzapi/api/controllers"
+	"github.com/khelechy/rielzapi/api/logging"
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/repository"
+	"github.com/khelechy/rielzapi/api/tracing"
+	"github.com/khelechy/rielzapi/config"
+	"github.com/khelechy/rielzapi/utils"
+)
+
+// App returns the rielz command line application
+func App() *cli.App {
+	return &cli.App{
+		Name:    "rielz",
+		Usage:   "serve and administer the Rielz API",
+		Version: utils.Build().Version,
+		Action:  serve, // plain `rielz` keeps starting the server
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "config", Aliases: []string{"c"}, EnvVars: []string{"CONFIG_FILE"}, Usage: "YAML or TOML file to read settings from"},
+		},
+		Commands: []*cli.Command{
+			serveCommand,
+			migrateCommand,
+			seedCommand,
+			userCommand,
+			houseCommand,
+			tokenCommand,
+			configCommand,
+		},
+	}
+}
+
+var serveCommand = &cli.Command{
+	Name:   "serve",
+	Usage:  "migrate the database and serve the API",
+	Action: serve,
+}
+
+func serve(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+
+	logger, err := logging.New(cfg.Log)
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+
+	flushTraces, err := tracing.Setup(c.Context, cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := flushTraces(ctx); err != nil {
+			logger.Warn("cannot flush traces", zap.Error(err))
+		}
+	}()
+
+	app := controllers.App{}
+	app.Initialize(cfg, logger)
+
+	return app.RunServer()
+}
+
+// loadConfig reads the settings from the --config file and the environment and
+// refuses any the API cannot run with
+func loadConfig(c *cli.Context) (*config.Config, error) {
+	cfg, err := config.Load(c.String("config"))
+	if err != nil {
+		return nil, err
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// openStore opens the configured store. Database logs go to stderr so they
+// never mix with what a command prints.
+func openStore(c *cli.Context) (*repository.Store, error) {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	store, err := repository.Open(cfg.Database)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to database: %v", err)
+	}
+	logger, err := logging.New(cfg.Log)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	store.UseLogger(logger)
+	return store, nil
+}
+
+// withStore runs a command against a migrated store and closes it afterwards
+func withStore(action func(c *cli.Context, store *repository.Store) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		store, err := openStore(c)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		if err = store.Migrate(); err != nil {
+			return fmt.Errorf("cannot migrate database: %v", err)
+		}
+		return action(c, store)
+	}
+}
+
+// findUser looks a user up by the --email or --id flag of a command
+func findUser(c *cli.Context, store *repository.Store) (*models.User, error) {
+	var (
+		user *models.User
+		err  error
+	)
+	switch {
+	case c.String("email") != "":
+		user, err = store.Users.GetUserByEmail(c.String("email"))
+	case c.Int("id") > 0:
+		user, err = store.Users.GetUserById(c.Int("id"))
+	default:
+		return nil, errors.New("either --email or --id is required")
+	}
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, errors.New("user not found")
+	}
+	return user, err
+}
+
+// userFlags select the user a command works on
+var userFlags = []cli.Flag{
+	&cli.StringFlag{Name: "email", Usage: "email of the user"},
+	&cli.IntFlag{Name: "id", Usage: "id of the user"},
+}
+
+// passwordOrGenerate returns the --password flag, or a random password when it is empty
+func passwordOrGenerate(c *cli.Context) (password string, generated bool, err error) {
+	if password = c.String("password"); password != "" {
+		return password, false, nil
+	}
+	password, _, err = utils.NewOpaqueToken()
+	return password, true, err
+}
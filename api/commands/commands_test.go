@@ -0,0 +1,175 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/utils"
+)
+
+// useSQLite points the commands at a new SQLite database for the rest of the test
+func useSQLite(t *testing.T) {
+	t.Helper()
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_NAME", filepath.Join(t.TempDir(), "rielz.db"))
+	t.Setenv("SECRET", "0123456789012345678901234567890123456789")
+	t.Setenv("LOG_LEVEL", "error")
+}
+
+// run runs the command line with args, returning what it printed to stdout
+// and to stderr
+func run(t *testing.T, args ...string) (string, string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	app := App()
+	app.Writer, app.ErrWriter = &stdout, &stderr
+	app.ExitErrHandler = func(*cli.Context, error) {} // keep failures from exiting the test binary
+	err := app.Run(append([]string{"rielz"}, args...))
+	return stdout.String(), stderr.String(), err
+}
+
+// mustRun runs the command line and fails the test if the command fails
+func mustRun(t *testing.T, args ...string) string {
+	t.Helper()
+	stdout, stderr, err := run(t, args...)
+	if err != nil {
+		t.Fatalf("rielz %s: %v\n%s", strings.Join(args, " "), err, stderr)
+	}
+	return stdout
+}
+
+func TestMigrate(t *testing.T) {
+	useSQLite(t)
+
+	applied := mustRun(t, "migrate", "up")
+	if !strings.HasPrefix(applied, "applied 0001_baseline\n") {
+		t.Errorf("migrate up printed %q", applied)
+	}
+	if out := mustRun(t, "migrate", "up"); out != "schema is up to date\n" {
+		t.Errorf("second migrate up printed %q", out)
+	}
+
+	if out := mustRun(t, "migrate", "down", "2"); strings.Count(out, "rolled back ") != 2 {
+		t.Errorf("migrate down 2 printed %q", out)
+	}
+	status := mustRun(t, "migrate", "status")
+	if lines := strings.Split(strings.TrimSpace(status), "\n"); len(lines) != strings.Count(applied, "\n") || strings.Count(status, " pending") != 2 {
+		t.Errorf("status after rolling back two migrations is\n%s", status)
+	}
+	if _, _, err := run(t, "migrate", "down", "none"); err == nil {
+		t.Error("migrate down accepted steps that are not a number")
+	}
+}
+
+func TestUserCommands(t *testing.T) {
+	useSQLite(t)
+
+	out := mustRun(t, "user", "create", "--email", "admin@example.com", "--firstname", "Ada", "--lastname", "Admin", "--phone", "+2348012345678", "--role", models.RoleAdmin)
+	if !strings.HasPrefix(out, "created admin 1 <admin@example.com>\npassword: ") {
+		t.Errorf("user create printed %q", out)
+	}
+	if _, _, err := run(t, "user", "create", "--email", "admin@example.com", "--firstname", "Ada", "--lastname", "Admin", "--phone", "+2348012345678"); err == nil {
+		t.Error("a second user with the same email was created")
+	}
+	if _, _, err := run(t, "user", "create", "--email", "not an email", "--firstname", "Ada", "--lastname", "Admin", "--phone", "+2348012345678"); err == nil {
+		t.Error("a user with an invalid email was created")
+	}
+
+	if out = mustRun(t, "user", "reset-password", "--email", "admin@example.com", "--password", "newpassword456"); out != "password of admin@example.com reset, every session ended\n" {
+		t.Errorf("reset-password printed %q", out)
+	}
+	if _, _, err := run(t, "user", "reset-password", "--email", "nobody@example.com"); err == nil || err.Error() != "user not found" {
+		t.Errorf("reset-password of an unknown user returned %v", err)
+	}
+	if _, _, err := run(t, "user", "reset-password"); err == nil {
+		t.Error("reset-password ran without --email or --id")
+	}
+
+	out = mustRun(t, "token", "issue", "--id", "1")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	claims, err := utils.ParseAuthToken(lines[len(lines)-1])
+	if err != nil {
+		t.Fatalf("issued token does not verify: %v\n%s", err, out)
+	}
+	if claims.UserID != 1 || claims.Role != models.RoleAdmin {
+		t.Errorf("token is for user %d as %s, want user 1 as admin", claims.UserID, claims.Role)
+	}
+}
+
+func TestHouseImportExport(t *testing.T) {
+	useSQLite(t)
+	mustRun(t, "user", "create", "--email", "landlord@example.com", "--firstname", "Lara", "--lastname", "Landlord", "--phone", "+2348012345678")
+
+	file := filepath.Join(t.TempDir(), "houses.json")
+	write := func(body string) {
+		if err := ioutil.WriteFile(file, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flat := `{"house_type":"Flat","state":"Lagos","description":"Two bedroom flat","location":"Yaba","rooms":2,"available_rooms":0,"bathrooms":1,"price":1200000,"long_lat":"3.3792,6.5095","amenities":[{"slug":"water"}]}`
+	bungalow := `{"house_type":"Bungalow","state":"Abuja","description":"Three bedroom bungalow","location":"Gwarinpa","rooms":3,"bathrooms":3,"price":2500000,"long_lat":"7.4124,9.1099"}`
+
+	// nothing is imported from a file with a bad house in it
+	write("[" + flat + `,{"house_type":"Flat","state":"Lagos","rooms":2,"price":1200000,"long_lat":"north"}]`)
+	if _, _, err := run(t, "house", "import", "--landlord", "landlord@example.com", file); err == nil {
+		t.Error("a house without a description or location was imported")
+	}
+	write("[" + strings.Replace(flat, "water", "sauna", 1) + "]")
+	if _, _, err := run(t, "house", "import", "--landlord", "landlord@example.com", file); err == nil {
+		t.Error("a house with an unknown amenity was imported")
+	}
+	write("[" + flat + "]")
+	if _, _, err := run(t, "house", "import", file); err == nil {
+		t.Error("a house without a landlord was imported")
+	}
+	if out := mustRun(t, "house", "export"); strings.TrimSpace(out) != "[]" {
+		t.Fatalf("failed imports left houses behind: %s", out)
+	}
+
+	write("[" + flat + "," + bungalow + "]")
+	if _, stderr, err := run(t, "house", "import", "--landlord", "landlord@example.com", file); err != nil || stderr != "imported 2 houses\n" {
+		t.Fatalf("import returned %v, printed %q", err, stderr)
+	}
+
+	houses := []models.House{}
+	if err := json.Unmarshal([]byte(mustRun(t, "house", "export", "--state", "Lagos")), &houses); err != nil {
+		t.Fatal(err)
+	}
+	if len(houses) != 1 || houses[0].Location != "Yaba" {
+		t.Fatalf("export of Lagos holds %v, want the flat", houses)
+	}
+	if houses[0].AvailableRooms != houses[0].Rooms {
+		t.Errorf("imported flat has %d of %d rooms available, want all of them", houses[0].AvailableRooms, houses[0].Rooms)
+	}
+	if len(houses[0].Amenities) != 1 || houses[0].Amenities[0].Slug != "water" {
+		t.Errorf("imported flat has amenities %v, want water", houses[0].Amenities)
+	}
+
+	exported := filepath.Join(t.TempDir(), "export.json")
+	mustRun(t, "house", "export", "--landlord", "landlord@example.com", exported)
+	body, err := ioutil.ReadFile(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(body, &houses); err != nil || len(houses) != 2 {
+		t.Errorf("export of the landlord holds %d houses, %v; want 2", len(houses), err)
+	}
+}
+
+// TestSeedTwice checks that seeding an already seeded database changes nothing
+func TestSeedTwice(t *testing.T) {
+	useSQLite(t)
+	if out := mustRun(t, "seed"); !strings.Contains(out, "created landlord landlord@rielzapi.local") {
+		t.Errorf("seed printed %q", out)
+	}
+	if out := mustRun(t, "seed"); strings.Contains(out, "created") {
+		t.Errorf("second seed printed %q, want nothing created", out)
+	}
+}
@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/khelechy/rielzapi/config"
+)
+
+var configCommand = &cli.Command{
+	Name:  "config",
+	Usage: "inspect the settings the API runs with",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "print",
+			Usage: "print the effective settings as YAML and report any that are invalid",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "redacted", Usage: "mask passwords and secrets"},
+			},
+			Action: printConfig,
+		},
+	},
+}
+
+func printConfig(c *cli.Context) error {
+	cfg, err := config.Load(c.String("config"))
+	if err != nil {
+		return err
+	}
+	shown := cfg
+	if c.Bool("redacted") {
+		shown = cfg.Redacted()
+	}
+	out, err := shown.YAML()
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(c.App.Writer, string(out))
+	return cfg.Validate()
+}
@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/jinzhu/gorm"
+	"github.com/urfave/cli/v2"
+
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/repository"
+)
+
+var houseCommand = &cli.Command{
+	Name:  "house",
+	Usage: "move house listings in and out as JSON",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "import",
+			Usage:     "create the houses in a JSON array, validating each like the API does",
+			ArgsUsage: "<file|->",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "landlord", Usage: "email of the landlord to list every house under, instead of each house's user_id"},
+			},
+			Action: withStore(importHouses),
+		},
+		{
+			Name:      "export",
+			Usage:     "write houses as a JSON array",
+			ArgsUsage: "[file|-]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "landlord", Usage: "only export the houses of the landlord with this email"},
+				&cli.StringFlag{Name: "state", Usage: "only export houses in this state"},
+			},
+			Action: withStore(exportHouses),
+		},
+	},
+}
+
+// landlordID resolves the --landlord flag to a user id, 0 when it is not set
+func landlordID(c *cli.Context, store *repository.Store) (uint, error) {
+	if c.String("landlord") == "" {
+		return 0, nil
+	}
+	landlord, err := store.Users.GetUserByEmail(c.String("landlord"))
+	if gorm.IsRecordNotFoundError(err) {
+		return 0, fmt.Errorf("landlord %s not found", c.String("landlord"))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return landlord.ID, nil
+}
+
+func importHouses(c *cli.Context, store *repository.Store) error {
+	if !c.Args().Present() {
+		return fmt.Errorf("usage: %s house import <file|->", c.App.Name)
+	}
+	var (
+		body []byte
+		err  error
+	)
+	if name := c.Args().First(); name == "-" {
+		body, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		body, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	houses := []models.House{}
+	if err = json.Unmarshal(body, &houses); err != nil {
+		return fmt.Errorf("houses must be a JSON array: %v", err)
+	}
+	owner, err := landlordID(c, store)
+	if err != nil {
+		return err
+	}
+
+	catalog, err := store.Amenities.GetAmenities()
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, amenity := range *catalog {
+		known[amenity.Slug] = true
+	}
+
+	// validate everything first so a bad row does not leave a half imported file
+	for i := range houses {
+		house := &houses[i]
+		house.Model = gorm.Model{}
+		house.Prepare()
+		if err = house.Validate(); err != nil {
+			return fmt.Errorf("house %d: %v", i+1, err)
+		}
+		for _, amenity := range house.Amenities {
+			if !known[amenity.Slug] {
+				return fmt.Errorf("house %d: unknown amenity %s", i+1, amenity.Slug)
+			}
+		}
+		if owner != 0 {
+			house.UserID = owner
+		}
+		if house.UserID == 0 {
+			return fmt.Errorf("house %d: user_id is required without --landlord", i+1)
+		}
+		if _, err = store.Users.GetUserById(int(house.UserID)); gorm.IsRecordNotFoundError(err) {
+			return fmt.Errorf("house %d: landlord %d not found", i+1, house.UserID)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	for i := range houses {
+		if _, err = store.Houses.SaveHouse(&houses[i]); err != nil {
+			return fmt.Errorf("house %d: %v", i+1, err)
+		}
+	}
+	fmt.Fprintf(c.App.ErrWriter, "imported %d houses\n", len(houses))
+	return nil
+}
+
+func exportHouses(c *cli.Context, store *repository.Store) error {
+	owner, err := landlordID(c, store)
+	if err != nil {
+		return err
+	}
+
+	var houses *[]models.House
+	if owner != 0 {
+		houses, err = store.Houses.GetHousesByLandlord(owner)
+	} else {
+		houses, _, err = store.Houses.GetHouses(models.HouseFilter{State: c.String("state")})
+	}
+	if err != nil {
+		return err
+	}
+	selected := []models.House{}
+	for _, house := range *houses {
+		if c.String("state") == "" || house.State == c.String("state") {
+			selected = append(selected, house)
+		}
+	}
+
+	body, err := json.MarshalIndent(selected, "", "  ")
+	if err != nil {
+		return err
+	}
+	body = append(body, '\n')
+
+	var out io.Writer = c.App.Writer
+	if name := c.Args().First(); name != "" && name != "-" {
+		file, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	if _, err = out.Write(body); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "exported %d houses\n", len(selected))
+	return nil
+}
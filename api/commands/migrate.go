@@ -0,0 +1,112 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/khelechy/rielzapi/api/migrations"
+)
+
+var migrateCommand = &cli.Command{
+	Name:  "migrate",
+	Usage: "apply, roll back and create schema migrations",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "up",
+			Usage:  "apply every pending migration",
+			Action: withMigrator(migrateUp),
+		},
+		{
+			Name:      "down",
+			Usage:     "roll back the last applied migrations",
+			ArgsUsage: "[steps]",
+			Action:    withMigrator(migrateDown),
+		},
+		{
+			Name:   "status",
+			Usage:  "list applied and pending migrations",
+			Action: withMigrator(migrateStatus),
+		},
+		{
+			Name:      "create",
+			Usage:     "write empty up and down scripts for a new migration",
+			ArgsUsage: "<name>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "dir", Value: "api/migrations", Usage: "directory holding the migrations of each dialect"},
+			},
+			Action: migrateCreate,
+		},
+	},
+}
+
+// withMigrator runs a migrate subcommand against the configured database
+func withMigrator(action func(c *cli.Context, migrator *migrations.Migrator) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		store, err := openStore(c)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		migrator, err := migrations.New(store.DB)
+		if err != nil {
+			return err
+		}
+		return action(c, migrator)
+	}
+}
+
+func migrateUp(c *cli.Context, migrator *migrations.Migrator) error {
+	applied, err := migrator.Up()
+	for _, m := range applied {
+		fmt.Fprintf(c.App.Writer, "applied %04d_%s\n", m.Version, m.Name)
+	}
+	if err == nil && len(applied) == 0 {
+		fmt.Fprintln(c.App.Writer, "schema is up to date")
+	}
+	return err
+}
+
+func migrateDown(c *cli.Context, migrator *migrations.Migrator) error {
+	steps := 1
+	if c.Args().Present() {
+		var err error
+		if steps, err = strconv.Atoi(c.Args().First()); err != nil || steps < 1 {
+			return fmt.Errorf("steps must be a positive number, got %q", c.Args().First())
+		}
+	}
+	rolledBack, err := migrator.Down(steps)
+	for _, m := range rolledBack {
+		fmt.Fprintf(c.App.Writer, "rolled back %04d_%s\n", m.Version, m.Name)
+	}
+	return err
+}
+
+func migrateStatus(c *cli.Context, migrator *migrations.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		state := "pending"
+		if status.AppliedAt != nil {
+			state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(c.App.Writer, "%04d_%-40s %s\n", status.Version, status.Name, state)
+	}
+	return nil
+}
+
+// migrateCreate works on the source tree and needs no database
+func migrateCreate(c *cli.Context) error {
+	if !c.Args().Present() {
+		return fmt.Errorf("usage: %s migrate create <name>", c.App.Name)
+	}
+	paths, err := migrations.Create(c.String("dir"), c.Args().First())
+	for _, path := range paths {
+		fmt.Fprintln(c.App.Writer, "created", path)
+	}
+	return err
+}
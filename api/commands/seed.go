@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/urfave/cli/v2"
+
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/repository"
+)
+
+var seedCommand = &cli.Command{
+	Name:  "seed",
+	Usage: "fill a development database with sample users, houses and a tenant",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "password", Value: "password123", Usage: "password of every sample user"},
+	},
+	Action: withStore(seed),
+}
+
+// seedUsers are the sample accounts, one for each role that manages or rents houses
+var seedUsers = []models.User{
+	{Email: "landlord@rielzapi.local", FirstName: "Lara", LastName: "Landlord", PhoneNumber: "+2348000000001", Role: models.RoleLandlord},
+	{Email: "agent@rielzapi.local", FirstName: "Ade", LastName: "Agent", PhoneNumber: "+2348000000002", Role: models.RoleAgent},
+	{Email: "tenant@rielzapi.local", FirstName: "Tobi", LastName: "Tenant", PhoneNumber: "+2348000000003", Role: models.RoleTenant},
+}
+
+// seedHouses are listed by the sample landlord
+var seedHouses = []models.House{
+	{HouseType: "Duplex", State: "Lagos", Description: "Four bedroom duplex with a garden", Location: "Lekki Phase 1", Rooms: 4, AvailableRooms: 4, BathRooms: 5, Price: 4500000, LongLat: "3.4738,6.4474", Amenities: seedAmenities("parking", "generator", "security", "gated")},
+	{HouseType: "Flat", State: "Lagos", Description: "Two bedroom flat close to the mall", Location: "Yaba", Rooms: 2, AvailableRooms: 2, BathRooms: 2, Price: 1200000, LongLat: "3.3792,6.5095", Amenities: seedAmenities("water", "electricity", "internet")},
+	{HouseType: "Bungalow", State: "Abuja", Description: "Three bedroom bungalow in a quiet estate", Location: "Gwarinpa", Rooms: 3, AvailableRooms: 3, BathRooms: 3, Price: 2500000, LongLat: "7.4124,9.1099", Amenities: seedAmenities("parking", "water", "pets_allowed")},
+}
+
+// seedAmenities names amenities of the starter catalog by slug
+func seedAmenities(slugs ...string) []models.Amenity {
+	amenities := []models.Amenity{}
+	for _, slug := range slugs {
+		amenities = append(amenities, models.Amenity{Slug: slug})
+	}
+	return amenities
+}
+
+// seed creates whatever sample data is missing, so running it twice changes nothing
+func seed(c *cli.Context, store *repository.Store) error {
+	users := map[string]*models.User{}
+	for i := range seedUsers {
+		user, err := store.Users.GetUserByEmail(seedUsers[i].Email)
+		if gorm.IsRecordNotFoundError(err) {
+			sample := seedUsers[i]
+			sample.Password = c.String("password")
+			if user, err = store.Users.SaveUser(&sample); err == nil {
+				fmt.Fprintf(c.App.Writer, "created %s %s\n", user.Role, user.Email)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		users[user.Role] = user
+	}
+
+	landlord, agent, tenant := users[models.RoleLandlord], users[models.RoleAgent], users[models.RoleTenant]
+	if _, err := store.Users.LinkAgent(agent.ID, landlord.ID); err != nil {
+		return err
+	}
+
+	houses, err := store.Houses.GetHousesByLandlord(landlord.ID)
+	if err != nil {
+		return err
+	}
+	if len(*houses) == 0 {
+		for _, sample := range seedHouses {
+			house := sample
+			house.UserID = landlord.ID
+			if err = house.ParseLongLat(); err != nil {
+				return err
+			}
+			if _, err = store.Houses.SaveHouse(&house); err != nil {
+				return err
+			}
+			*houses = append(*houses, house)
+			fmt.Fprintf(c.App.Writer, "created house %d in %s\n", house.ID, house.Location)
+		}
+	}
+
+	leases, err := store.Tenants.GetLeasesByTenantEmail(tenant.Email)
+	if err != nil {
+		return err
+	}
+	if len(*leases) == 0 {
+		home := (*houses)[0]
+		lease := &models.Lease{RentAmount: home.Price}
+		lease.Prepare()
+		_, _, err = store.Tenants.AddTenant(&models.Tenant{
+			Email:       tenant.Email,
+			FirstName:   tenant.FirstName,
+			LastName:    tenant.LastName,
+			PhoneNumber: tenant.PhoneNumber,
+			HouseId:     int(home.ID),
+		}, lease)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.Writer, "leased a room in house %d to %s\n", home.ID, tenant.Email)
+	}
+	return nil
+}
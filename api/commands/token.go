@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/khelechy/rielzapi/api/repository"
+	"github.com/khelechy/rielzapi/utils"
+)
+
+var tokenCommand = &cli.Command{
+	Name:  "token",
+	Usage: "issue API tokens",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "issue",
+			Usage: "print an access token for a user, signed with the configured keys",
+			Flags: append([]cli.Flag{
+				&cli.BoolFlag{Name: "refresh", Usage: "also start a session and print its refresh token"},
+			}, userFlags...),
+			Action: withStore(issueToken),
+		},
+	},
+}
+
+func issueToken(c *cli.Context, store *repository.Store) error {
+	user, err := findUser(c, store)
+	if err != nil {
+		return err
+	}
+	if user.Suspended {
+		return fmt.Errorf("%s is suspended", user.Email)
+	}
+
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	keys, err := utils.LoadKeyRing(cfg.Auth)
+	if err != nil {
+		return fmt.Errorf("cannot load token signing keys: %v", err)
+	}
+	utils.SetKeyRing(keys)
+
+	token, err := utils.EncodeAuthToken(user.ID, user.Role)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "access token (expires in %s):\n%s\n", utils.AccessTokenTTL, token)
+
+	if c.Bool("refresh") {
+		refreshToken, hash, err := utils.NewRefreshToken()
+		if err != nil {
+			return err
+		}
+		if _, err = store.Tokens.SaveRefreshToken(user.ID, hash, time.Now().Add(utils.RefreshTokenTTL)); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.Writer, "refresh token (expires in %s):\n%s\n", utils.RefreshTokenTTL, refreshToken)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/urfave/cli/v2"
+
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/repository"
+	"github.com/khelechy/rielzapi/utils"
+)
+
+var userCommand = &cli.Command{
+	Name:  "user",
+	Usage: "manage user accounts",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "create",
+			Usage: "create a user, printing a generated password when none is given",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "email", Required: true},
+				&cli.StringFlag{Name: "firstname", Required: true},
+				&cli.StringFlag{Name: "lastname", Required: true},
+				&cli.StringFlag{Name: "phone", Required: true, Usage: "phone number in international format, such as +2348012345678"},
+				&cli.StringFlag{Name: "role", Value: models.RoleLandlord, Usage: "admin, landlord, tenant or agent"},
+				&cli.StringFlag{Name: "password", Usage: "password of the user, generated when empty"},
+				&cli.BoolFlag{Name: "verified", Usage: "mark the email as already verified"},
+			},
+			Action: withStore(createUser),
+		},
+		{
+			Name:   "reset-password",
+			Usage:  "set a new password and end every session of the user",
+			Flags:  append([]cli.Flag{&cli.StringFlag{Name: "password", Usage: "new password, generated when empty"}}, userFlags...),
+			Action: withStore(resetPassword),
+		},
+	},
+}
+
+func createUser(c *cli.Context, store *repository.Store) error {
+	password, generated, err := passwordOrGenerate(c)
+	if err != nil {
+		return err
+	}
+
+	user := &models.User{
+		Email:       c.String("email"),
+		FirstName:   c.String("firstname"),
+		LastName:    c.String("lastname"),
+		PhoneNumber: c.String("phone"),
+		Role:        c.String("role"),
+		Password:    password,
+	}
+	user.Prepare()
+	if err = user.Validate(""); err != nil {
+		return err
+	}
+	if _, err = store.Users.GetUserByEmail(user.Email); err == nil {
+		return fmt.Errorf("%s is already registered", user.Email)
+	} else if !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+	if c.Bool("verified") {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	created, err := store.Users.SaveUser(user)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "created %s %d <%s>\n", created.Role, created.ID, created.Email)
+	if generated {
+		fmt.Fprintf(c.App.Writer, "password: %s\n", password)
+	}
+	return nil
+}
+
+// resetPassword goes through a single use reset token so that it revokes the
+// user's sessions exactly like the emailed reset link does
+func resetPassword(c *cli.Context, store *repository.Store) error {
+	user, err := findUser(c, store)
+	if err != nil {
+		return err
+	}
+	password, generated, err := passwordOrGenerate(c)
+	if err != nil {
+		return err
+	}
+
+	_, hash, err := utils.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	if _, err = store.Tokens.SaveUserToken(user.ID, user.Email, models.PasswordResetToken, hash, time.Now().Add(utils.PasswordResetTTL)); err != nil {
+		return err
+	}
+	if err = store.Tokens.ResetPassword(hash, password); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.App.Writer, "password of %s reset, every session ended\n", user.Email)
+	if generated {
+		fmt.Fprintf(c.App.Writer, "password: %s\n", password)
+	}
+	return nil
+}
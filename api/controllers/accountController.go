@@ -0,0 +1,194 @@
+package controllers
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "go.uber.org/zap"
+
+    "github.com/khelechy/rielzapi/api/apperr"
+    "github.com/khelechy/rielzapi/api/logging"
+    "github.com/khelechy/rielzapi/api/mailer"
+    "github.com/khelechy/rielzapi/api/models"
+    "github.com/khelechy/rielzapi/api/responses"
+    "github.com/khelechy/rielzapi/utils"
+)
+
+// accountRequest is the body of the password reset and email verification endpoints
+type accountRequest struct {
+    Email    string `json:"email"`
+    Token    string `json:"token"`
+    Password string `json:"password"`
+}
+
+// readAccountRequest decodes an account request, trimming its email and token
+func readAccountRequest(r *http.Request) (accountRequest, error) {
+    request := accountRequest{}
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        return request, err
+    }
+    if err = json.Unmarshal(body, &request); err != nil {
+        return request, err
+    }
+    request.Email = strings.TrimSpace(request.Email)
+    request.Token = strings.TrimSpace(request.Token)
+    request.Password = strings.TrimSpace(request.Password)
+    return request, nil
+}
+
+// appLink builds a link into the client app at APP_URL carrying a mailed token
+func (a *App) appLink(path, token string) string {
+    return strings.TrimRight(a.Config.AppURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendPasswordReset mails the user a link to choose a new password
+func (a *App) sendPasswordReset(r *http.Request, user *models.User) error {
+    token, hash, err := utils.NewOpaqueToken()
+    if err != nil {
+        return err
+    }
+    if _, err = a.tokens(r).SaveUserToken(user.ID, user.Email, models.PasswordResetToken, hash, time.Now().Add(utils.PasswordResetTTL)); err != nil {
+        return err
+    }
+    return a.mailer(r).Send(mailer.Message{
+        To:      user.Email,
+        Subject: "Reset your RielzAPI password",
+        Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It works once and expires in %v.\n\n%s\n\nIf you did not ask for this you can ignore this email.\n",
+            user.FirstName, utils.PasswordResetTTL, a.appLink("/password/reset", token)),
+    })
+}
+
+// sendEmailVerification mails the user a link that confirms they own their email
+func (a *App) sendEmailVerification(r *http.Request, user *models.User) error {
+    token, hash, err := utils.NewOpaqueToken()
+    if err != nil {
+        return err
+    }
+    if _, err = a.tokens(r).SaveUserToken(user.ID, user.Email, models.EmailVerificationToken, hash, time.Now().Add(utils.EmailVerificationTTL)); err != nil {
+        return err
+    }
+    return a.mailer(r).Send(mailer.Message{
+        To:      user.Email,
+        Subject: "Verify your RielzAPI email",
+        Body: fmt.Sprintf("Hi %s,\n\nUse the link below to verify your email. It expires in %v.\n\n%s\n",
+            user.FirstName, utils.EmailVerificationTTL, a.appLink("/email/verify", token)),
+    })
+}
+
+// ForgotPassword godoc
+// @Summary Email a password reset link
+// @Accept  json
+// @Produce  json
+// @Router /password/forgot [post]
+func (a *App) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+    // the same answer is given whether or not the email is registered
+    var resp = map[string]interface{}{"status": "success", "message": "If the email is registered, a reset link has been sent to it"}
+
+    request, err := readAccountRequest(r)
+    if err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+    if request.Email == "" {
+        responses.ERROR(w, r, apperr.InvalidField("email", "Email is required"))
+        return
+    }
+
+    user, _ := a.users(r).GetUserByEmail(request.Email)
+    if user != nil && !user.Suspended {
+        if err = a.sendPasswordReset(r, user); err != nil {
+            logging.FromContext(r.Context()).Error("cannot send password reset", zap.Uint("user_id", user.ID), zap.Error(err))
+        }
+    }
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// ResetPassword godoc
+// @Summary Set a new password with a mailed reset token, logging out every session
+// @Accept  json
+// @Produce  json
+// @Router /password/reset [post]
+func (a *App) ResetPassword(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Password has been reset, please login"}
+
+    request, err := readAccountRequest(r)
+    if err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+    if request.Token == "" {
+        responses.ERROR(w, r, apperr.InvalidField("token", "Token is required"))
+        return
+    }
+    if request.Password == "" {
+        responses.ERROR(w, r, apperr.InvalidField("password", "Password is required"))
+        return
+    }
+
+    err = a.tokens(r).ResetPassword(utils.HashToken(request.Token), request.Password)
+    if err != nil { // ErrInvalidUserToken answers 400
+        responses.ERROR(w, r, err)
+        return
+    }
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// VerifyEmail godoc
+// @Summary Confirm an email with a mailed verification token
+// @Accept  json
+// @Produce  json
+// @Router /email/verify [post]
+func (a *App) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Email verified"}
+
+    request, err := readAccountRequest(r)
+    if err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+    if request.Token == "" {
+        responses.ERROR(w, r, apperr.InvalidField("token", "Token is required"))
+        return
+    }
+
+    err = a.tokens(r).VerifyEmail(utils.HashToken(request.Token))
+    if err != nil { // ErrInvalidUserToken answers 400
+        responses.ERROR(w, r, err)
+        return
+    }
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// ResendVerification godoc
+// @Summary Email a new verification link to the logged in user
+// @Accept  json
+// @Produce  json
+// @Router /email/verify/resend [post]
+func (a *App) ResendVerification(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Verification email sent"}
+
+    user, ok := a.loadUser(w, r, int(a.currentActor(r).UserID))
+    if !ok {
+        return
+    }
+    if user.EmailVerified() {
+        responses.ERROR(w, r, apperr.Conflict("email_already_verified", "Email is already verified"))
+        return
+    }
+
+    if err := a.sendEmailVerification(r, user); err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
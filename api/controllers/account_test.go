@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/khelechy/rielzapi/api/mailer"
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/repository"
+)
+
+// TestVerificationLinkDoesNotFollowEmailChange checks that a link mailed to an
+// old address cannot verify the address the user changed to, while the link
+// mailed to the new address can
+func TestVerificationLinkDoesNotFollowEmailChange(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			a := newTestApp(t, store.open(t))
+			id, token := signUp(t, a, "old@example.com", models.RoleLandlord)
+			link := mailedToken(t, a, "old@example.com")
+
+			call(t, a, "PATCH", fmt.Sprintf("/api/users/%d", id), token, `{"email":"new@example.com"}`).expect(t, http.StatusOK)
+			resp := call(t, a, "POST", "/email/verify", "", fmt.Sprintf(`{"token":%q}`, link)).expect(t, http.StatusBadRequest)
+			if code := resp.str("code"); code != "invalid_token" {
+				t.Errorf("got code %q, want invalid_token", code)
+			}
+
+			link = mailedToken(t, a, "new@example.com")
+			call(t, a, "POST", "/email/verify", "", fmt.Sprintf(`{"token":%q}`, link)).expect(t, http.StatusOK)
+			me := call(t, a, "GET", fmt.Sprintf("/api/users/%d", id), token, "").expect(t, http.StatusOK)
+			if verified, _ := me.field("email_verified").(bool); !verified {
+				t.Errorf("new address is not verified: %s", me.Raw)
+			}
+		})
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	a := newTestApp(t, repository.NewMemoryStore())
+	signUp(t, a, "landlord@example.com", models.RoleLandlord)
+	before := login(t, a, "landlord@example.com")
+
+	mailed := len(a.Mailer.(*mailer.MemoryMailer).Messages())
+	call(t, a, "POST", "/password/forgot", "", `{"email":"nobody@example.com"}`).expect(t, http.StatusOK)
+	if sent := len(a.Mailer.(*mailer.MemoryMailer).Messages()) - mailed; sent != 0 {
+		t.Errorf("%d messages were sent for an unknown email, want none", sent)
+	}
+	call(t, a, "POST", "/password/forgot", "", `{"email":"landlord@example.com"}`).expect(t, http.StatusOK)
+	token := mailedToken(t, a, "landlord@example.com")
+
+	// run in order: the token works once
+	tests := []struct {
+		name string
+		body string
+		want int
+		code string
+	}{
+		{"without a token", `{"password":"newpassword456"}`, http.StatusUnprocessableEntity, ""},
+		{"with an unknown token", `{"token":"unknown","password":"newpassword456"}`, http.StatusBadRequest, "invalid_token"},
+		{"with the mailed token", fmt.Sprintf(`{"token":%q,"password":"newpassword456"}`, token), http.StatusOK, ""},
+		{"with the mailed token again", fmt.Sprintf(`{"token":%q,"password":"otherpassword789"}`, token), http.StatusBadRequest, "invalid_token"},
+	}
+	for _, tt := range tests {
+		resp := call(t, a, "POST", "/password/reset", "", tt.body)
+		if resp.Code != tt.want {
+			t.Fatalf("%s: got status %d, want %d: %s", tt.name, resp.Code, tt.want, resp.Raw)
+		}
+		if tt.code != "" && resp.str("code") != tt.code {
+			t.Fatalf("%s: got code %q, want %q", tt.name, resp.str("code"), tt.code)
+		}
+	}
+
+	call(t, a, "POST", "/login", "", `{"email":"landlord@example.com","password":"password123"}`).expect(t, http.StatusUnauthorized)
+	call(t, a, "POST", "/login", "", `{"email":"landlord@example.com","password":"newpassword456"}`).expect(t, http.StatusOK)
+	// the reset logs out the sessions opened with the old password
+	call(t, a, "POST", "/token/refresh", "", fmt.Sprintf(`{"refresh_token":%q}`, before.refresh)).expect(t, http.StatusUnauthorized)
+}
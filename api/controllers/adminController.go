@@ -0,0 +1,115 @@
+package controllers
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/gorilla/mux"
+
+    "github.com/khelechy/rielzapi/api/apperr"
+    "github.com/khelechy/rielzapi/api/models"
+    "github.com/khelechy/rielzapi/api/policies"
+    "github.com/khelechy/rielzapi/api/responses"
+)
+
+// SuspendUser godoc
+// @Summary Suspend a user so they can no longer log in
+// @Accept  json
+// @Produce  json
+// @Router /api/admin/users/{id}/suspend [post]
+func (a *App) SuspendUser(w http.ResponseWriter, r *http.Request) {
+    a.setSuspended(w, r, true, "User suspended successfully")
+}
+
+// ReinstateUser godoc
+// @Summary Lift the suspension of a user
+// @Accept  json
+// @Produce  json
+// @Router /api/admin/users/{id}/reinstate [post]
+func (a *App) ReinstateUser(w http.ResponseWriter, r *http.Request) {
+    a.setSuspended(w, r, false, "User reinstated successfully")
+}
+
+func (a *App) setSuspended(w http.ResponseWriter, r *http.Request, suspended bool, message string) {
+    var resp = map[string]interface{}{"status": "success", "message": message}
+
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+    user, ok := a.loadUser(w, r, id)
+    if !ok {
+        return
+    }
+    actor := a.currentActor(r)
+    if forbid(w, r, policies.CanModerateUsers(actor), "Unauthorized user moderation") {
+        return
+    }
+    if user.ID == actor.UserID {
+        responses.ERROR(w, r, apperr.Conflict("cannot_suspend_self", "You cannot change your own suspension"))
+        return
+    }
+
+    if err := a.users(r).SetSuspended(id, suspended); err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    if suspended {
+        if err := a.tokens(r).RevokeAllSessions(user.ID); err != nil {
+            responses.ERROR(w, r, err)
+            return
+        }
+    }
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// SetUserRole godoc
+// @Summary Change the role of a user, ending their sessions so no token carries the old role
+// @Accept  json
+// @Produce  json
+// @Router /api/admin/users/{id}/role [put]
+func (a *App) SetUserRole(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "User role updated successfully"}
+
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+    user, ok := a.loadUser(w, r, id)
+    if !ok {
+        return
+    }
+    if forbid(w, r, policies.CanModerateUsers(a.currentActor(r)), "Unauthorized user moderation") {
+        return
+    }
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+    update := struct {
+        Role string `json:"role"`
+    }{}
+    if err = json.Unmarshal(body, &update); err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+
+    role := strings.ToLower(strings.TrimSpace(update.Role))
+    if !models.ValidRole(role) {
+        responses.ERROR(w, r, apperr.InvalidField("role", "Role must be admin, landlord, tenant or agent"))
+        return
+    }
+
+    if err = a.users(r).SetRole(id, role); err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    if err = a.tokens(r).RevokeAllSessions(user.ID); err != nil { // access tokens carry the role
+        responses.ERROR(w, r, err)
+        return
+    }
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "strconv"
+
+    "github.com/gorilla/mux"
+
+    "github.com/khelechy/rielzapi/api/apperr"
+    "github.com/khelechy/rielzapi/api/dto"
+    "github.com/khelechy/rielzapi/api/models"
+    "github.com/khelechy/rielzapi/api/policies"
+    "github.com/khelechy/rielzapi/api/responses"
+)
+
+// landlordParam returns the landlord an agent request is about: the landlord_id
+// query parameter for admins and the logged in user for everybody else
+func landlordParam(r *http.Request, actor policies.Actor) uint {
+    if actor.IsAdmin() {
+        if id, err := strconv.Atoi(r.URL.Query().Get("landlord_id")); err == nil && id > 0 {
+            return uint(id)
+        }
+    }
+    return actor.UserID
+}
+
+// GetAgents godoc
+// @Summary List the agents linked to a landlord
+// @Accept  json
+// @Produce  json
+// @Param landlord_id query int false "Landlord to list agents for (admins only)"
+// @Router /api/agents [get]
+func (a *App) GetAgents(w http.ResponseWriter, r *http.Request) {
+    actor := a.currentActor(r)
+    landlordID := landlordParam(r, actor)
+    if forbid(w, r, policies.CanLinkAgent(actor, landlordID), "Unauthorized access to agents") {
+        return
+    }
+
+    agents, err := a.users(r).GetAgentsByLandlord(landlordID)
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    visibility := policies.VisibilityContact // landlords may contact their own agents
+    if actor.IsAdmin() {
+        visibility = policies.VisibilityAdmin
+    }
+    views := make([]interface{}, 0, len(*agents))
+    for i := range *agents {
+        views = append(views, dto.NewUser(&(*agents)[i], visibility))
+    }
+    responses.JSON(w, http.StatusOK, views)
+    return
+}
+
+// LinkAgent godoc
+// @Summary Let an agent manage the landlord's houses
+// @Accept  json
+// @Produce  json
+// @Param landlord_id query int false "Landlord to link the agent to (admins only)"
+// @Router /api/agents [post]
+func (a *App) LinkAgent(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Agent linked successfully"}
+
+    actor := a.currentActor(r)
+    landlordID := landlordParam(r, actor)
+    if forbid(w, r, policies.CanLinkAgent(actor, landlordID), "Unauthorized agent link") {
+        return
+    }
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+    request := struct {
+        AgentID int `json:"agent_id"`
+    }{}
+    if err = json.Unmarshal(body, &request); err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+
+    agent, ok := a.loadUser(w, r, request.AgentID)
+    if !ok {
+        return
+    }
+    if agent.Role != models.RoleAgent {
+        responses.ERROR(w, r, apperr.InvalidField("agent_id", "User is not an agent"))
+        return
+    }
+
+    link, err := a.users(r).LinkAgent(agent.ID, landlordID)
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    resp["link"] = link
+    responses.JSON(w, http.StatusCreated, resp)
+    return
+}
+
+// UnlinkAgent godoc
+// @Summary Stop an agent managing the landlord's houses
+// @Accept  json
+// @Produce  json
+// @Param landlord_id query int false "Landlord to unlink the agent from (admins only)"
+// @Router /api/agents/{id} [delete]
+func (a *App) UnlinkAgent(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Agent unlinked successfully"}
+
+    actor := a.currentActor(r)
+    landlordID := landlordParam(r, actor)
+    if forbid(w, r, policies.CanLinkAgent(actor, landlordID), "Unauthorized agent unlink") {
+        return
+    }
+
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+    if err := a.users(r).UnlinkAgent(uint(id), landlordID); err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
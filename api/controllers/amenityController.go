@@ -0,0 +1,134 @@
+package controllers
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "strconv"
+
+    "github.com/gorilla/mux"
+
+    "github.com/khelechy/rielzapi/api/apperr"
+    "github.com/khelechy/rielzapi/api/models"
+    "github.com/khelechy/rielzapi/api/policies"
+    "github.com/khelechy/rielzapi/api/responses"
+)
+
+// GetAmenities godoc
+// @Summary List the amenities houses can be listed and filtered with, by category then name
+// @Produce  json
+// @Router /api/amenities [get]
+func (a *App) GetAmenities(w http.ResponseWriter, r *http.Request) {
+    amenities, err := a.amenities(r).GetAmenities()
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    responses.JSON(w, http.StatusOK, amenities)
+    return
+}
+
+// readAmenity decodes, tidies and validates the amenity in the request body
+func readAmenity(r *http.Request) (*models.Amenity, error) {
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        return nil, apperr.Malformed(err)
+    }
+    amenity := &models.Amenity{}
+    if err = json.Unmarshal(body, amenity); err != nil {
+        return nil, apperr.Malformed(err)
+    }
+
+    amenity.Prepare()
+    if err = amenity.Validate(); err != nil {
+        return nil, apperr.Invalid(err)
+    }
+    return amenity, nil
+}
+
+// CreateAmenity godoc
+// @Summary Add an amenity to the catalog
+// @Accept  json
+// @Produce  json
+// @Router /api/admin/amenities [post]
+func (a *App) CreateAmenity(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Amenity created successfully"}
+
+    if forbid(w, r, policies.CanManageAmenities(a.currentActor(r)), "Unauthorized amenity change") {
+        return
+    }
+
+    amenity, err := readAmenity(r)
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    amenityCreated, err := a.amenities(r).SaveAmenity(amenity)
+    if err != nil { // ErrAmenityExists answers 409
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    resp["amenity"] = amenityCreated
+    responses.JSON(w, http.StatusCreated, resp)
+    return
+}
+
+// UpdateAmenity godoc
+// @Summary Change the slug, name or category of an amenity; houses listed with it keep it
+// @Accept  json
+// @Produce  json
+// @Router /api/admin/amenities/{id} [put]
+func (a *App) UpdateAmenity(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Amenity updated successfully"}
+
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+    if forbid(w, r, policies.CanManageAmenities(a.currentActor(r)), "Unauthorized amenity change") {
+        return
+    }
+    if _, ok := a.loadAmenity(w, r, id); !ok {
+        return
+    }
+
+    amenity, err := readAmenity(r)
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    amenityUpdated, err := a.amenities(r).UpdateAmenity(id, amenity)
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    resp["amenity"] = amenityUpdated
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// DeleteAmenity godoc
+// @Summary Remove an amenity from the catalog and from every house listed with it
+// @Produce  json
+// @Router /api/admin/amenities/{id} [delete]
+func (a *App) DeleteAmenity(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Amenity deleted successfully"}
+
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+    if forbid(w, r, policies.CanManageAmenities(a.currentActor(r)), "Unauthorized amenity change") {
+        return
+    }
+    if _, ok := a.loadAmenity(w, r, id); !ok {
+        return
+    }
+
+    if err := a.amenities(r).DeleteAmenity(id); err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
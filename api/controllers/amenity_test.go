@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/khelechy/rielzapi/api/models"
+)
+
+// houseWith is a valid house listed with the amenities named by slug
+func houseWith(slugs ...string) string {
+	named, _ := json.Marshal(slugs)
+	return strings.TrimSuffix(houseJSON(1), "}") + `,"amenities":` + string(named) + "}"
+}
+
+// listedHouses returns the ids of the houses GET path lists, in id order
+func listedHouses(t *testing.T, a *App, path string) []int {
+	t.Helper()
+	page := housePage{}
+	if err := json.Unmarshal([]byte(call(t, a, "GET", path, "", "").expect(t, http.StatusOK).Raw), &page); err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, house := range page.Data {
+		ids = append(ids, int(house.ID))
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// TestFilterHousesByAmenities checks that houses must have every amenity
+// named, however the list is written
+func TestFilterHousesByAmenities(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			a := newTestApp(t, store.open(t))
+			_, landlord := signUp(t, a, "landlord@example.com", models.RoleLandlord)
+			create := func(slugs ...string) int {
+				return call(t, a, "POST", "/api/houses", landlord, houseWith(slugs...)).expect(t, http.StatusCreated).num("house", "ID")
+			}
+			both := create("parking", "water")
+			parking := create("parking")
+			water := create("water", "gym")
+			create()
+
+			tests := []struct {
+				query string
+				want  []int
+			}{
+				{"amenities=parking", []int{both, parking}},
+				{"amenities=parking,water", []int{both}},
+				{"amenities=water,parking", []int{both}},
+				{"amenities=parking&amenities=water", []int{both}},
+				{"amenities=+parking+,,water,parking", []int{both}},
+				{"amenities=water,gym", []int{water}},
+				{"amenities=parking,gym", []int{}},
+				{"amenities=pets_allowed", []int{}},
+			}
+			for _, tt := range tests {
+				if got := listedHouses(t, a, "/api/houses?"+tt.query); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+					t.Errorf("%s lists houses %v, want %v", tt.query, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestAmenityChangesBumpHouseVersions checks that renaming or deleting an
+// amenity changes the ETag of the houses listed with it, and only theirs
+func TestAmenityChangesBumpHouseVersions(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			a := newTestApp(t, store.open(t))
+			_, landlord := signUp(t, a, "landlord@example.com", models.RoleLandlord)
+			_, admin := signUp(t, a, "admin@example.com", models.RoleAdmin)
+
+			created := call(t, a, "POST", "/api/admin/amenities", admin, `{"slug":"borehole","name":"Borehole","category":"utilities"}`).
+				expect(t, http.StatusCreated)
+			amenity := fmt.Sprintf("/api/admin/amenities/%d", created.num("amenity", "ID"))
+			call(t, a, "POST", "/api/admin/amenities", admin, `{"slug":"borehole","name":"Another borehole"}`).
+				expect(t, http.StatusConflict)
+			call(t, a, "POST", "/api/admin/amenities", landlord, `{"slug":"lift","name":"Lift"}`).
+				expect(t, http.StatusForbidden)
+
+			with := fmt.Sprintf("/api/houses/%d", call(t, a, "POST", "/api/houses", landlord, houseWith("borehole", "parking")).
+				expect(t, http.StatusCreated).num("house", "ID"))
+			without := fmt.Sprintf("/api/houses/%d", call(t, a, "POST", "/api/houses", landlord, houseWith("parking")).
+				expect(t, http.StatusCreated).num("house", "ID"))
+			etag := func(path string) string {
+				return call(t, a, "GET", path, "", "").expect(t, http.StatusOK).Header.Get("ETag")
+			}
+			slugs := func(path string) string {
+				house := models.House{}
+				if err := json.Unmarshal([]byte(call(t, a, "GET", path, "", "").Raw), &house); err != nil {
+					t.Fatal(err)
+				}
+				return strings.Join(models.AmenitySlugs(house.Amenities), ",")
+			}
+			before, untouched := etag(with), etag(without)
+
+			call(t, a, "PUT", amenity, admin, `{"slug":"water_well","name":"Water well","category":"utilities"}`).expect(t, http.StatusOK)
+			renamed := etag(with)
+			if renamed == before {
+				t.Errorf("house kept ETag %s after its amenity was renamed", before)
+			}
+			if got := slugs(with); got != "parking,water_well" {
+				t.Errorf("house lists amenities %s after the rename, want parking,water_well", got)
+			}
+
+			call(t, a, "DELETE", amenity, admin, "").expect(t, http.StatusOK)
+			if etag(with) == renamed {
+				t.Errorf("house kept ETag %s after its amenity was deleted", renamed)
+			}
+			if got := slugs(with); got != "parking" {
+				t.Errorf("house lists amenities %s after the delete, want parking", got)
+			}
+			call(t, a, "DELETE", amenity, admin, "").expect(t, http.StatusNotFound)
+
+			if etag(without) != untouched {
+				t.Errorf("house without the amenity changed ETag from %s to %s", untouched, etag(without))
+			}
+			call(t, a, "PUT", with, landlord, houseWith("parking"), "If-Match", before).expect(t, http.StatusPreconditionFailed)
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/khelechy/rielzapi/api/apperr"
+    "github.com/khelechy/rielzapi/api/models"
+    "github.com/khelechy/rielzapi/api/repository"
+    "github.com/khelechy/rielzapi/api/responses"
+    "github.com/khelechy/rielzapi/utils"
+)
+
+// errAccountSuspended refuses logins and token refreshes of suspended users
+var errAccountSuspended = apperr.Forbidden("account_suspended", "Your account has been suspended")
+
+// refreshRequest is the body of the refresh and logout endpoints
+type refreshRequest struct {
+    RefreshToken string `json:"refresh_token"`
+}
+
+// readRefreshRequest decodes an optional refresh token from the request body
+func readRefreshRequest(r *http.Request) (refreshRequest, error) {
+    request := refreshRequest{}
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        return request, err
+    }
+    if len(body) > 0 {
+        if err = json.Unmarshal(body, &request); err != nil {
+            return request, err
+        }
+    }
+    request.RefreshToken = strings.TrimSpace(request.RefreshToken)
+    return request, nil
+}
+
+// issueTokens adds a new access token and the given refresh token to a response
+func issueTokens(resp map[string]interface{}, user *models.User, refreshToken string) error {
+    token, err := utils.EncodeAuthToken(user.ID, user.Role)
+    if err != nil {
+        return err
+    }
+    resp["token"] = token
+    resp["refresh_token"] = refreshToken
+    resp["expires_in"] = int(utils.AccessTokenTTL.Seconds())
+    return nil
+}
+
+// isTokenRevoked is the revocation check AuthJwtVerify runs on every token
+func (a *App) isTokenRevoked(ctx context.Context, jti string, userID uint, issuedAt int64) (bool, error) {
+    return repository.TracedTokens(ctx, a.Tokens).IsAccessTokenRevoked(jti, userID, issuedAt)
+}
+
+// startSession stores a new refresh token for the user and adds a token pair to a response
+func (a *App) startSession(r *http.Request, resp map[string]interface{}, user *models.User) error {
+    refreshToken, hash, err := utils.NewRefreshToken()
+    if err != nil {
+        return err
+    }
+    if _, err = a.tokens(r).SaveRefreshToken(user.ID, hash, time.Now().Add(utils.RefreshTokenTTL)); err != nil {
+        return err
+    }
+    return issueTokens(resp, user, refreshToken)
+}
+
+// RefreshToken godoc
+// @Summary Exchange a refresh token for a new access and refresh token
+// @Accept  json
+// @Produce  json
+// @Router /token/refresh [post]
+func (a *App) RefreshToken(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Token refreshed"}
+
+    request, err := readRefreshRequest(r)
+    if err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+    if request.RefreshToken == "" {
+        responses.ERROR(w, r, apperr.InvalidField("refresh_token", "Refresh token is required"))
+        return
+    }
+
+    refreshToken, hash, err := utils.NewRefreshToken()
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    user, err := a.tokens(r).RotateRefreshToken(utils.HashToken(request.RefreshToken), hash, time.Now().Add(utils.RefreshTokenTTL))
+    if err != nil { // an invalid or reused refresh token answers 401
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    if user.Suspended {
+        responses.ERROR(w, r, errAccountSuspended)
+        return
+    }
+
+    if err = issueTokens(resp, user, refreshToken); err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// Logout godoc
+// @Summary Revoke the current access token and, when given, its refresh token
+// @Accept  json
+// @Produce  json
+// @Router /logout [post]
+func (a *App) Logout(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Logged out"}
+
+    request, err := readRefreshRequest(r)
+    if err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+
+    actor := a.currentActor(r)
+    jti, _ := r.Context().Value("jti").(string)
+    expiresAt, _ := r.Context().Value("tokenExpiresAt").(time.Time)
+
+    if err = a.tokens(r).RevokeAccessToken(jti, expiresAt); err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    if request.RefreshToken != "" {
+        if err = a.tokens(r).RevokeRefreshToken(actor.UserID, utils.HashToken(request.RefreshToken)); err != nil {
+            responses.ERROR(w, r, err)
+            return
+        }
+    }
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// LogoutAll godoc
+// @Summary Revoke every access and refresh token of the logged in user
+// @Accept  json
+// @Produce  json
+// @Router /logout/all [post]
+func (a *App) LogoutAll(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Logged out of all sessions"}
+
+    if err := a.tokens(r).RevokeAllSessions(a.currentActor(r).UserID); err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// JWKS godoc
+// @Summary Public keys that verify access tokens, as a JSON Web Key Set
+// @Produce  json
+// @Router /.well-known/jwks.json [get]
+func (a *App) JWKS(w http.ResponseWriter, r *http.Request) {
+    keys, err := utils.Keys()
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    w.Header().Set("Cache-Control", "public, max-age=300")
+    responses.JSON(w, http.StatusOK, keys.JWKS())
+    return
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/repository"
+)
+
+// session is the token pair a login or refresh answers with
+type session struct {
+	access, refresh string
+}
+
+// login logs a user in with the password signUp registers
+func login(t *testing.T, a *App, email string) session {
+	t.Helper()
+	resp := call(t, a, "POST", "/login", "", fmt.Sprintf(`{"email":%q,"password":"password123"}`, email)).expect(t, http.StatusOK)
+	return session{resp.str("token"), resp.str("refresh_token")}
+}
+
+func TestLogin(t *testing.T) {
+	a := newTestApp(t, repository.NewMemoryStore())
+	signUp(t, a, "landlord@example.com", models.RoleLandlord)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+		code string
+	}{
+		{"right password", `{"email":"landlord@example.com","password":"password123"}`, http.StatusOK, ""},
+		{"wrong password", `{"email":"landlord@example.com","password":"password124"}`, http.StatusUnauthorized, "invalid_credentials"},
+		{"unknown email", `{"email":"nobody@example.com","password":"password123"}`, http.StatusUnauthorized, "invalid_credentials"},
+		{"missing password", `{"email":"landlord@example.com"}`, http.StatusUnprocessableEntity, ""},
+		{"malformed body", `{"email":`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := call(t, a, "POST", "/login", "", tt.body).expect(t, tt.want)
+			if tt.want == http.StatusOK && (resp.str("token") == "" || resp.str("refresh_token") == "") {
+				t.Errorf("login answered without a token pair: %s", resp.Raw)
+			}
+			if tt.code != "" && resp.str("code") != tt.code {
+				t.Errorf("got code %q, want %q", resp.str("code"), tt.code)
+			}
+		})
+	}
+}
+
+// TestRefreshTokenRotation walks a session through refresh, logout and the
+// reuse of a rotated refresh token. Each step depends on the ones before it.
+func TestRefreshTokenRotation(t *testing.T) {
+	a := newTestApp(t, repository.NewMemoryStore())
+	signUp(t, a, "landlord@example.com", models.RoleLandlord)
+	first := login(t, a, "landlord@example.com")
+	var second session
+
+	steps := []struct {
+		name    string
+		method  string
+		path    string
+		access  *string // sent as the bearer token
+		refresh *string // sent in the body
+		save    *session
+		want    int
+		code    string
+	}{
+		{"access token opens authenticated routes", "GET", "/api/agents", &first.access, nil, nil, http.StatusOK, ""},
+		{"refresh token is exchanged for a new pair", "POST", "/token/refresh", nil, &first.refresh, &second, http.StatusOK, ""},
+		{"new access token opens authenticated routes", "GET", "/api/agents", &second.access, nil, nil, http.StatusOK, ""},
+		{"logout revokes both tokens", "POST", "/logout", &second.access, &second.refresh, nil, http.StatusOK, ""},
+		{"logged out access token is refused", "GET", "/api/agents", &second.access, nil, nil, http.StatusUnauthorized, "token_revoked"},
+		{"logged out refresh token is refused", "POST", "/token/refresh", nil, &second.refresh, nil, http.StatusUnauthorized, "invalid_refresh_token"},
+		{"rotated refresh token is caught when reused", "POST", "/token/refresh", nil, &first.refresh, nil, http.StatusUnauthorized, "refresh_token_reused"},
+		{"request without a token is refused", "GET", "/api/agents", nil, nil, nil, http.StatusUnauthorized, "missing_token"},
+	}
+	for _, step := range steps {
+		token, body := "", ""
+		if step.access != nil {
+			token = *step.access
+		}
+		if step.refresh != nil {
+			body = fmt.Sprintf(`{"refresh_token":%q}`, *step.refresh)
+		}
+		resp := call(t, a, step.method, step.path, token, body)
+		if resp.Code != step.want {
+			t.Fatalf("%s: got status %d, want %d: %s", step.name, resp.Code, step.want, resp.Raw)
+		}
+		if step.code != "" && resp.str("code") != step.code {
+			t.Fatalf("%s: got code %q, want %q", step.name, resp.str("code"), step.code)
+		}
+		if step.save != nil {
+			*step.save = session{resp.str("token"), resp.str("refresh_token")}
+			if step.save.access == "" || step.save.refresh == "" {
+				t.Fatalf("%s: no token pair in %s", step.name, resp.Raw)
+			}
+		}
+	}
+}
+
+// TestJWKSPublishesNoSecret checks that a deployment signing with a shared
+// secret publishes an empty key set
+func TestJWKSPublishesNoSecret(t *testing.T) {
+	a := newTestApp(t, repository.NewMemoryStore())
+	resp := call(t, a, "GET", "/.well-known/jwks.json", "", "").expect(t, http.StatusOK)
+	if keys, ok := resp.field("keys").([]interface{}); !ok || len(keys) != 0 {
+		t.Errorf("got %s, want an empty key set", resp.Raw)
+	}
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"go.uber.org/zap"
+
+	"github.com/khelechy/rielzapi/api/apperr"
+	"github.com/khelechy/rielzapi/api/logging"
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/policies"
+	"github.com/khelechy/rielzapi/api/responses"
+)
+
+// currentActor returns the logged in user that AuthJwtVerify put on the request,
+// along with the landlords they work for when they are an agent
+func (a *App) currentActor(r *http.Request) policies.Actor {
+	userID, _ := r.Context().Value("userID").(float64)
+	role, _ := r.Context().Value("role").(string)
+	actor := policies.Actor{UserID: uint(userID), Role: role}
+
+	if role == models.RoleAgent {
+		landlords, err := a.users(r).GetLinkedLandlordIDs(actor.UserID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("cannot load landlords of agent", zap.Uint("user_id", actor.UserID), zap.Error(err))
+		}
+		actor.Landlords = landlords
+	}
+	return actor
+}
+
+// forbid writes a 403 response when a policy refuses the action and reports whether it did
+func forbid(w http.ResponseWriter, r *http.Request, allowed bool, message string) bool {
+	if !allowed {
+		responses.ERROR(w, r, apperr.Forbidden(apperr.CodeForbidden, message))
+	}
+	return !allowed
+}
+
+// loadHouse fetches a house, writing a 404 or 500 response when it cannot
+func (a *App) loadHouse(w http.ResponseWriter, r *http.Request, id int) (*models.House, bool) {
+	house, err := a.houses(r).GetHouseById(id)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			err = apperr.NotFound("house_not_found", "House not found")
+		}
+		responses.ERROR(w, r, err)
+		return nil, false
+	}
+	return house, true
+}
+
+// loadUser fetches a user, writing a 404 or 500 response when it cannot
+func (a *App) loadUser(w http.ResponseWriter, r *http.Request, id int) (*models.User, bool) {
+	user, err := a.users(r).GetUserById(id)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			err = apperr.NotFound("user_not_found", "User not found")
+		}
+		responses.ERROR(w, r, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// loadTenant fetches a tenant, writing a 404 or 500 response when it cannot
+func (a *App) loadTenant(w http.ResponseWriter, r *http.Request, id int) (*models.Tenant, bool) {
+	tenant, err := a.tenants(r).GetTenantById(id)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			err = apperr.NotFound("tenant_not_found", "Tenant not found")
+		}
+		responses.ERROR(w, r, err)
+		return nil, false
+	}
+	return tenant, true
+}
+
+// loadLease fetches a lease, writing a 404 or 500 response when it cannot
+func (a *App) loadLease(w http.ResponseWriter, r *http.Request, id int) (*models.Lease, bool) {
+	lease, err := a.tenants(r).GetLeaseById(id)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			err = apperr.NotFound("lease_not_found", "Lease not found")
+		}
+		responses.ERROR(w, r, err)
+		return nil, false
+	}
+	return lease, true
+}
+
+// loadAmenity fetches an amenity of the catalog, writing a 404 or 500 response when it cannot
+func (a *App) loadAmenity(w http.ResponseWriter, r *http.Request, id int) (*models.Amenity, bool) {
+	amenity, err := a.amenities(r).GetAmenityById(id)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			err = apperr.NotFound("amenity_not_found", "Amenity not found")
+		}
+		responses.ERROR(w, r, err)
+		return nil, false
+	}
+	return amenity, true
+}
+
+// loadMedia fetches the media named in the route from its house, writing a 404
+// or 500 response when it cannot
+func (a *App) loadMedia(w http.ResponseWriter, r *http.Request, house *models.House) (*models.HouseMedia, bool) {
+	id, _ := strconv.Atoi(mux.Vars(r)["mediaId"])
+
+	media, err := a.houses(r).GetHouseMediaById(house.ID, id)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			err = apperr.NotFound("media_not_found", "Media not found")
+		}
+		responses.ERROR(w, r, err)
+		return nil, false
+	}
+	return media, true
+}
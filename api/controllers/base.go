@@ -2,42 +2,118 @@
 package controllers
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"database/sql"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gorilla/mux"
-	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/postgres" //postgres
+	"go.uber.org/zap"
 
 	_ "github.com/khelechy/rielzapi/api/models/docs"
 	httpSwagger "github.com/swaggo/http-swagger"
 
+	"github.com/khelechy/rielzapi/api/apperr"
+	"github.com/khelechy/rielzapi/api/blobstore"
+	"github.com/khelechy/rielzapi/api/mailer"
+	"github.com/khelechy/rielzapi/api/metrics"
 	"github.com/khelechy/rielzapi/api/middlewares"
 	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/repository"
 	"github.com/khelechy/rielzapi/api/responses"
+	"github.com/khelechy/rielzapi/api/tracing"
+	"github.com/khelechy/rielzapi/config"
+	"github.com/khelechy/rielzapi/utils"
 )
 
 type App struct {
-	Router *mux.Router
-	DB     *gorm.DB
+	Config    *config.Config
+	Router    *mux.Router
+	Houses    repository.HouseRepository
+	Amenities repository.AmenityRepository
+	Users     repository.UserRepository
+	Tenants   repository.TenantRepository
+	Tokens    repository.TokenRepository
+	Mailer    mailer.Mailer
+	Blobs     blobstore.BlobStore
+	Logger    *zap.Logger
+
+	store *repository.Store
+}
+
+// UseStore serves the app's data from the repositories of a store
+func (a *App) UseStore(store *repository.Store) {
+	a.store = store
+	a.Houses = store.Houses
+	a.Amenities = store.Amenities
+	a.Users = store.Users
+	a.Tenants = store.Tenants
+	a.Tokens = store.Tokens
+}
+
+// houses, amenities, users, tenants, tokens, mailer and blobs hand the app's dependencies to a
+// handler with each call traced as part of the request
+func (a *App) houses(r *http.Request) repository.HouseRepository {
+	return repository.TracedHouses(r.Context(), a.Houses)
+}
+
+func (a *App) amenities(r *http.Request) repository.AmenityRepository {
+	return repository.TracedAmenities(r.Context(), a.Amenities)
+}
+
+func (a *App) users(r *http.Request) repository.UserRepository {
+	return repository.TracedUsers(r.Context(), a.Users)
+}
+
+func (a *App) tenants(r *http.Request) repository.TenantRepository {
+	return repository.TracedTenants(r.Context(), a.Tenants)
+}
+
+func (a *App) tokens(r *http.Request) repository.TokenRepository {
+	return repository.TracedTokens(r.Context(), a.Tokens)
+}
+
+func (a *App) mailer(r *http.Request) mailer.Mailer {
+	return mailer.Traced(r.Context(), a.Mailer)
+}
+
+func (a *App) blobs() blobstore.BlobStore {
+	return blobstore.Traced(a.Blobs)
 }
 
 // Initialize connect to the database and wire up routes
-func (a *App) Initialize(DbHost, DbPort, DbUser, DbName, DbPassword string) {
-	var err error
-	DBURI := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s", DbHost, DbPort, DbUser, DbName, DbPassword)
+func (a *App) Initialize(cfg *config.Config, logger *zap.Logger) {
+	a.Config = cfg
+	a.Logger = logger
 
-	a.DB, err = gorm.Open("postgres", DBURI)
+	store, err := repository.Open(cfg.Database)
 	if err != nil {
-		fmt.Printf("\n Cannot connect to database %s", DbName)
-		log.Fatal("This is the error:", err)
-	} else {
-		fmt.Printf("We are connected to the database %s", DbName)
+		logger.Fatal("cannot connect to database", zap.String("database", cfg.Database.Name), zap.Error(err))
 	}
+	logger.Info("connected to database", zap.String("driver", cfg.Database.Driver), zap.String("database", cfg.Database.Name))
+	store.UseLogger(logger)
 
-	a.DB.Debug().AutoMigrate(&models.User{}, &models.House{}, &models.Tenant{}) //database migration
+	if err = store.Migrate(); err != nil { //database migration
+		logger.Fatal("cannot migrate database", zap.Error(err))
+	}
+	a.UseStore(store)
+
+	keys, err := utils.LoadKeyRing(cfg.Auth)
+	if err != nil {
+		logger.Fatal("cannot load token signing keys", zap.Error(err))
+	}
+	utils.SetKeyRing(keys)
+
+	if a.Mailer, err = mailer.FromConfig(cfg.Mail); err != nil {
+		logger.Fatal("cannot configure mailer", zap.Error(err))
+	}
+
+	if a.Blobs, err = blobstore.FromConfig(cfg.Storage); err != nil {
+		logger.Fatal("cannot configure file storage", zap.Error(err))
+	}
+	models.UseBlobURLs(a.Blobs.URL)
 
 	a.Router = mux.NewRouter().StrictSlash(true)
 	a.initializeRoutes()
@@ -54,35 +130,172 @@ func (a *App) Initialize(DbHost, DbPort, DbUser, DbName, DbPassword string) {
 // @host localhost:5000
 // @BasePath /
 func (a *App) initializeRoutes() {
+	authenticated := middlewares.AuthJwtVerify(a.isTokenRevoked)
+	optional := middlewares.AuthJwtOptional(a.isTokenRevoked) // public routes that show more to logged in users
+
+	collector := metrics.New(a.Houses, a.sqlDB())
+	a.Router.Use(tracing.Middleware)                   // a span per route, continuing the caller's trace
+	a.Router.Use(collector.Middleware)                 // request counts and latencies per route
 	a.Router.Use(middlewares.SetContentTypeMiddleware) // setting content-type to json
+	bodyLimits := map[*mux.Route]int64{}               // larger limits of the routes taking files
+	a.Router.Use(middlewares.LimitBodySize(int64(a.Config.Server.MaxBodyBytes), bodyLimits))
+	a.Router.NotFoundHandler = http.HandlerFunc(routeNotFound)
+	a.Router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
 	a.Router.PathPrefix("/swagger").Handler(httpSwagger.WrapHandler)
+	if files, ok := a.Blobs.(http.Handler); ok { // the local store serves its own files
+		a.Router.PathPrefix("/media/").Handler(http.StripPrefix("/media", files)).Methods("GET", "HEAD")
+	}
 	a.Router.HandleFunc("/", home).Methods("GET")
+	a.Router.HandleFunc("/healthz", a.Healthz).Methods("GET")
+	a.Router.HandleFunc("/readyz", a.Readyz).Methods("GET")
+	a.Router.HandleFunc("/version", a.Version).Methods("GET")
+	a.Router.Handle("/metrics", collector.Handler()).Methods("GET")
 	a.Router.HandleFunc("/register", a.UserSignUp).Methods("POST")
 	a.Router.HandleFunc("/login", a.Login).Methods("POST")
+	a.Router.HandleFunc("/token/refresh", a.RefreshToken).Methods("POST")
+	a.Router.HandleFunc("/.well-known/jwks.json", a.JWKS).Methods("GET")
+	a.Router.HandleFunc("/password/forgot", a.ForgotPassword).Methods("POST")
+	a.Router.HandleFunc("/password/reset", a.ResetPassword).Methods("POST")
+	a.Router.HandleFunc("/email/verify", a.VerifyEmail).Methods("POST")
+	a.Router.Handle("/email/verify/resend", authenticated(http.HandlerFunc(a.ResendVerification))).Methods("POST")
+	a.Router.Handle("/logout", authenticated(http.HandlerFunc(a.Logout))).Methods("POST")
+	a.Router.Handle("/logout/all", authenticated(http.HandlerFunc(a.LogoutAll))).Methods("POST")
 	a.Router.HandleFunc("/api/houses", a.GetHouses).Methods("GET")
+	a.Router.HandleFunc("/api/houses/nearby", a.GetHousesNearby).Methods("GET")
 	a.Router.HandleFunc("/api/houses/{id:[0-9]+}", a.GetHouseById).Methods("GET")
-	a.Router.HandleFunc("/api/users", a.GetUsers).Methods("GET")
-	a.Router.HandleFunc("/api/users/{id:[0-9]+}", a.GetUserById).Methods("GET")
+	a.Router.HandleFunc("/api/houses/{id:[0-9]+}/media", a.GetHouseMedia).Methods("GET")
+	a.Router.HandleFunc("/api/amenities", a.GetAmenities).Methods("GET")
+	a.Router.Handle("/api/users", optional(http.HandlerFunc(a.GetUsers))).Methods("GET")
+	a.Router.Handle("/api/users/{id:[0-9]+}", optional(http.HandlerFunc(a.GetUserById))).Methods("GET")
 	a.Router.HandleFunc("/api/houses/landlord/{id:[0-9]+}", a.GetHousesByLandlordId).Methods("GET")
 	a.Router.HandleFunc("/api/houses/{state}", a.GetHousesByState).Methods("GET")
 
 	s := a.Router.PathPrefix("/api").Subrouter() // routes that require authentication
-	s.Use(middlewares.AuthJwtVerify)
+	s.Use(authenticated)
+
+	managers := middlewares.RequireRole(models.RoleAdmin, models.RoleLandlord, models.RoleAgent) // roles that look after houses
+	landlords := middlewares.RequireRole(models.RoleAdmin, models.RoleLandlord)
 
-	s.HandleFunc("/houses", a.CreateHouse).Methods("POST")
-	s.HandleFunc("/houses/tenant", a.AddTenant).Methods("POST")
+	s.Handle("/houses", managers(http.HandlerFunc(a.CreateHouse))).Methods("POST")
+	s.Handle("/houses/tenant", managers(http.HandlerFunc(a.AddTenant))).Methods("POST")
 	s.HandleFunc("/houses/landlord/", a.GetHousesByLandlord).Methods("GET")
 	s.HandleFunc("/api/users/{id:[0-9]+}", a.GetUserById).Methods("GET")
 	s.HandleFunc("/users/{id:[0-9]+}", a.UpdateUser).Methods("PUT")
-	s.HandleFunc("/houses/{id:[0-9]+}", a.UpdateHouse).Methods("PUT")
-	s.HandleFunc("/houses/{id:[0-9]+}", a.DeleteHouse).Methods("DELETE")
+	s.HandleFunc("/users/{id:[0-9]+}", a.PatchUser).Methods("PATCH")
+	s.Handle("/houses/{id:[0-9]+}", managers(http.HandlerFunc(a.UpdateHouse))).Methods("PUT")
+	s.Handle("/houses/{id:[0-9]+}", managers(http.HandlerFunc(a.PatchHouse))).Methods("PATCH")
+	s.Handle("/houses/{id:[0-9]+}", managers(http.HandlerFunc(a.DeleteHouse))).Methods("DELETE")
+	s.Handle("/houses/{id:[0-9]+}/tenants", managers(http.HandlerFunc(a.GetHouseTenants))).Methods("GET")
+	upload := s.Handle("/houses/{id:[0-9]+}/media", managers(http.HandlerFunc(a.UploadHouseMedia))).Methods("POST")
+	bodyLimits[upload] = int64(a.Config.Storage.MaxUploadBytes) + multipartOverhead
+	s.Handle("/houses/{id:[0-9]+}/media/order", managers(http.HandlerFunc(a.ReorderHouseMedia))).Methods("PUT")
+	s.Handle("/houses/{id:[0-9]+}/media/{mediaId:[0-9]+}/cover", managers(http.HandlerFunc(a.SetHouseCover))).Methods("PUT")
+	s.Handle("/houses/{id:[0-9]+}/media/{mediaId:[0-9]+}", managers(http.HandlerFunc(a.DeleteHouseMedia))).Methods("DELETE")
+	s.Handle("/tenants/{id:[0-9]+}", managers(http.HandlerFunc(a.GetTenantById))).Methods("GET")
+	s.Handle("/tenants/{id:[0-9]+}", managers(http.HandlerFunc(a.UpdateTenant))).Methods("PUT")
+	s.Handle("/tenants/{id:[0-9]+}", managers(http.HandlerFunc(a.DeleteTenant))).Methods("DELETE")
+	s.Handle("/tenants/{id:[0-9]+}/transfer", managers(http.HandlerFunc(a.TransferTenant))).Methods("POST")
+	s.Handle("/leases/{id:[0-9]+}/end", managers(http.HandlerFunc(a.EndLease))).Methods("POST")
+	s.Handle("/agents", landlords(http.HandlerFunc(a.GetAgents))).Methods("GET")
+	s.Handle("/agents", landlords(http.HandlerFunc(a.LinkAgent))).Methods("POST")
+	s.Handle("/agents/{id:[0-9]+}", landlords(http.HandlerFunc(a.UnlinkAgent))).Methods("DELETE")
+	s.Handle("/me/leases", middlewares.RequireRole(models.RoleTenant)(http.HandlerFunc(a.GetMyLeases))).Methods("GET")
+
+	admin := s.PathPrefix("/admin").Subrouter() // platform moderation and the amenities catalog
+	admin.Use(middlewares.RequireRole(models.RoleAdmin))
+
+	admin.HandleFunc("/users/{id:[0-9]+}/suspend", a.SuspendUser).Methods("POST")
+	admin.HandleFunc("/users/{id:[0-9]+}/reinstate", a.ReinstateUser).Methods("POST")
+	admin.HandleFunc("/users/{id:[0-9]+}/role", a.SetUserRole).Methods("PUT")
+	admin.HandleFunc("/amenities", a.CreateAmenity).Methods("POST")
+	admin.HandleFunc("/amenities/{id:[0-9]+}", a.UpdateAmenity).Methods("PUT")
+	admin.HandleFunc("/amenities/{id:[0-9]+}", a.DeleteAmenity).Methods("DELETE")
+}
+
+// Handler is the router wrapped so that every request gets an ID, a tagged
+// logger and an access log line
+func (a *App) Handler() http.Handler {
+	return middlewares.RequestID(a.logger())(middlewares.AccessLog(a.Router))
+}
+
+// logger is the app's logger, a no-op one when none was set
+func (a *App) logger() *zap.Logger {
+	if a.Logger == nil {
+		return zap.NewNop()
+	}
+	return a.Logger
+}
+
+// RunServer serves the API until SIGINT or SIGTERM, then stops accepting
+// connections, lets in-flight requests finish within the shutdown timeout and
+// closes the database
+func (a *App) RunServer() error {
+	cfg := a.Config.Server
+	server := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           a.Handler(),
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+
+	failed := make(chan error, 1)
+	go func() {
+		a.logger().Info("server starting", zap.String("port", cfg.Port), zap.Bool("tls", cfg.TLSCertFile != ""))
+		if cfg.TLSCertFile != "" {
+			failed <- server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			failed <- server.ListenAndServe()
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case err := <-failed:
+		a.Close()
+		return err
+	case sig := <-stop:
+		a.logger().Info("shutting down", zap.String("signal", sig.String()))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if closeErr := a.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
-func (a *App) RunServer() {
-	log.Printf("\nServer starting on port 5000")
-	log.Fatal(http.ListenAndServe(":"+os.Getenv("PORT"), a.Router))
+// sqlDB is the connection pool behind the app's store, nil without a SQL database
+func (a *App) sqlDB() *sql.DB {
+	if a.store == nil || a.store.DB == nil {
+		return nil
+	}
+	return a.store.DB.DB()
+}
+
+// Close releases the database connections of the app's store
+func (a *App) Close() error {
+	if a.store == nil {
+		return nil
+	}
+	return a.store.Close()
 }
 
 func home(w http.ResponseWriter, r *http.Request) { // this is the home route
 	responses.JSON(w, http.StatusOK, "Welcome To RielzAPI")
 }
+
+func routeNotFound(w http.ResponseWriter, r *http.Request) {
+	responses.ERROR(w, r, apperr.NotFound("route_not_found", "No route matches "+r.URL.Path))
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	responses.ERROR(w, r, apperr.New(apperr.KindMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed on "+r.URL.Path))
+}
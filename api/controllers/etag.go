@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/responses"
+)
+
+// houseETag is the entity tag of a house, which changes whenever its version does
+func houseETag(house *models.House) string {
+	return fmt.Sprintf(`"house-%d-v%d"`, house.ID, house.Version)
+}
+
+// etagListed reports whether an If-Match or If-None-Match header lists etag.
+// If-Match compares strongly, so weak tags never match; If-None-Match
+// compares weakly and ignores the W/ prefix.
+func etagListed(header, etag string, weak bool) bool {
+	for _, listed := range strings.Split(header, ",") {
+		listed = strings.TrimSpace(listed)
+		if listed == "*" {
+			return true
+		}
+		if strings.HasPrefix(listed, "W/") {
+			if !weak {
+				continue
+			}
+			listed = strings.TrimPrefix(listed, "W/")
+		}
+		if listed == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch checks the If-Match header of a request that changes the house,
+// writing a 412 response when the client edited a version other than the
+// current one. Requests without the header are let through.
+func ifMatch(w http.ResponseWriter, r *http.Request, house *models.House) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" || etagListed(header, houseETag(house), false) {
+		return true
+	}
+	responses.ERROR(w, r, models.ErrHouseModified)
+	return false
+}
+
+// notModified answers 304 when the If-None-Match header of a request lists the
+// current ETag of the house, so clients polling a house only download changes
+func notModified(w http.ResponseWriter, r *http.Request, house *models.House) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !etagListed(header, houseETag(house), true) {
+		return false
+	}
+	w.Header().Set("ETag", houseETag(house))
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
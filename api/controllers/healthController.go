@@ -0,0 +1,65 @@
+package controllers
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/khelechy/rielzapi/api/responses"
+    "github.com/khelechy/rielzapi/utils"
+)
+
+// readinessTimeout bounds how long /readyz waits on the database
+const readinessTimeout = 2 * time.Second
+
+// Healthz godoc
+// @Summary Liveness probe, succeeds while the process can serve requests
+// @Produce  json
+// @Router /healthz [get]
+func (a *App) Healthz(w http.ResponseWriter, r *http.Request) {
+    responses.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+    return
+}
+
+// Readyz godoc
+// @Summary Readiness probe, succeeds when the database answers and its schema is current
+// @Produce  json
+// @Router /readyz [get]
+func (a *App) Readyz(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "ok"}
+    checks := map[string]string{"database": "ok", "migrations": "ok"}
+
+    ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+    defer cancel()
+
+    if a.store == nil {
+        checks["database"] = "no store configured"
+    } else if err := a.store.Ping(ctx); err != nil {
+        checks["database"] = err.Error()
+    } else if pending, err := a.store.PendingMigrations(); err != nil {
+        checks["migrations"] = err.Error()
+    } else if pending > 0 {
+        checks["migrations"] = fmt.Sprintf("%d pending", pending)
+    }
+
+    resp["checks"] = checks
+    for _, check := range checks {
+        if check != "ok" {
+            resp["status"] = "unavailable"
+            responses.JSON(w, http.StatusServiceUnavailable, resp)
+            return
+        }
+    }
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// Version godoc
+// @Summary Version, commit, build time and Go version of the running binary
+// @Produce  json
+// @Router /version [get]
+func (a *App) Version(w http.ResponseWriter, r *http.Request) {
+    responses.JSON(w, http.StatusOK, utils.Build())
+    return
+}
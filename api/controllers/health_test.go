@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/khelechy/rielzapi/api/migrations"
+	"github.com/khelechy/rielzapi/api/repository"
+)
+
+// TestReadyzFollowsMigrations reports the API unready until its schema is
+// current. Each step depends on the ones before it.
+func TestReadyzFollowsMigrations(t *testing.T) {
+	store, err := repository.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	a := newTestApp(t, store)
+	migrator, err := migrations.New(store.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name       string
+		change     func() error
+		want       int
+		migrations string // the migrations check, when it is known
+	}{
+		{"schema never migrated", nil, http.StatusServiceUnavailable, ""},
+		{"schema migrated", store.Migrate, http.StatusOK, "ok"},
+		{"last migration rolled back", func() error { _, err := migrator.Down(1); return err }, http.StatusServiceUnavailable, "1 pending"},
+		{"migrated again", store.Migrate, http.StatusOK, "ok"},
+		{"database closed", store.Close, http.StatusServiceUnavailable, ""},
+	}
+	for _, step := range steps {
+		if step.change != nil {
+			if err := step.change(); err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+		}
+		resp := call(t, a, "GET", "/readyz", "", "")
+		if resp.Code != step.want {
+			t.Fatalf("%s: got status %d, want %d: %s", step.name, resp.Code, step.want, resp.Raw)
+		}
+		if step.migrations != "" && resp.str("checks", "migrations") != step.migrations {
+			t.Errorf("%s: migrations check is %q, want %q", step.name, resp.str("checks", "migrations"), step.migrations)
+		}
+		// the process stays alive whatever the database does
+		call(t, a, "GET", "/healthz", "", "").expect(t, http.StatusOK)
+	}
+}
+
+func TestReadyzWithoutDatabase(t *testing.T) {
+	a := newTestApp(t, repository.NewMemoryStore())
+	resp := call(t, a, "GET", "/readyz", "", "").expect(t, http.StatusOK)
+	if status := resp.str("status"); status != "ok" {
+		t.Errorf("got status %q, want ok", status)
+	}
+	if version := call(t, a, "GET", "/version", "", "").expect(t, http.StatusOK).str("go_version"); version == "" {
+		t.Error("version does not name the Go release")
+	}
+}
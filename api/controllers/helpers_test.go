@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/khelechy/rielzapi/api/blobstore"
+	"github.com/khelechy/rielzapi/api/mailer"
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/repository"
+	"github.com/khelechy/rielzapi/config"
+	"github.com/khelechy/rielzapi/utils"
+)
+
+// testStores are the repositories the API is tested against
+var testStores = []struct {
+	name string
+	open func(t *testing.T) *repository.Store
+}{
+	{"memory", func(t *testing.T) *repository.Store { return repository.NewMemoryStore() }},
+	{"sqlite", openSQLiteStore},
+}
+
+func openSQLiteStore(t *testing.T) *repository.Store {
+	store, err := repository.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if err = store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// newTestApp wires the API to store, with an in-memory mailer and files kept
+// in a temporary directory
+func newTestApp(t *testing.T, store *repository.Store) *App {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Auth.Secret = "0123456789012345678901234567890123456789"
+	keys, err := utils.LoadKeyRing(cfg.Auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.SetKeyRing(keys)
+
+	a := &App{
+		Config: cfg,
+		Router: mux.NewRouter().StrictSlash(true),
+		Mailer: &mailer.MemoryMailer{},
+		Blobs:  blobstore.NewLocal(t.TempDir(), ""),
+	}
+	models.UseBlobURLs(a.Blobs.URL)
+	a.UseStore(store)
+	a.initializeRoutes()
+	return a
+}
+
+// testResponse is a recorded response with its JSON body decoded
+type testResponse struct {
+	Code   int
+	Header http.Header
+	Body   map[string]interface{}
+	Raw    string
+}
+
+// call sends a request through the router. headers are name, value pairs.
+func call(t *testing.T, a *App, method, path, token, body string, headers ...string) *testResponse {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+
+	resp := &testResponse{Code: rec.Code, Header: rec.Header(), Raw: rec.Body.String()}
+	json.Unmarshal(rec.Body.Bytes(), &resp.Body) // lists and empty bodies leave Body nil
+	return resp
+}
+
+// expect fails the test when the response does not have the status code
+func (r *testResponse) expect(t *testing.T, code int) *testResponse {
+	t.Helper()
+	if r.Code != code {
+		t.Fatalf("got status %d, want %d: %s", r.Code, code, r.Raw)
+	}
+	return r
+}
+
+// field reads a value of the body by path, such as field("house", "id")
+func (r *testResponse) field(path ...string) interface{} {
+	var value interface{} = r.Body
+	for _, key := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// str reads a string of the body by path
+func (r *testResponse) str(path ...string) string {
+	s, _ := r.field(path...).(string)
+	return s
+}
+
+// num reads a number of the body by path
+func (r *testResponse) num(path ...string) int {
+	n, _ := r.field(path...).(float64)
+	return int(n)
+}
+
+// signUp registers a user with the role given and logs them in, returning the
+// user's id and access token. Roles other than the default are granted
+// through the store, as an admin would.
+func signUp(t *testing.T, a *App, email, role string) (uint, string) {
+	t.Helper()
+	body := fmt.Sprintf(`{"email":%q,"firstname":"Test","lastname":"User","password":"password123","phonenumber":"+2348012345678"}`, email)
+	user := call(t, a, "POST", "/register", "", body).expect(t, http.StatusCreated)
+	id := uint(user.num("user", "id"))
+	if role != models.RoleLandlord {
+		if err := a.Users.SetRole(int(id), role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	login := call(t, a, "POST", "/login", "", fmt.Sprintf(`{"email":%q,"password":"password123"}`, email)).expect(t, http.StatusOK)
+	return id, login.str("token")
+}
+
+// houseJSON is a valid house with the number of rooms given, all of them free
+func houseJSON(rooms int) string {
+	return fmt.Sprintf(`{"house_type":"Flat","state":"Lagos","description":"Two bedroom flat","location":"Yaba","rooms":%d,"available_rooms":%d,"bathrooms":1,"price":1200000,"long_lat":"3.3792,6.5095"}`, rooms, rooms)
+}
+
+// createHouse lists a house for the landlord logged in with token, returning its id
+func createHouse(t *testing.T, a *App, token string, rooms int) int {
+	t.Helper()
+	return call(t, a, "POST", "/api/houses", token, houseJSON(rooms)).expect(t, http.StatusCreated).num("house", "ID")
+}
+
+// tenantJSON is a tenant to add to the house with the id given
+func tenantJSON(email string, houseID int) string {
+	return fmt.Sprintf(`{"email":%q,"firstname":"Tobi","lastname":"Tenant","phonenumber":"+2348000000003","house_id":%d,"rent_amount":1200000}`, email, houseID)
+}
+
+// mailedToken reads the token from the link in the last message mailed to the address
+func mailedToken(t *testing.T, a *App, to string) string {
+	t.Helper()
+	messages := a.Mailer.(*mailer.MemoryMailer).Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].To != to {
+			continue
+		}
+		body := messages[i].Body
+		start := strings.Index(body, "token=")
+		if start < 0 {
+			break
+		}
+		token, err := url.QueryUnescape(strings.Fields(body[start+len("token="):])[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	t.Fatalf("no link was mailed to %s", to)
+	return ""
+}
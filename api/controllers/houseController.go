@@ -2,50 +2,61 @@ package controllers
 
 import (
     "encoding/json"
+    "errors"
     "io/ioutil"
     "net/http"
+    "net/url"
     "strconv"
+    "strings"
 
     "github.com/gorilla/mux"
 
+    "github.com/khelechy/rielzapi/api/apperr"
     "github.com/khelechy/rielzapi/api/models"
+    "github.com/khelechy/rielzapi/api/policies"
     "github.com/khelechy/rielzapi/api/responses"
 )
 
 // CreateHouse godoc
-// @Summary Create House for landlord
+// @Summary Create House for landlord. available_rooms starts at rooms and then follows the leases.
 // @Accept  json
 // @Produce  json
 // @Router /api/houses [post]
 func (a *App) CreateHouse(w http.ResponseWriter, r *http.Request) {
     var resp = map[string]interface{}{"status": "success", "message": "House successfully created"}
 
-    user := r.Context().Value("userID").(float64)
+    actor := a.currentActor(r)
     house := &models.House{}
     body, err := ioutil.ReadAll(r.Body)
     if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+        responses.ERROR(w, r, apperr.Malformed(err))
         return
     }
 
     err = json.Unmarshal(body, &house)
     if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+        responses.ERROR(w, r, apperr.Malformed(err))
         return
     }
 
     house.Prepare() // strip away any white spaces
+    house.AvailableRooms = house.Rooms // a new house has no leases, so any value sent is ignored
 
     if err = house.Validate(); err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+        responses.ERROR(w, r, apperr.Invalid(err))
         return
     }
 
-    house.UserID = uint(user)
+    if house.UserID == 0 || actor.Role == models.RoleLandlord {
+        house.UserID = actor.UserID // landlords always list their own houses
+    }
+    if forbid(w, r, policies.CanCreateHouseFor(actor, house.UserID), "Unauthorized house creation for this landlord") {
+        return
+    }
 
-    houseCreated, err := house.Save(a.DB)
+    houseCreated, err := a.houses(r).SaveHouse(house)
     if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+        responses.ERROR(w, r, err)
         return
     }
 
@@ -58,11 +69,150 @@ func (a *App) CreateHouse(w http.ResponseWriter, r *http.Request) {
 // @Summary Get All Houses
 // @Accept  json
 // @Produce  json
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
+// @Param sort query string false "Sort by price, created_at or available_rooms"
+// @Param order query string false "asc or desc"
+// @Param house_type query string false "Filter by house type"
+// @Param state query string false "Filter by state"
+// @Param min_price query int false "Minimum price"
+// @Param max_price query int false "Maximum price"
+// @Param min_rooms query int false "Minimum number of rooms"
+// @Param min_bathrooms query int false "Minimum number of bathrooms"
+// @Param available query bool false "Only houses with available rooms"
+// @Param amenities query string false "Comma separated slugs of amenities houses must all have, such as parking,water"
 // @Router /api/houses [get]
 func (a *App) GetHouses(w http.ResponseWriter, r *http.Request) {
-    houses, err := models.GetHouses(a.DB)
+    filter, err := houseFilterFromQuery(r.URL.Query())
+    if err != nil {
+        responses.ERROR(w, r, apperr.Invalid(err))
+        return
+    }
+
+    houses, total, err := a.houses(r).GetHouses(filter)
     if err != nil {
-        responses.ERROR(w, http.StatusInternalServerError, err)
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    page := responses.Page{
+        Data:       houses,
+        Pagination: responses.Pagination{Total: total, Limit: filter.Limit},
+    }
+    if next := filter.Offset + len(*houses); next < total {
+        page.Pagination.NextCursor = encodeCursor(next)
+        page.Pagination.Next = nextPageURL(r, page.Pagination.NextCursor)
+    }
+    responses.JSON(w, http.StatusOK, page)
+    return
+}
+
+// houseFilterFromQuery builds a house filter from the query string of a list request
+func houseFilterFromQuery(query url.Values) (models.HouseFilter, error) {
+    var err error
+    filter := models.HouseFilter{
+        HouseType: strings.TrimSpace(query.Get("house_type")),
+        State:     strings.TrimSpace(query.Get("state")),
+        SortBy:    query.Get("sort"),
+    }
+
+    if filter.Limit, filter.Offset, err = pageParams(query); err != nil {
+        return filter, err
+    }
+
+    if filter.SortBy != "" && !models.ValidHouseSort(filter.SortBy) {
+        return filter, errors.New("sort must be one of price, created_at or available_rooms")
+    }
+    switch strings.ToLower(query.Get("order")) {
+    case "", "asc":
+    case "desc":
+        filter.Descending = true
+    default:
+        return filter, errors.New("order must be asc or desc")
+    }
+
+    if filter.MinPrice, err = intParam(query, "min_price"); err != nil {
+        return filter, err
+    }
+    if filter.MaxPrice, err = intParam(query, "max_price"); err != nil {
+        return filter, err
+    }
+    if filter.MaxPrice > 0 && filter.MinPrice > filter.MaxPrice {
+        return filter, errors.New("min_price cannot be greater than max_price")
+    }
+    if filter.MinRooms, err = intParam(query, "min_rooms"); err != nil {
+        return filter, err
+    }
+    if filter.MinBathRooms, err = intParam(query, "min_bathrooms"); err != nil {
+        return filter, err
+    }
+
+    if v := query.Get("available"); v != "" {
+        if filter.Available, err = strconv.ParseBool(v); err != nil {
+            return filter, errors.New("available must be true or false")
+        }
+    }
+
+    amenities := []models.Amenity{}
+    for _, v := range query["amenities"] { // amenities=parking,water or amenities=parking&amenities=water
+        for _, slug := range strings.Split(v, ",") {
+            amenities = append(amenities, models.Amenity{Slug: slug})
+        }
+    }
+    filter.Amenities = models.AmenitySlugs(amenities)
+    return filter, nil
+}
+
+const (
+    defaultNearbyRadiusKm = 10.0
+    maxNearbyRadiusKm     = 100.0
+)
+
+// GetHousesNearby godoc
+// @Summary Get Houses near a point, closest first
+// @Accept  json
+// @Produce  json
+// @Param lat query number true "Latitude of the point"
+// @Param lng query number true "Longitude of the point"
+// @Param radius_km query number false "Search radius in kilometres (default 10, max 100)"
+// @Param limit query int false "Maximum number of houses (default 20, max 100)"
+// @Router /api/houses/nearby [get]
+func (a *App) GetHousesNearby(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+
+    lat, err := strconv.ParseFloat(query.Get("lat"), 64)
+    if err != nil {
+        responses.ERROR(w, r, apperr.InvalidField("lat", "lat is required and must be a number"))
+        return
+    }
+    lng, err := strconv.ParseFloat(query.Get("lng"), 64)
+    if err != nil {
+        responses.ERROR(w, r, apperr.InvalidField("lng", "lng is required and must be a number"))
+        return
+    }
+    if err = models.ValidateCoordinates(lat, lng); err != nil {
+        responses.ERROR(w, r, apperr.Invalid(err))
+        return
+    }
+
+    radius := defaultNearbyRadiusKm
+    if v := query.Get("radius_km"); v != "" {
+        radius, err = strconv.ParseFloat(v, 64)
+        if err != nil || radius <= 0 || radius > maxNearbyRadiusKm {
+            responses.ERROR(w, r, apperr.InvalidField("radius_km", "radius_km must be greater than 0 and at most 100"))
+            return
+        }
+    }
+
+    limit, _, err := pageParams(query)
+    if err != nil {
+        responses.ERROR(w, r, apperr.Invalid(err))
+        return
+    }
+
+    houses, err := a.houses(r).GetHousesNearby(lat, lng, radius, limit)
+    if err != nil {
+        responses.ERROR(w, r, err)
         return
     }
     responses.JSON(w, http.StatusOK, houses)
@@ -77,9 +227,9 @@ func (a *App) GetHouses(w http.ResponseWriter, r *http.Request) {
 func (a *App) GetHousesByLandlord(w http.ResponseWriter, r *http.Request) {
     user := r.Context().Value("userID").(float64)
     userID := uint(user)
-    houses, err := models.GetHousesByLandLord(userID, a.DB)
+    houses, err := a.houses(r).GetHousesByLandlord(userID)
     if err != nil {
-        responses.ERROR(w, http.StatusInternalServerError, err)
+        responses.ERROR(w, r, err)
         return
     }
     responses.JSON(w, http.StatusOK, houses)
@@ -98,9 +248,9 @@ func (a *App) GetHousesByLandlordId(w http.ResponseWriter, r *http.Request) {
     id, _ := strconv.Atoi(vars["id"])
 
     userID := uint(id)
-    houses, err := models.GetHousesByLandLord(userID, a.DB)
+    houses, err := a.houses(r).GetHousesByLandlord(userID)
     if err != nil {
-        responses.ERROR(w, http.StatusInternalServerError, err)
+        responses.ERROR(w, r, err)
         return
     }
     responses.JSON(w, http.StatusOK, houses)
@@ -116,9 +266,9 @@ func (a *App) GetHousesByLandlordId(w http.ResponseWriter, r *http.Request) {
 func (a *App) GetHousesByState(w http.ResponseWriter, r *http.Request) {
 
     state := mux.Vars(r)["state"]
-    houses, err := models.GetHousesByState(state, a.DB)
+    houses, err := a.houses(r).GetHousesByState(state)
     if err != nil {
-        responses.ERROR(w, http.StatusInternalServerError, err)
+        responses.ERROR(w, r, err)
         return
     }
     responses.JSON(w, http.StatusOK, houses)
@@ -129,6 +279,7 @@ func (a *App) GetHousesByState(w http.ResponseWriter, r *http.Request) {
 // @Summary Get All Houses By Id
 // @Accept  json
 // @Produce  json
+// @Param If-None-Match header string false "ETag of the copy the client holds, answered with 304 while it is current"
 // @Router /api/houses/id [get]
 func (a *App) GetHouseById(w http.ResponseWriter, r *http.Request){
 
@@ -136,11 +287,14 @@ func (a *App) GetHouseById(w http.ResponseWriter, r *http.Request){
 
     id, _ := strconv.Atoi(vars["id"])
 
-    house, err := models.GetHouseById(id, a.DB)
-    if err != nil{
-        responses.ERROR(w, http.StatusInternalServerError, err)
+    house, ok := a.loadHouse(w, r, id)
+    if !ok {
         return
     }
+    if notModified(w, r, house) {
+        return
+    }
+    w.Header().Set("ETag", houseETag(house))
     responses.JSON(w, http.StatusOK, house)
     return
 }
@@ -153,104 +307,156 @@ func (a *App) GetHouseById(w http.ResponseWriter, r *http.Request){
 func (a *App) AddTenant(w http.ResponseWriter, r *http.Request){
     var resp = map[string]interface{}{"status": "success", "message": "Tenant added successfully"}
 
-    tenant := &models.Tenant{}
     body, err := ioutil.ReadAll(r.Body)
     if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+        responses.ERROR(w, r, apperr.Malformed(err))
         return
     }
 
-    err = json.Unmarshal(body, &tenant)
+    request := struct {
+        models.Tenant
+        leaseRequest
+    }{}
+    err = json.Unmarshal(body, &request)
     if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+        responses.ERROR(w, r, apperr.Malformed(err))
         return
     }
+    tenant := &request.Tenant
 
     tenant.Prepare()
     err = tenant.Validate()
 
     if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+        responses.ERROR(w, r, apperr.Invalid(err))
         return
     }
 
-    house, err := models.GetHouseById(tenant.HouseId, a.DB)
-    if err != nil{
-        responses.ERROR(w, http.StatusInternalServerError, err)
+    house, ok := a.loadHouse(w, r, tenant.HouseId)
+    if !ok {
         return
     }
-
-    if house.AvailableRooms <= 0 {
-        resp["status"] = "failed"
-        resp["message"] = "There are no available rooms"
-        responses.JSON(w, http.StatusInternalServerError, resp)
+    if forbid(w, r, policies.CanAddTenant(a.currentActor(r), house), "Unauthorized tenant creation") {
         return
     }
 
-    tenantCreated, err := tenant.SaveTenant(a.DB)
-    if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+    lease := request.lease(house)
+    if err = lease.Validate(); err != nil {
+        responses.ERROR(w, r, apperr.Invalid(err))
         return
     }
 
-    house.AvailableRooms = house.AvailableRooms - 1
-
-    _, err = house.UpdateHouse(tenant.HouseId, a.DB)
-    if err != nil {
-        responses.ERROR(w, http.StatusInternalServerError, err)
+    // the room is checked and taken inside the transaction, the house read above only supplies defaults
+    tenantCreated, leaseCreated, err := a.tenants(r).AddTenant(tenant, lease)
+    if err != nil { // ErrNoAvailableRooms answers 409
+        responses.ERROR(w, r, err)
         return
     }
 
     resp["tenant"] = tenantCreated
+    resp["lease"] = leaseCreated
     responses.JSON(w, http.StatusCreated, resp)
     return
 }
 
-// UpdateHouse By Landlord godoc
-// @Summary Update Houses By Landlord
-// @Accept  json
-// @Produce  json
-// @Router /api/houses/id [put]
-func (a *App) UpdateHouse(w http.ResponseWriter, r *http.Request) {
-    var resp = map[string]interface{}{"status": "success", "message": "House updated successfully"}
+// editableHouse loads the house named in the route, checking the logged in user
+// may edit it, and reads the body of the edit
+func (a *App) editableHouse(w http.ResponseWriter, r *http.Request) (*models.House, []byte, bool) {
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
 
-    vars := mux.Vars(r)
+    house, ok := a.loadHouse(w, r, id)
+    if !ok {
+        return nil, nil, false
+    }
+    if forbid(w, r, policies.CanUpdateHouse(a.currentActor(r), house), "Unauthorized house update") {
+        return nil, nil, false
+    }
+    if !ifMatch(w, r, house) {
+        return nil, nil, false
+    }
 
-    user := r.Context().Value("userID").(float64)
-    userID := uint(user)
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return nil, nil, false
+    }
+    return house, body, true
+}
 
-    id, _ := strconv.Atoi(vars["id"])
+// replaceHouse saves update in place of every editable field of house and
+// answers with the house as saved
+func (a *App) replaceHouse(w http.ResponseWriter, r *http.Request, house, update *models.House) {
+    var resp = map[string]interface{}{"status": "success", "message": "House updated successfully"}
 
-    house, err := models.GetHouseById(id, a.DB)
+    // the listing keeps its id, owner and creation time whatever the body says,
+    // and is saved only if it is still at the version that was read
+    update.Model = house.Model
+    update.UserID = house.UserID
+    update.Version = house.Version
+    // available rooms are recomputed from the leases on save, so any value sent is ignored
+    update.AvailableRooms = 0
+
+    update.Prepare()
+    if err := update.Validate(); err != nil {
+        responses.ERROR(w, r, apperr.Invalid(err))
+        return
+    }
 
-    if house.UserID != userID {
-        resp["status"] = "failed"
-        resp["message"] = "Unauthorized house update"
-        responses.JSON(w, http.StatusUnauthorized, resp)
+    if _, err := a.houses(r).UpdateHouse(int(house.ID), update); err != nil { // ErrHouseModified answers 412, ErrRoomsOccupied 409
+        responses.ERROR(w, r, err)
+        return
+    }
+    updated, ok := a.loadHouse(w, r, int(house.ID))
+    if !ok {
         return
     }
 
-    body, err := ioutil.ReadAll(r.Body)
-    if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+    w.Header().Set("ETag", houseETag(updated))
+    resp["house"] = updated
+    responses.JSON(w, http.StatusOK, resp)
+}
+
+// UpdateHouse By Landlord godoc
+// @Summary Replace a house listing. Every field is required, as on create, except available_rooms, which follows the leases and cannot be set.
+// @Accept  json
+// @Produce  json
+// @Param If-Match header string false "ETag the edit is based on, answered with 412 once the house has changed"
+// @Router /api/houses/id [put]
+func (a *App) UpdateHouse(w http.ResponseWriter, r *http.Request) {
+    house, body, ok := a.editableHouse(w, r)
+    if !ok {
         return
     }
 
-    houseUpdate := models.House{}
-    if err = json.Unmarshal(body, &houseUpdate); err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+    update := &models.House{}
+    if err := json.Unmarshal(body, update); err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
         return
     }
 
-    houseUpdate.Prepare()
+    a.replaceHouse(w, r, house, update)
+    return
+}
 
-    _, err = houseUpdate.UpdateHouse(id, a.DB)
-    if err != nil {
-        responses.ERROR(w, http.StatusInternalServerError, err)
+// PatchHouse By Landlord godoc
+// @Summary Change some fields of a house listing with a JSON Merge Patch, where null clears a field. available_rooms follows the leases and cannot be set.
+// @Accept  application/merge-patch+json
+// @Produce  json
+// @Param If-Match header string false "ETag the edit is based on, answered with 412 once the house has changed"
+// @Router /api/houses/id [patch]
+func (a *App) PatchHouse(w http.ResponseWriter, r *http.Request) {
+    house, body, ok := a.editableHouse(w, r)
+    if !ok {
         return
     }
 
-    responses.JSON(w, http.StatusOK, resp)
+    update := &models.House{}
+    if err := mergePatch(house, body, update); err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+
+    a.replaceHouse(w, r, house, update)
     return
 }
 
@@ -258,31 +464,36 @@ func (a *App) UpdateHouse(w http.ResponseWriter, r *http.Request) {
 // @Summary Delete house By Landlord
 // @Accept  json
 // @Produce  json
+// @Param If-Match header string false "ETag the edit is based on, answered with 412 once the house has changed"
 // @Router /api/houses/id [delete]
 func (a *App) DeleteHouse(w http.ResponseWriter, r *http.Request) {
     var resp = map[string]interface{}{"status": "success", "message": "House deleted successfully"}
 
     vars := mux.Vars(r)
 
-    user := r.Context().Value("userID").(float64)
-    userID := uint(user)
-
     id, _ := strconv.Atoi(vars["id"])
 
-    house, err := models.GetHouseById(id, a.DB)
-
-    if house.UserID != userID {
-        resp["status"] = "failed"
-        resp["message"] = "Unauthorized house delete"
-        responses.JSON(w, http.StatusUnauthorized, resp)
+    house, ok := a.loadHouse(w, r, id)
+    if !ok {
+        return
+    }
+    if forbid(w, r, policies.CanDeleteHouse(a.currentActor(r), house), "Unauthorized house delete") {
+        return
+    }
+    if !ifMatch(w, r, house) {
         return
     }
 
-    err = models.DeleteHouse(id, a.DB)
+    // the house is deleted only if it is still at the version checked above
+    err := a.houses(r).DeleteHouse(id, house.Version)
     if err != nil {
-        responses.ERROR(w, http.StatusInternalServerError, err)
+        responses.ERROR(w, r, err)
         return
     }
+    // the files go once the house is gone, as nothing can show them any more
+    for _, media := range house.Media {
+        a.deleteBlobs(r, media.Key, media.ThumbnailKey)
+    }
     responses.JSON(w, http.StatusOK, resp)
     return
 }
\ No newline at end of file
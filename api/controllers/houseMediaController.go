@@ -0,0 +1,289 @@
+package controllers
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "path/filepath"
+    "strconv"
+    "strings"
+
+    "github.com/gorilla/mux"
+    "go.uber.org/zap"
+
+    "github.com/khelechy/rielzapi/api/apperr"
+    "github.com/khelechy/rielzapi/api/logging"
+    "github.com/khelechy/rielzapi/api/models"
+    "github.com/khelechy/rielzapi/api/policies"
+    "github.com/khelechy/rielzapi/api/responses"
+    "github.com/khelechy/rielzapi/api/uploads"
+)
+
+// multipartOverhead is the room left in upload bodies for the multipart
+// boundaries, headers and kind field around the file
+const multipartOverhead = 64 << 10
+
+// mediaHouse loads the house named in the route, checking the logged in user
+// may change its media
+func (a *App) mediaHouse(w http.ResponseWriter, r *http.Request) (*models.House, bool) {
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+    house, ok := a.loadHouse(w, r, id)
+    if !ok {
+        return nil, false
+    }
+    if forbid(w, r, policies.CanUpdateHouse(a.currentActor(r), house), "Unauthorized house update") {
+        return nil, false
+    }
+    if !ifMatch(w, r, house) {
+        return nil, false
+    }
+    return house, true
+}
+
+// UploadHouseMedia godoc
+// @Summary Upload a photo, floor plan or document of a house as multipart/form-data. Photos are JPEG, PNG or WebP images, documents PDFs and floor plans either; images get a thumbnail and the first photo becomes the cover.
+// @Accept  multipart/form-data
+// @Produce  json
+// @Param kind formData string true "photo, floor_plan or document"
+// @Param file formData file true "The file"
+// @Param If-Match header string false "ETag the upload is based on, answered with 412 once the house has changed"
+// @Router /api/houses/{id}/media [post]
+func (a *App) UploadHouseMedia(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Media uploaded successfully"}
+
+    house, ok := a.mediaHouse(w, r)
+    if !ok {
+        return
+    }
+
+    kind, fileName, data, err := a.readUpload(r)
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    file, err := uploads.Inspect(kind, data)
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    media := &models.HouseMedia{
+        HouseID:     house.ID,
+        Kind:        kind,
+        FileName:    fileName,
+        ContentType: file.ContentType,
+        Size:        int64(len(data)),
+        Width:       file.Width,
+        Height:      file.Height,
+    }
+    prefix := fmt.Sprintf("houses/%d", house.ID)
+    if media.Key, err = uploads.NewKey(prefix, file.Extension); err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    if err = a.blobs().Put(r.Context(), media.Key, bytes.NewReader(data), media.Size, media.ContentType); err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    if file.IsImage() {
+        media.ThumbnailKey = strings.TrimSuffix(media.Key, file.Extension) + "_thumb.jpg"
+        err = a.blobs().Put(r.Context(), media.ThumbnailKey, bytes.NewReader(file.Thumbnail), int64(len(file.Thumbnail)), "image/jpeg")
+        if err != nil {
+            a.deleteBlobs(r, media.Key)
+            responses.ERROR(w, r, err)
+            return
+        }
+    }
+
+    saved, err := a.houses(r).SaveHouseMedia(media)
+    if err != nil {
+        a.deleteBlobs(r, media.Key, media.ThumbnailKey) // nothing refers to them
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    resp["media"] = saved
+    responses.JSON(w, http.StatusCreated, resp)
+    return
+}
+
+// readUpload reads the kind and file fields of a multipart upload. The file
+// is read whole, as its type is sniffed and images are decoded anyway.
+func (a *App) readUpload(r *http.Request) (string, string, []byte, error) {
+    reader, err := r.MultipartReader()
+    if err != nil {
+        return "", "", nil, apperr.Malformed(err)
+    }
+    max := int64(a.Config.Storage.MaxUploadBytes)
+
+    var kind, fileName string
+    var data []byte
+    for {
+        part, err := reader.NextPart()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return "", "", nil, apperr.Malformed(err)
+        }
+        switch part.FormName() {
+        case "kind":
+            value, err := ioutil.ReadAll(io.LimitReader(part, 100))
+            if err != nil {
+                return "", "", nil, apperr.Malformed(err)
+            }
+            kind = strings.ToLower(strings.TrimSpace(string(value)))
+        case "file":
+            if data != nil {
+                return "", "", nil, apperr.InvalidField("file", "Upload one file at a time")
+            }
+            if data, err = ioutil.ReadAll(io.LimitReader(part, max+1)); err != nil {
+                return "", "", nil, apperr.Malformed(err)
+            }
+            if int64(len(data)) > max {
+                return "", "", nil, apperr.New(apperr.KindTooLarge, apperr.CodeBodyTooLarge, fmt.Sprintf("File is larger than %d bytes", max))
+            }
+            fileName = uploadName(part.FileName())
+        }
+        part.Close()
+    }
+
+    if !uploads.ValidKind(kind) {
+        return "", "", nil, apperr.InvalidField("kind", "kind must be photo, floor_plan or document")
+    }
+    if data == nil {
+        return "", "", nil, apperr.InvalidField("file", "file is required")
+    }
+    return kind, fileName, data, nil
+}
+
+// uploadName keeps the base of the name a file was uploaded with, for display
+func uploadName(name string) string {
+    name = strings.TrimSpace(filepath.Base(strings.Replace(name, "\\", "/", -1)))
+    if name == "." || name == "/" {
+        name = ""
+    }
+    if len(name) > 255 {
+        name = name[len(name)-255:]
+    }
+    return name
+}
+
+// deleteBlobs removes stored files best effort; files left behind only take space
+func (a *App) deleteBlobs(r *http.Request, keys ...string) {
+    for _, key := range keys {
+        if key == "" {
+            continue
+        }
+        if err := a.blobs().Delete(r.Context(), key); err != nil {
+            logging.FromContext(r.Context()).Warn("cannot delete blob", zap.String("key", key), zap.Error(err))
+        }
+    }
+}
+
+// GetHouseMedia godoc
+// @Summary List the photos, floor plans and documents of a house in the order they are shown
+// @Produce  json
+// @Router /api/houses/{id}/media [get]
+func (a *App) GetHouseMedia(w http.ResponseWriter, r *http.Request) {
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+    house, ok := a.loadHouse(w, r, id)
+    if !ok {
+        return
+    }
+    responses.JSON(w, http.StatusOK, house.Media)
+    return
+}
+
+// ReorderHouseMedia godoc
+// @Summary Set the order media of a house are shown in, listing the ID of each exactly once
+// @Accept  json
+// @Produce  json
+// @Param If-Match header string false "ETag the change is based on, answered with 412 once the house has changed"
+// @Router /api/houses/{id}/media/order [put]
+func (a *App) ReorderHouseMedia(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Media reordered successfully"}
+
+    house, ok := a.mediaHouse(w, r)
+    if !ok {
+        return
+    }
+
+    request := struct {
+        IDs []uint `json:"ids"`
+    }{}
+    if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+
+    media, err := a.houses(r).ReorderHouseMedia(house.ID, request.IDs)
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    resp["media"] = media
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// SetHouseCover godoc
+// @Summary Make a photo the cover of its house
+// @Produce  json
+// @Param If-Match header string false "ETag the change is based on, answered with 412 once the house has changed"
+// @Router /api/houses/{id}/media/{mediaId}/cover [put]
+func (a *App) SetHouseCover(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Cover set successfully"}
+
+    house, ok := a.mediaHouse(w, r)
+    if !ok {
+        return
+    }
+    media, ok := a.loadMedia(w, r, house)
+    if !ok {
+        return
+    }
+
+    cover, err := a.houses(r).SetHouseCover(media)
+    if err != nil { // ErrCoverNotPhoto answers 422
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    resp["media"] = cover
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// DeleteHouseMedia godoc
+// @Summary Delete a photo, floor plan or document of a house along with its files
+// @Produce  json
+// @Param If-Match header string false "ETag the change is based on, answered with 412 once the house has changed"
+// @Router /api/houses/{id}/media/{mediaId} [delete]
+func (a *App) DeleteHouseMedia(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Media deleted successfully"}
+
+    house, ok := a.mediaHouse(w, r)
+    if !ok {
+        return
+    }
+    media, ok := a.loadMedia(w, r, house)
+    if !ok {
+        return
+    }
+
+    if err := a.houses(r).DeleteHouseMedia(media); err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    a.deleteBlobs(r, media.Key, media.ThumbnailKey)
+
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/khelechy/rielzapi/api/blobstore"
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/repository"
+)
+
+// TestHouseCRUD lists, reads, replaces and deletes a house on each backend
+func TestHouseCRUD(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			a := newTestApp(t, store.open(t))
+			landlordID, landlord := signUp(t, a, "landlord@example.com", models.RoleLandlord)
+			houseID := createHouse(t, a, landlord, 2)
+			path := fmt.Sprintf("/api/houses/%d", houseID)
+
+			house := call(t, a, "GET", path, "", "").expect(t, http.StatusOK)
+			if got := house.str("location"); got != "Yaba" {
+				t.Errorf("house is in %q, want Yaba", got)
+			}
+			if got := uint(house.num("user_id")); got != landlordID {
+				t.Errorf("house belongs to user %d, want %d", got, landlordID)
+			}
+
+			houses := []models.House{}
+			list := call(t, a, "GET", fmt.Sprintf("/api/houses/landlord/%d", landlordID), "", "").expect(t, http.StatusOK)
+			if err := json.Unmarshal([]byte(list.Raw), &houses); err != nil {
+				t.Fatal(err)
+			}
+			if len(houses) != 1 || int(houses[0].ID) != houseID {
+				t.Errorf("landlord lists %v, want house %d", houses, houseID)
+			}
+
+			_, stranger := signUp(t, a, "stranger@example.com", models.RoleLandlord)
+			call(t, a, "PUT", path, stranger, houseJSON(3)).expect(t, http.StatusForbidden)
+			call(t, a, "DELETE", path, stranger, "").expect(t, http.StatusForbidden)
+
+			call(t, a, "PUT", path, landlord, houseJSON(3)).expect(t, http.StatusOK)
+			if rooms := call(t, a, "GET", path, "", "").expect(t, http.StatusOK).num("rooms"); rooms != 3 {
+				t.Errorf("house has %d rooms after the update, want 3", rooms)
+			}
+
+			call(t, a, "DELETE", path, landlord, "").expect(t, http.StatusOK)
+			list = call(t, a, "GET", fmt.Sprintf("/api/houses/landlord/%d", landlordID), "", "").expect(t, http.StatusOK)
+			if err := json.Unmarshal([]byte(list.Raw), &houses); err != nil {
+				t.Fatal(err)
+			}
+			if len(houses) != 0 {
+				t.Errorf("landlord still lists %d houses after the delete", len(houses))
+			}
+			call(t, a, "GET", path, "", "").expect(t, http.StatusNotFound)
+		})
+	}
+}
+
+// TestCreateHouseFreesEveryRoom checks that a new house, which has no leases,
+// is listed with all its rooms available whatever the body says
+func TestCreateHouseFreesEveryRoom(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			a := newTestApp(t, store.open(t))
+			_, landlord := signUp(t, a, "landlord@example.com", models.RoleLandlord)
+
+			for _, available := range []int{0, 5} {
+				body := fmt.Sprintf(`{"house_type":"Flat","state":"Lagos","description":"Two bedroom flat","location":"Yaba","rooms":3,"available_rooms":%d,"bathrooms":1,"price":1200000,"long_lat":"3.3792,6.5095"}`, available)
+				house := call(t, a, "POST", "/api/houses", landlord, body).expect(t, http.StatusCreated)
+				if rooms := house.num("house", "available_rooms"); rooms != 3 {
+					t.Errorf("house sent with %d available rooms has %d, want 3", available, rooms)
+				}
+			}
+
+			page := call(t, a, "GET", "/api/houses?available=true", "", "").expect(t, http.StatusOK)
+			if total := page.num("pagination", "total"); total != 2 {
+				t.Errorf("%d houses are listed as available, want 2", total)
+			}
+		})
+	}
+}
+
+// TestHouseUpdateKeepsAvailableRoomsFromLeases checks that the rooms left in a
+// house follow its leases rather than what an update says
+func TestHouseUpdateKeepsAvailableRoomsFromLeases(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			a := newTestApp(t, store.open(t))
+			_, landlord := signUp(t, a, "landlord@example.com", models.RoleLandlord)
+			houseID := createHouse(t, a, landlord, 3)
+			path := fmt.Sprintf("/api/houses/%d", houseID)
+			for i := 0; i < 2; i++ {
+				call(t, a, "POST", "/api/houses/tenant", landlord, tenantJSON(fmt.Sprintf("tenant%d@example.com", i), houseID)).expect(t, http.StatusCreated)
+			}
+
+			// a replacement claiming every room is free leaves the let ones let
+			house := call(t, a, "PUT", path, landlord, houseJSON(4)).expect(t, http.StatusOK)
+			if rooms := house.num("house", "available_rooms"); rooms != 2 {
+				t.Errorf("house has %d available rooms after PUT, want 2", rooms)
+			}
+			house = call(t, a, "PATCH", path, landlord, `{"available_rooms":4}`, "Content-Type", "application/merge-patch+json").expect(t, http.StatusOK)
+			if rooms := house.num("house", "available_rooms"); rooms != 2 {
+				t.Errorf("house has %d available rooms after PATCH, want 2", rooms)
+			}
+
+			// rooms may drop to the number let, but not below it
+			house = call(t, a, "PATCH", path, landlord, `{"rooms":2}`, "Content-Type", "application/merge-patch+json").expect(t, http.StatusOK)
+			if rooms := house.num("house", "available_rooms"); rooms != 0 {
+				t.Errorf("house has %d available rooms with every room let, want 0", rooms)
+			}
+			resp := call(t, a, "PUT", path, landlord, houseJSON(1)).expect(t, http.StatusConflict)
+			if code := resp.str("code"); code != "rooms_occupied" {
+				t.Errorf("got code %q, want rooms_occupied", code)
+			}
+		})
+	}
+}
+
+// TestHouseConditionalRequests walks a house through reads and edits guarded by
+// its ETag. Each step depends on the ones before it.
+func TestHouseConditionalRequests(t *testing.T) {
+	a := newTestApp(t, repository.NewMemoryStore())
+	_, landlord := signUp(t, a, "landlord@example.com", models.RoleLandlord)
+	houseID := createHouse(t, a, landlord, 2)
+	path := fmt.Sprintf("/api/houses/%d", houseID)
+	stale := fmt.Sprintf(`"house-%d-v999"`, houseID)
+	var created, patched string
+
+	steps := []struct {
+		name   string
+		method string
+		body   string
+		header string  // If-Match or If-None-Match
+		etag   *string // sent in the header
+		save   *string // set to the ETag answered
+		want   int
+		code   string
+	}{
+		{"read the house", "GET", "", "", nil, &created, http.StatusOK, ""},
+		{"unchanged house is not sent again", "GET", "", "If-None-Match", &created, nil, http.StatusNotModified, ""},
+		{"edit based on an unknown version", "PUT", houseJSON(2), "If-Match", &stale, nil, http.StatusPreconditionFailed, "house_modified"},
+		{"patch based on the current version", "PATCH", `{"price":1500000}`, "If-Match", &created, &patched, http.StatusOK, ""},
+		{"changed house is sent again", "GET", "", "If-None-Match", &created, nil, http.StatusOK, ""},
+		{"replace based on the old version", "PUT", houseJSON(2), "If-Match", &created, nil, http.StatusPreconditionFailed, "house_modified"},
+		{"delete based on the old version", "DELETE", "", "If-Match", &created, nil, http.StatusPreconditionFailed, "house_modified"},
+		{"delete based on the current version", "DELETE", "", "If-Match", &patched, nil, http.StatusOK, ""},
+		{"deleted house is gone", "GET", "", "", nil, nil, http.StatusNotFound, ""},
+	}
+	for _, step := range steps {
+		headers := []string{}
+		if step.etag != nil {
+			headers = append(headers, step.header, *step.etag)
+		}
+		resp := call(t, a, step.method, path, landlord, step.body, headers...)
+		if resp.Code != step.want {
+			t.Fatalf("%s: got status %d, want %d: %s", step.name, resp.Code, step.want, resp.Raw)
+		}
+		if step.code != "" && resp.str("code") != step.code {
+			t.Fatalf("%s: got code %q, want %q", step.name, resp.str("code"), step.code)
+		}
+		if step.save != nil {
+			if *step.save = resp.Header.Get("ETag"); *step.save == "" {
+				t.Fatalf("%s: no ETag answered", step.name)
+			}
+		}
+	}
+
+	if created == patched {
+		t.Errorf("ETag %s did not change when the house did", created)
+	}
+}
+
+// TestDeleteHouseDeletesMediaFiles checks that the files uploaded for a house
+// go with it
+func TestDeleteHouseDeletesMediaFiles(t *testing.T) {
+	a := newTestApp(t, repository.NewMemoryStore())
+	_, landlord := signUp(t, a, "landlord@example.com", models.RoleLandlord)
+	houseID := createHouse(t, a, landlord, 1)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("kind", "photo")
+	file, _ := form.CreateFormFile("file", "front.png")
+	if err := png.Encode(file, image.NewRGBA(image.Rect(0, 0, 640, 480))); err != nil {
+		t.Fatal(err)
+	}
+	form.Close()
+	call(t, a, "POST", fmt.Sprintf("/api/houses/%d/media", houseID), landlord, body.String(), "Content-Type", form.FormDataContentType()).
+		expect(t, http.StatusCreated)
+
+	dir := a.Blobs.(*blobstore.Local).Dir
+	if files := storedFiles(t, dir); len(files) != 2 {
+		t.Fatalf("upload stored %v, want the photo and its thumbnail", files)
+	}
+	call(t, a, "DELETE", fmt.Sprintf("/api/houses/%d", houseID), landlord, "").expect(t, http.StatusOK)
+	if files := storedFiles(t, dir); len(files) != 0 {
+		t.Errorf("files %v are left after the house was deleted", files)
+	}
+}
+
+// storedFiles lists the files under dir
+func storedFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// TestCreateHouseReportsInvalidFields checks that every field breaking a rule
+// is reported at once, under the name the client sent it by
+func TestCreateHouseReportsInvalidFields(t *testing.T) {
+	a := newTestApp(t, repository.NewMemoryStore())
+	_, landlord := signUp(t, a, "landlord@example.com", models.RoleLandlord)
+
+	body := `{"house_type":"","state":"Lagos","description":"Two bedroom flat","location":"Yaba","rooms":0,"bathrooms":1,"price":1200000,"long_lat":"200,6.5095"}`
+	resp := call(t, a, "POST", "/api/houses", landlord, body).expect(t, http.StatusUnprocessableEntity)
+	if code := resp.str("code"); code != "validation_failed" {
+		t.Errorf("got code %q, want validation_failed", code)
+	}
+	fields := map[string]string{}
+	errors, _ := resp.field("errors").([]interface{})
+	for _, e := range errors {
+		e := e.(map[string]interface{})
+		fields[e["field"].(string)] = e["message"].(string)
+	}
+	want := map[string]string{
+		"house_type": "is required",
+		"rooms":      "must be greater than 0",
+		"long_lat":   "must be a longitude,latitude pair on the globe, such as 3.3792,6.5244",
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("got errors %v, want %v", fields, want)
+	}
+	if total := call(t, a, "GET", "/api/houses", "", "").expect(t, http.StatusOK).num("pagination", "total"); total != 0 {
+		t.Errorf("%d houses were listed, want none", total)
+	}
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/khelechy/rielzapi/api/models"
+)
+
+// TestAddTenantDoesNotOverbook races requests for the last room of a house;
+// exactly one may get it
+func TestAddTenantDoesNotOverbook(t *testing.T) {
+	const requests = 20
+
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			a := newTestApp(t, store.open(t))
+			_, landlord := signUp(t, a, "landlord@example.com", models.RoleLandlord)
+			houseID := createHouse(t, a, landlord, 1)
+
+			responses := make([]*testResponse, requests)
+			var wg sync.WaitGroup
+			for i := 0; i < requests; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					email := fmt.Sprintf("tenant%d@example.com", i)
+					responses[i] = call(t, a, "POST", "/api/houses/tenant", landlord, tenantJSON(email, houseID))
+				}(i)
+			}
+			wg.Wait()
+
+			created := 0
+			for _, resp := range responses {
+				switch {
+				case resp.Code == http.StatusCreated:
+					created++
+				case resp.Code == http.StatusConflict && resp.str("code") == "no_available_rooms":
+				default:
+					t.Errorf("got %d, want 201 or 409 no_available_rooms: %s", resp.Code, resp.Raw)
+				}
+			}
+			if created != 1 {
+				t.Errorf("%d tenants got the only room, want 1", created)
+			}
+
+			leases := []models.Lease{}
+			resp := call(t, a, "GET", fmt.Sprintf("/api/houses/%d/tenants?status=current", houseID), landlord, "").expect(t, http.StatusOK)
+			if err := json.Unmarshal([]byte(resp.Raw), &leases); err != nil {
+				t.Fatal(err)
+			}
+			if len(leases) != 1 {
+				t.Errorf("house has %d active leases, want 1", len(leases))
+			}
+
+			house := call(t, a, "GET", fmt.Sprintf("/api/houses/%d", houseID), "", "").expect(t, http.StatusOK)
+			if rooms := house.num("available_rooms"); rooms != 0 {
+				t.Errorf("house has %d available rooms, want 0", rooms)
+			}
+		})
+	}
+}
+
+func TestTransferTenant(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			a := newTestApp(t, store.open(t))
+			_, landlord := signUp(t, a, "landlord@example.com", models.RoleLandlord)
+			from, to, full := createHouse(t, a, landlord, 1), createHouse(t, a, landlord, 1), createHouse(t, a, landlord, 1)
+			tenant := call(t, a, "POST", "/api/houses/tenant", landlord, tenantJSON("tenant@example.com", from)).expect(t, http.StatusCreated)
+			call(t, a, "POST", "/api/houses/tenant", landlord, tenantJSON("other@example.com", full)).expect(t, http.StatusCreated)
+			path := fmt.Sprintf("/api/tenants/%d/transfer", tenant.num("tenant", "ID"))
+
+			// run in order: only the last move succeeds
+			tests := []struct {
+				name    string
+				houseID int
+				want    int
+				code    string
+			}{
+				{"to the house the tenant lives in", from, http.StatusConflict, "tenant_already_in_house"},
+				{"to a house with no room left", full, http.StatusConflict, "no_available_rooms"},
+				{"to a house that does not exist", 9999, http.StatusNotFound, ""},
+				{"to a house with a free room", to, http.StatusOK, ""},
+			}
+			for _, tt := range tests {
+				resp := call(t, a, "POST", path, landlord, fmt.Sprintf(`{"house_id":%d,"rent_amount":1500000}`, tt.houseID))
+				if resp.Code != tt.want {
+					t.Fatalf("%s: got status %d, want %d: %s", tt.name, resp.Code, tt.want, resp.Raw)
+				}
+				if tt.code != "" && resp.str("code") != tt.code {
+					t.Fatalf("%s: got code %q, want %q", tt.name, resp.str("code"), tt.code)
+				}
+			}
+
+			for houseID, want := range map[int]int{from: 1, to: 0, full: 0} {
+				house := call(t, a, "GET", fmt.Sprintf("/api/houses/%d", houseID), "", "").expect(t, http.StatusOK)
+				if rooms := house.num("available_rooms"); rooms != want {
+					t.Errorf("house %d has %d available rooms, want %d", houseID, rooms, want)
+				}
+			}
+			for status, want := range map[string]int{"current": 0, "past": 1} {
+				leases := []models.Lease{}
+				resp := call(t, a, "GET", fmt.Sprintf("/api/houses/%d/tenants?status=%s", from, status), landlord, "").expect(t, http.StatusOK)
+				if err := json.Unmarshal([]byte(resp.Raw), &leases); err != nil {
+					t.Fatal(err)
+				}
+				if len(leases) != want {
+					t.Errorf("house moved out of has %d %s leases, want %d", len(leases), status, want)
+				}
+			}
+		})
+	}
+}
+
+// TestTransferTenantBeforeLeaseStart checks that a transfer dated before the
+// tenant moved in is refused as invalid rather than failing
+func TestTransferTenantBeforeLeaseStart(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			a := newTestApp(t, store.open(t))
+			_, landlord := signUp(t, a, "landlord@example.com", models.RoleLandlord)
+			from, to := createHouse(t, a, landlord, 1), createHouse(t, a, landlord, 1)
+			tenant := call(t, a, "POST", "/api/houses/tenant", landlord, tenantJSON("tenant@example.com", from)).expect(t, http.StatusCreated)
+
+			path := fmt.Sprintf("/api/tenants/%d/transfer", tenant.num("tenant", "ID"))
+			resp := call(t, a, "POST", path, landlord, fmt.Sprintf(`{"house_id":%d,"start_date":"2000-01-01T00:00:00Z"}`, to)).
+				expect(t, http.StatusUnprocessableEntity)
+			errors, _ := resp.field("errors").([]interface{})
+			if len(errors) != 1 || errors[0].(map[string]interface{})["field"] != "start_date" {
+				t.Errorf("got errors %v, want one for start_date", resp.field("errors"))
+			}
+
+			house := call(t, a, "GET", fmt.Sprintf("/api/houses/%d", from), "", "").expect(t, http.StatusOK)
+			if rooms := house.num("available_rooms"); rooms != 0 {
+				t.Errorf("house moved out of has %d available rooms, want 0", rooms)
+			}
+		})
+	}
+}
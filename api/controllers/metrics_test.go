@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/khelechy/rielzapi/api/models"
+)
+
+// TestTenantsGaugeCountsActiveLeases checks that tenants who moved out are not
+// counted as living in a house
+func TestTenantsGaugeCountsActiveLeases(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			a := newTestApp(t, store.open(t))
+			_, landlord := signUp(t, a, "landlord@example.com", models.RoleLandlord)
+			houseID := createHouse(t, a, landlord, 2)
+			call(t, a, "POST", "/api/houses/tenant", landlord, tenantJSON("stays@example.com", houseID)).expect(t, http.StatusCreated)
+			leaving := call(t, a, "POST", "/api/houses/tenant", landlord, tenantJSON("leaves@example.com", houseID)).expect(t, http.StatusCreated)
+			call(t, a, "POST", fmt.Sprintf("/api/leases/%d/end", leaving.num("lease", "ID")), landlord, "").expect(t, http.StatusOK)
+
+			metrics := call(t, a, "GET", "/metrics", "", "").expect(t, http.StatusOK)
+			if want := `rielzapi_tenants{state="Lagos"} 1`; !strings.Contains(metrics.Raw, want) {
+				t.Errorf("metrics do not report %s", want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/khelechy/rielzapi/api/apperr"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// errInvalidCursor is a bad request rather than a validation failure, as clients
+// only pass on cursors they were given
+var errInvalidCursor = apperr.BadRequest("invalid_cursor", "Cursor is invalid")
+
+// encodeCursor turns an offset into the opaque cursor handed to clients
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+// decodeCursor turns a cursor received from a client back into an offset
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 {
+		return 0, errInvalidCursor
+	}
+	return offset, nil
+}
+
+// pageParams reads the limit and cursor query parameters of a list request
+func pageParams(query url.Values) (limit, offset int, err error) {
+	limit = defaultPageLimit
+	if v := query.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return 0, 0, errors.New("limit must be a positive number")
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+	}
+	offset, err = decodeCursor(query.Get("cursor"))
+	return limit, offset, err
+}
+
+// nextPageURL returns the request URL pointing at the page after the current one
+func nextPageURL(r *http.Request, cursor string) string {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	return next.String()
+}
+
+// intParam reads an optional non-negative integer query parameter
+func intParam(query url.Values, name string) (int, error) {
+	v := query.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, errors.New(name + " must be a non-negative number")
+	}
+	return n, nil
+}
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/responses"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, offset := range []int{0, 1, 20, 12345} {
+		got, err := decodeCursor(encodeCursor(offset))
+		if err != nil || got != offset {
+			t.Errorf("cursor for offset %d decodes to %d, %v", offset, got, err)
+		}
+	}
+	for _, cursor := range []string{"not a cursor", encodeCursor(-1), "YWJj", "MTA="} {
+		if _, err := decodeCursor(cursor); err != errInvalidCursor {
+			t.Errorf("cursor %q returned %v, want errInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func TestPageParams(t *testing.T) {
+	tests := []struct {
+		query  string
+		limit  int
+		offset int
+		fails  bool
+	}{
+		{"", defaultPageLimit, 0, false},
+		{"limit=5", 5, 0, false},
+		{"limit=100", 100, 0, false},
+		{"limit=101", maxPageLimit, 0, false},
+		{"limit=5000", maxPageLimit, 0, false},
+		{"limit=0", 0, 0, true},
+		{"limit=-3", 0, 0, true},
+		{"limit=ten", 0, 0, true},
+		{"cursor=" + encodeCursor(40), defaultPageLimit, 40, false},
+		{"cursor=bad", 0, 0, true},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		limit, offset, err := pageParams(query)
+		if (err != nil) != tt.fails {
+			t.Errorf("%q: got error %v, want failure %v", tt.query, err, tt.fails)
+			continue
+		}
+		if !tt.fails && (limit != tt.limit || offset != tt.offset) {
+			t.Errorf("%q: got limit %d offset %d, want %d and %d", tt.query, limit, offset, tt.limit, tt.offset)
+		}
+	}
+}
+
+// housePage is the body of GET /api/houses
+type housePage struct {
+	Data       []models.House       `json:"data"`
+	Pagination responses.Pagination `json:"pagination"`
+}
+
+// TestGetHousesPages follows the next links through houses that all cost the
+// same, which must come in order of id with none repeated or skipped
+func TestGetHousesPages(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			a := newTestApp(t, store.open(t))
+			_, landlord := signUp(t, a, "landlord@example.com", models.RoleLandlord)
+			ids := []int{}
+			for i := 0; i < 5; i++ {
+				ids = append(ids, createHouse(t, a, landlord, 1))
+			}
+
+			for _, order := range []string{"asc", "desc"} {
+				want := append([]int{}, ids...)
+				if order == "desc" {
+					for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+						want[i], want[j] = want[j], want[i]
+					}
+				}
+				got := []int{}
+				next := "/api/houses?sort=price&order=" + order + "&limit=2"
+				for pages := 0; next != ""; pages++ {
+					if pages == len(ids) {
+						t.Fatalf("%s: pages do not end", order)
+					}
+					page := housePage{}
+					if err := json.Unmarshal([]byte(call(t, a, "GET", next, "", "").expect(t, http.StatusOK).Raw), &page); err != nil {
+						t.Fatal(err)
+					}
+					if page.Pagination.Total != len(ids) || page.Pagination.Limit != 2 {
+						t.Errorf("%s: page says total %d limit %d, want %d and 2", order, page.Pagination.Total, page.Pagination.Limit, len(ids))
+					}
+					for _, house := range page.Data {
+						got = append(got, int(house.ID))
+					}
+					next = page.Pagination.Next
+				}
+				if fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("%s: pages hold houses %v, want %v", order, got, want)
+				}
+			}
+
+			page := call(t, a, "GET", "/api/houses?limit=500", "", "").expect(t, http.StatusOK)
+			if limit := page.num("pagination", "limit"); limit != maxPageLimit {
+				t.Errorf("limit of 500 gave pages of %d, want %d", limit, maxPageLimit)
+			}
+			if cursor := page.str("pagination", "next_cursor"); cursor != "" {
+				t.Errorf("last page points on to %q", cursor)
+			}
+
+			resp := call(t, a, "GET", "/api/houses?cursor=bad", "", "").expect(t, http.StatusBadRequest)
+			if code := resp.str("code"); code != "invalid_cursor" {
+				t.Errorf("got code %q, want invalid_cursor", code)
+			}
+			call(t, a, "GET", "/api/houses?limit=0", "", "").expect(t, http.StatusUnprocessableEntity)
+			call(t, a, "GET", "/api/houses?sort=name", "", "").expect(t, http.StatusUnprocessableEntity)
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package controllers
+
+import (
+	"encoding/json"
+
+	"github.com/khelechy/rielzapi/api/mergepatch"
+)
+
+// mergePatch applies the JSON Merge Patch in body to the JSON form of current
+// and decodes the result into patched
+func mergePatch(current interface{}, body []byte, patched interface{}) error {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if doc, err = mergepatch.Apply(doc, body); err != nil {
+		return err
+	}
+	return json.Unmarshal(doc, patched)
+}
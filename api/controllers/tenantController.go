@@ -0,0 +1,334 @@
+package controllers
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "github.com/jinzhu/gorm"
+
+    "github.com/khelechy/rielzapi/api/apperr"
+    "github.com/khelechy/rielzapi/api/models"
+    "github.com/khelechy/rielzapi/api/policies"
+    "github.com/khelechy/rielzapi/api/responses"
+)
+
+// errEmailNotVerified refuses tenants their leases until they verified the email the leases are found by
+var errEmailNotVerified = apperr.Forbidden("email_not_verified", "Verify your email to see your leases")
+
+// leaseRequest carries the lease terms sent along with a new or transferred tenant
+type leaseRequest struct {
+    StartDate  *time.Time `json:"start_date"`
+    EndDate    *time.Time `json:"end_date"`
+    RentAmount *int       `json:"rent_amount"`
+}
+
+// lease builds a lease from the request, charging the house price when no rent is given
+func (l leaseRequest) lease(house *models.House) *models.Lease {
+    lease := &models.Lease{EndDate: l.EndDate, RentAmount: house.Price}
+    if l.StartDate != nil {
+        lease.StartDate = *l.StartDate
+    }
+    if l.RentAmount != nil {
+        lease.RentAmount = *l.RentAmount
+    }
+    lease.Prepare()
+    return lease
+}
+
+// manageableTenant loads the tenant named in the route, checking the logged in
+// user may manage the tenants of the house it lives in
+func (a *App) manageableTenant(w http.ResponseWriter, r *http.Request) (*models.Tenant, bool) {
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+    tenant, ok := a.loadTenant(w, r, id)
+    if !ok {
+        return nil, false
+    }
+    house, ok := a.loadHouse(w, r, tenant.HouseId)
+    if !ok {
+        return nil, false
+    }
+    if forbid(w, r, policies.CanManageTenants(a.currentActor(r), house), "Unauthorized access to tenant") {
+        return nil, false
+    }
+    return tenant, true
+}
+
+// GetHouseTenants godoc
+// @Summary List tenants of a house with their leases
+// @Accept  json
+// @Produce  json
+// @Param status query string false "current, past or empty for all"
+// @Router /api/houses/{id}/tenants [get]
+func (a *App) GetHouseTenants(w http.ResponseWriter, r *http.Request) {
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+    house, ok := a.loadHouse(w, r, id)
+    if !ok {
+        return
+    }
+    if forbid(w, r, policies.CanManageTenants(a.currentActor(r), house), "Unauthorized access to house tenants") {
+        return
+    }
+
+    status := strings.ToLower(r.URL.Query().Get("status"))
+    if status != "" && status != "current" && status != "past" {
+        responses.ERROR(w, r, apperr.InvalidField("status", "status must be current or past"))
+        return
+    }
+
+    leases, err := a.tenants(r).GetLeasesByHouse(house.ID, status)
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    responses.JSON(w, http.StatusOK, leases)
+    return
+}
+
+// GetTenantById godoc
+// @Summary Get Tenant By Id
+// @Accept  json
+// @Produce  json
+// @Router /api/tenants/{id} [get]
+func (a *App) GetTenantById(w http.ResponseWriter, r *http.Request) {
+    tenant, ok := a.manageableTenant(w, r)
+    if !ok {
+        return
+    }
+    responses.JSON(w, http.StatusOK, tenant)
+    return
+}
+
+// UpdateTenant godoc
+// @Summary Update Tenant details
+// @Accept  json
+// @Produce  json
+// @Router /api/tenants/{id} [put]
+func (a *App) UpdateTenant(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Tenant updated successfully"}
+
+    tenant, ok := a.manageableTenant(w, r)
+    if !ok {
+        return
+    }
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+
+    tenantUpdate := *tenant // checked as it will be saved
+    if err = json.Unmarshal(body, &tenantUpdate); err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+
+    tenantUpdate.Prepare()
+    if err = tenantUpdate.Validate(); err != nil {
+        responses.ERROR(w, r, apperr.Invalid(err))
+        return
+    }
+
+    _, err = a.tenants(r).UpdateTenant(int(tenant.ID), &tenantUpdate)
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// DeleteTenant godoc
+// @Summary Remove Tenant, terminating the active lease
+// @Accept  json
+// @Produce  json
+// @Router /api/tenants/{id} [delete]
+func (a *App) DeleteTenant(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Tenant removed successfully"}
+
+    tenant, ok := a.manageableTenant(w, r)
+    if !ok {
+        return
+    }
+
+    if err := a.tenants(r).DeleteTenant(tenant); err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// TransferTenant godoc
+// @Summary Move Tenant to another house
+// @Accept  json
+// @Produce  json
+// @Router /api/tenants/{id}/transfer [post]
+func (a *App) TransferTenant(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Tenant transferred successfully"}
+
+    tenant, ok := a.manageableTenant(w, r)
+    if !ok {
+        return
+    }
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+
+    transfer := struct {
+        leaseRequest
+        HouseId int `json:"house_id"`
+    }{}
+    if err = json.Unmarshal(body, &transfer); err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+
+    if transfer.HouseId <= 0 {
+        responses.ERROR(w, r, apperr.InvalidField("house_id", "HouseId of house is invalid"))
+        return
+    }
+    if transfer.HouseId == tenant.HouseId {
+        responses.ERROR(w, r, apperr.Conflict("tenant_already_in_house", "Tenant already lives in this house"))
+        return
+    }
+
+    house, ok := a.loadHouse(w, r, transfer.HouseId)
+    if !ok {
+        return
+    }
+    if forbid(w, r, policies.CanAddTenant(a.currentActor(r), house), "Unauthorized transfer to house") {
+        return
+    }
+
+    lease := transfer.lease(house)
+    if err = lease.Validate(); err != nil {
+        responses.ERROR(w, r, apperr.Invalid(err))
+        return
+    }
+
+    // the new lease starts the day the current one ends, which cannot be before it began
+    current, err := a.tenants(r).GetActiveLease(tenant.ID)
+    if err != nil {
+        if gorm.IsRecordNotFoundError(err) {
+            err = models.ErrLeaseNotActive
+        }
+        responses.ERROR(w, r, err)
+        return
+    }
+    if lease.StartDate.Before(current.StartDate) {
+        responses.ERROR(w, r, apperr.InvalidField("start_date", "Start date of lease cannot be before the start of the tenant's current lease"))
+        return
+    }
+
+    leaseCreated, err := a.tenants(r).TransferTenant(tenant, transfer.HouseId, lease)
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    resp["lease"] = leaseCreated
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// EndLease godoc
+// @Summary End or terminate a lease, freeing its room
+// @Accept  json
+// @Produce  json
+// @Router /api/leases/{id}/end [post]
+func (a *App) EndLease(w http.ResponseWriter, r *http.Request) {
+    var resp = map[string]interface{}{"status": "success", "message": "Lease ended successfully"}
+
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+    lease, ok := a.loadLease(w, r, id)
+    if !ok {
+        return
+    }
+    house, ok := a.loadHouse(w, r, int(lease.HouseID))
+    if !ok {
+        return
+    }
+    if forbid(w, r, policies.CanManageTenants(a.currentActor(r), house), "Unauthorized access to lease") {
+        return
+    }
+
+    end := struct {
+        Status  string     `json:"status"`
+        EndDate *time.Time `json:"end_date"`
+    }{}
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return
+    }
+    if len(body) > 0 {
+        if err = json.Unmarshal(body, &end); err != nil {
+            responses.ERROR(w, r, apperr.Malformed(err))
+            return
+        }
+    }
+
+    status := strings.ToLower(strings.TrimSpace(end.Status))
+    if status == "" {
+        status = models.LeaseEnded
+    }
+    if status != models.LeaseEnded && status != models.LeaseTerminated {
+        responses.ERROR(w, r, apperr.InvalidField("status", "Status of lease must be ended or terminated"))
+        return
+    }
+    endDate := time.Now()
+    if end.EndDate != nil {
+        endDate = *end.EndDate
+    }
+    if endDate.Before(lease.StartDate) {
+        responses.ERROR(w, r, apperr.InvalidField("end_date", "End date of lease cannot be before its start date"))
+        return
+    }
+
+    leaseEnded, err := a.tenants(r).EndLease(lease, status, endDate)
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+
+    resp["lease"] = leaseEnded
+    responses.JSON(w, http.StatusOK, resp)
+    return
+}
+
+// GetMyLeases godoc
+// @Summary List the leases of the logged in tenant, found by their email once they have verified it
+// @Accept  json
+// @Produce  json
+// @Router /api/me/leases [get]
+func (a *App) GetMyLeases(w http.ResponseWriter, r *http.Request) {
+    user, ok := a.loadUser(w, r, int(a.currentActor(r).UserID))
+    if !ok {
+        return
+    }
+    if !user.EmailVerified() { // leases are matched by email, so the user must show they own it
+        responses.ERROR(w, r, errEmailNotVerified)
+        return
+    }
+
+    leases, err := a.tenants(r).GetLeasesByTenantEmail(user.Email)
+    if err != nil {
+        responses.ERROR(w, r, err)
+        return
+    }
+    responses.JSON(w, http.StatusOK, leases)
+    return
+}
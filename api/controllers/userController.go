@@ -8,10 +8,16 @@ import (
     "strconv"
 
     "github.com/gorilla/mux"
+    "github.com/jinzhu/gorm"
+    "go.uber.org/zap"
 
+    "github.com/khelechy/rielzapi/api/apperr"
+    "github.com/khelechy/rielzapi/api/dto"
+    "github.com/khelechy/rielzapi/api/logging"
     "github.com/khelechy/rielzapi/api/models"
+    "github.com/khelechy/rielzapi/api/policies"
     "github.com/khelechy/rielzapi/api/responses"
-    "github.com/khelechy/rielzapi/utils"
+    "github.com/khelechy/rielzapi/api/tracing"
 )
 
 // signup godoc
@@ -23,40 +29,48 @@ import (
 func (a *App) UserSignUp(w http.ResponseWriter, r *http.Request) {
     var resp = map[string]interface{}{"status": "success", "message": "Registered successfully"}
 
-    user := &models.User{}
     body, err := ioutil.ReadAll(r.Body)
     if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+        responses.ERROR(w, r, apperr.Malformed(err))
         return
     }
 
-    err = json.Unmarshal(body, &user)
+    request := dto.SignupRequest{}
+    err = json.Unmarshal(body, &request)
     if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+        responses.ERROR(w, r, apperr.Malformed(err))
         return
     }
+    user := request.User() // here strip the text of white spaces
 
-    usr, _ := user.GetUser(a.DB)
+    usr, _ := a.users(r).GetUserByEmail(user.Email)
     if usr != nil {
-        resp["status"] = "failed"
-        resp["message"] = "User already registered, please login"
-        responses.JSON(w, http.StatusBadRequest, resp)
+        responses.ERROR(w, r, apperr.Conflict("email_taken", "User already registered, please login"))
         return
     }
 
-    user.Prepare() // here strip the text of white spaces
+    if user.Role == "" {
+        user.Role = models.RoleLandlord
+    }
+    if user.Role != models.RoleLandlord { // other roles are granted by an admin
+        responses.ERROR(w, r, apperr.Forbidden("role_signup_forbidden", "Only landlords can register, other roles are assigned by an admin"))
+        return
+    }
 
     err = user.Validate("") // default were all fields(email, lastname, firstname, password, profileimage) are validated
     if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+        responses.ERROR(w, r, apperr.Invalid(err))
         return
     }
-    userCreated, err := user.SaveUser(a.DB)
+    userCreated, err := a.users(r).SaveUser(user)
     if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+        responses.ERROR(w, r, err)
         return
     }
-    resp["user"] = userCreated
+    if err = a.sendEmailVerification(r, userCreated); err != nil {
+        logging.FromContext(r.Context()).Error("cannot send email verification", zap.Uint("user_id", userCreated.ID), zap.Error(err))
+    }
+    resp["user"] = dto.NewUser(userCreated, policies.VisibilitySelf)
     responses.JSON(w, http.StatusCreated, resp)
     return
 }
@@ -71,54 +85,57 @@ func (a *App) UserSignUp(w http.ResponseWriter, r *http.Request) {
 func (a *App) Login(w http.ResponseWriter, r *http.Request) {
     var resp = map[string]interface{}{"status": "success", "message": "logged in"}
 
-    user := &models.User{}
     body, err := ioutil.ReadAll(r.Body) // read user input from request
     if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+        responses.ERROR(w, r, apperr.Malformed(err))
         return
     }
 
-    err = json.Unmarshal(body, &user)
+    request := dto.LoginRequest{}
+    err = json.Unmarshal(body, &request)
     if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+        responses.ERROR(w, r, apperr.Malformed(err))
         return
     }
-
-    user.Prepare() // here strip the text of white spaces
+    user := request.User() // here strip the text of white spaces
 
     err = user.Validate("login") // fields(email, password) are validated
     if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+        responses.ERROR(w, r, apperr.Invalid(err))
         return
     }
 
-    usr, err := user.GetUser(a.DB)
-    if err != nil {
-        responses.ERROR(w, http.StatusInternalServerError, err)
+    // an unknown email and a wrong password get the same answer, so logins
+    // cannot be used to find out who is registered
+    invalidCredentials := apperr.Unauthorized("invalid_credentials", "Login failed, please try again")
+
+    usr, err := a.users(r).GetUserByEmail(user.Email)
+    if gorm.IsRecordNotFoundError(err) || (err == nil && usr == nil) {
+        responses.ERROR(w, r, invalidCredentials)
         return
     }
-
-    if usr == nil { // user is not registered
-        resp["status"] = "failed"
-        resp["message"] = "Login failed, please signup"
-        responses.JSON(w, http.StatusBadRequest, resp)
+    if err != nil {
+        responses.ERROR(w, r, err)
         return
     }
 
+    _, span := tracing.Start(r.Context(), "bcrypt.CompareHashAndPassword") // bcrypt is slow on purpose, keep it apart from the queries
     err = models.CheckPasswordHash(user.Password, usr.Password)
+    span.End()
     if err != nil {
-        resp["status"] = "failed"
-        resp["message"] = "Login failed, please try again"
-        responses.JSON(w, http.StatusForbidden, resp)
+        responses.ERROR(w, r, invalidCredentials)
         return
     }
-    token, err := utils.EncodeAuthToken(usr.ID)
-    if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+    if usr.Suspended {
+        responses.ERROR(w, r, errAccountSuspended)
+        return
+    }
+
+    if err = a.startSession(r, resp, usr); err != nil {
+        responses.ERROR(w, r, err)
         return
     }
 
-    resp["token"] = token
     responses.JSON(w, http.StatusOK, resp)
     return
 }
@@ -129,12 +146,12 @@ func (a *App) Login(w http.ResponseWriter, r *http.Request) {
 // @Produce  json
 // @Router /api/users [post]
 func (a *App) GetUsers(w http.ResponseWriter, r *http.Request) {
-    houses, err := models.GetAllUsers(a.DB)
+    users, err := a.users(r).GetUsers()
     if err != nil {
-        responses.ERROR(w, http.StatusInternalServerError, err)
+        responses.ERROR(w, r, err)
         return
     }
-    responses.JSON(w, http.StatusOK, houses)
+    responses.JSON(w, http.StatusOK, dto.NewUsers(*users, a.currentActor(r)))
     return
 }
 
@@ -150,59 +167,102 @@ func (a *App) GetUserById(w http.ResponseWriter, r *http.Request){
 
     id, _ := strconv.Atoi(vars["id"])
 
-    user, err := models.GetUserById(id, a.DB)
-    if err != nil{
-        responses.ERROR(w, http.StatusInternalServerError, err)
+    user, ok := a.loadUser(w, r, id)
+    if !ok {
         return
     }
-    responses.JSON(w, http.StatusOK, user)
+    responses.JSON(w, http.StatusOK, dto.NewUser(user, policies.UserVisibility(a.currentActor(r), user)))
     return
 }
 
-// Update User godoc
-// @Summary Update landlords
-// @Accept  json
-// @Produce  json
-// @Router /api/users/id [put]
-func (a *App) UpdateUser(w http.ResponseWriter, r *http.Request) {
-    var resp = map[string]interface{}{"status": "success", "message": "User updated successfully"}
+// editableUser loads the user named in the route, checking the logged in user
+// may edit them, and reads the body of the edit
+func (a *App) editableUser(w http.ResponseWriter, r *http.Request) (*models.User, []byte, bool) {
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
 
-    vars := mux.Vars(r)
+    user, ok := a.loadUser(w, r, id)
+    if !ok {
+        return nil, nil, false
+    }
+    if forbid(w, r, policies.CanUpdateUser(a.currentActor(r), user), "Unauthorized user update") {
+        return nil, nil, false
+    }
 
-    myUser := r.Context().Value("userID").(float64)
-    userID := uint(myUser)
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
+        return nil, nil, false
+    }
+    return user, body, true
+}
 
-    id, _ := strconv.Atoi(vars["id"])
+// replaceUser saves the profile in request over that of user and answers with
+// the user as saved. A changed email is mailed a new verification link.
+func (a *App) replaceUser(w http.ResponseWriter, r *http.Request, user *models.User, request dto.UserUpdateRequest) {
+    var resp = map[string]interface{}{"status": "success", "message": "User updated successfully"}
 
-    user, err := models.GetUserById(id, a.DB)
+    update := request.Apply(*user)
+    if err := update.Validate(""); err != nil {
+        responses.ERROR(w, r, apperr.Invalid(err))
+        return
+    }
 
-    if user.ID != userID {
-        resp["status"] = "failed"
-        resp["message"] = "Unauthorized user update"
-        responses.JSON(w, http.StatusUnauthorized, resp)
+    if _, err := a.users(r).UpdateUser(int(user.ID), update); err != nil {
+        responses.ERROR(w, r, err)
         return
     }
+    updated, ok := a.loadUser(w, r, int(user.ID))
+    if !ok {
+        return
+    }
+    if updated.Email != user.Email { // the new address is unverified until this link is followed
+        if err := a.sendEmailVerification(r, updated); err != nil {
+            logging.FromContext(r.Context()).Error("cannot send email verification", zap.Uint("user_id", updated.ID), zap.Error(err))
+        }
+    }
 
-    body, err := ioutil.ReadAll(r.Body)
-    if err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+    resp["user"] = dto.NewUser(updated, policies.UserVisibility(a.currentActor(r), updated))
+    responses.JSON(w, http.StatusOK, resp)
+}
+
+// Update User godoc
+// @Summary Replace the profile of a user. Every field is required, as on signup.
+// @Accept  json
+// @Produce  json
+// @Router /api/users/id [put]
+func (a *App) UpdateUser(w http.ResponseWriter, r *http.Request) {
+    user, body, ok := a.editableUser(w, r)
+    if !ok {
         return
     }
 
-    userUpdate := models.User{}
-    if err = json.Unmarshal(body, &userUpdate); err != nil {
-        responses.ERROR(w, http.StatusBadRequest, err)
+    request := dto.UserUpdateRequest{}
+    if err := json.Unmarshal(body, &request); err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
         return
     }
 
-    userUpdate.Prepare()
+    a.replaceUser(w, r, user, request)
+    return
+}
+
+// Patch User godoc
+// @Summary Change some fields of the profile of a user with a JSON Merge Patch
+// @Accept  application/merge-patch+json
+// @Produce  json
+// @Router /api/users/id [patch]
+func (a *App) PatchUser(w http.ResponseWriter, r *http.Request) {
+    user, body, ok := a.editableUser(w, r)
+    if !ok {
+        return
+    }
 
-    _, err = userUpdate.UpdateUser(id, a.DB)
-    if err != nil {
-        responses.ERROR(w, http.StatusInternalServerError, err)
+    request := dto.UserUpdateRequest{}
+    if err := mergePatch(dto.NewUserUpdateRequest(user), body, &request); err != nil {
+        responses.ERROR(w, r, apperr.Malformed(err))
         return
     }
 
-    responses.JSON(w, http.StatusOK, resp)
+    a.replaceUser(w, r, user, request)
     return
 }
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/repository"
+)
+
+// TestUserFieldVisibility checks the fields of a landlord's profile each kind
+// of viewer is shown
+func TestUserFieldVisibility(t *testing.T) {
+	a := newTestApp(t, repository.NewMemoryStore())
+	landlordID, landlord := signUp(t, a, "landlord@example.com", models.RoleLandlord)
+	_, stranger := signUp(t, a, "stranger@example.com", models.RoleLandlord)
+	agentID, agent := signUp(t, a, "agent@example.com", models.RoleAgent)
+	_, otherAgent := signUp(t, a, "other.agent@example.com", models.RoleAgent)
+	_, admin := signUp(t, a, "admin@example.com", models.RoleAdmin)
+	call(t, a, "POST", "/api/agents", landlord, fmt.Sprintf(`{"agent_id":%d}`, agentID)).expect(t, http.StatusCreated)
+
+	public := "firstname id lastname role"
+	contact := "email firstname id lastname phonenumber role"
+	self := "created_at email email_verified firstname id lastname phonenumber role"
+	full := "created_at email email_verified firstname id lastname phonenumber role suspended updated_at"
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"anonymous", "", public},
+		{"another landlord", stranger, public},
+		{"agent of another landlord", otherAgent, public},
+		{"agent of the landlord", agent, contact},
+		{"the landlord", landlord, self},
+		{"admin", admin, full},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := call(t, a, "GET", fmt.Sprintf("/api/users/%d", landlordID), tt.token, "").expect(t, http.StatusOK)
+			keys := []string{}
+			for key := range resp.Body {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if got := strings.Join(keys, " "); got != tt.want {
+				t.Errorf("got fields %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Package dto holds the request bodies the API accepts and the response bodies it
+// returns, so that models are never decoded from or encoded to JSON directly.
+package dto
+
+import (
+	"time"
+
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/policies"
+)
+
+// SignupRequest is the body of POST /register
+type SignupRequest struct {
+	Email       string `json:"email"`
+	FirstName   string `json:"firstname"`
+	LastName    string `json:"lastname"`
+	Password    string `json:"password"`
+	PhoneNumber string `json:"phonenumber"`
+	Role        string `json:"role"`
+}
+
+// User builds the account to register. Role is taken as given and must be checked by the caller.
+func (s SignupRequest) User() *models.User {
+	user := &models.User{
+		Email:       s.Email,
+		FirstName:   s.FirstName,
+		LastName:    s.LastName,
+		Password:    s.Password,
+		PhoneNumber: s.PhoneNumber,
+		Role:        s.Role,
+	}
+	user.Prepare()
+	return user
+}
+
+// LoginRequest is the body of POST /login
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// User builds the user to look up and check the password of
+func (l LoginRequest) User() *models.User {
+	user := &models.User{Email: l.Email, Password: l.Password}
+	user.Prepare()
+	return user
+}
+
+// UserUpdateRequest is the body of PUT /api/users/{id}, and what PATCH merges its
+// patch into. Role, suspension and password have their own endpoints and cannot be
+// changed here.
+type UserUpdateRequest struct {
+	Email       string `json:"email"`
+	FirstName   string `json:"firstname"`
+	LastName    string `json:"lastname"`
+	PhoneNumber string `json:"phonenumber"`
+}
+
+// NewUserUpdateRequest holds the fields of the user that can be updated
+func NewUserUpdateRequest(user *models.User) UserUpdateRequest {
+	return UserUpdateRequest{
+		Email:       user.Email,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		PhoneNumber: user.PhoneNumber,
+	}
+}
+
+// Apply returns the user with every updatable field replaced by the request,
+// empty ones included
+func (u UserUpdateRequest) Apply(user models.User) *models.User {
+	user.Email = u.Email
+	user.FirstName = u.FirstName
+	user.LastName = u.LastName
+	user.PhoneNumber = u.PhoneNumber
+	user.Prepare()
+	return &user
+}
+
+// PublicUser is the profile anyone may see
+type PublicUser struct {
+	ID        uint   `json:"id"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	Role      string `json:"role"`
+}
+
+// ContactUser adds the contact details shared with users who work together
+type ContactUser struct {
+	PublicUser
+	Email       string `json:"email"`
+	PhoneNumber string `json:"phonenumber"`
+}
+
+// SelfUser is what users see of their own account
+type SelfUser struct {
+	ContactUser
+	EmailVerified bool      `json:"email_verified"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// AdminUser is what admins see of an account
+type AdminUser struct {
+	SelfUser
+	Suspended bool      `json:"suspended"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewUser returns the view of the user for the given visibility
+func NewUser(user *models.User, visibility policies.Visibility) interface{} {
+	public := PublicUser{ID: user.ID, FirstName: user.FirstName, LastName: user.LastName, Role: user.Role}
+	if visibility == policies.VisibilityPublic {
+		return public
+	}
+	contact := ContactUser{PublicUser: public, Email: user.Email, PhoneNumber: user.PhoneNumber}
+	if visibility == policies.VisibilityContact {
+		return contact
+	}
+	self := SelfUser{ContactUser: contact, EmailVerified: user.EmailVerified(), CreatedAt: user.CreatedAt}
+	if visibility == policies.VisibilitySelf {
+		return self
+	}
+	return AdminUser{SelfUser: self, Suspended: user.Suspended, UpdatedAt: user.UpdatedAt}
+}
+
+// NewUsers returns the view of each user as the actor may see it
+func NewUsers(users []models.User, actor policies.Actor) []interface{} {
+	views := make([]interface{}, 0, len(users))
+	for i := range users {
+		views = append(views, NewUser(&users[i], policies.UserVisibility(actor, &users[i])))
+	}
+	return views
+}
@@ -0,0 +1,92 @@
+// Package logging builds the structured logger of the API and carries the
+// logger of each request, tagged with its request ID, through the context.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/khelechy/rielzapi/config"
+)
+
+// New builds a leveled logger writing JSON, or readable console lines, to stderr
+func New(cfg config.Log) (*zap.Logger, error) {
+	level, err := zapcore.ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	var zc zap.Config
+	if cfg.Format == "console" {
+		zc = zap.NewDevelopmentConfig()
+	} else {
+		zc = zap.NewProductionConfig()
+		zc.EncoderConfig.TimeKey = "time"
+		zc.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		zc.Sampling = nil // access logs must not be dropped
+	}
+	zc.Level = zap.NewAtomicLevelAt(level)
+	zc.DisableStacktrace = true
+	return zc.Build()
+}
+
+type contextKey struct{}
+
+// WithLogger returns a context carrying logger
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of the request, or a no-op logger outside of one
+func FromContext(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return zap.NewNop()
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the ID of the request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request, empty outside of one
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// GormLogger sends gorm's output to a structured logger. Statements are logged
+// at debug level without their bound values, which may hold password hashes or
+// token hashes; database errors are logged as warnings.
+type GormLogger struct {
+	Logger *zap.Logger
+}
+
+// Print receives gorm's log values: a kind, the calling source line, then
+// duration, statement, values and rows affected for "sql" entries
+func (g GormLogger) Print(values ...interface{}) {
+	if len(values) < 2 {
+		return
+	}
+	source := zap.String("source", fmt.Sprint(values[1]))
+
+	switch values[0] {
+	case "sql":
+		if len(values) < 6 {
+			return
+		}
+		duration, _ := values[2].(time.Duration)
+		rows, _ := values[5].(int64)
+		g.Logger.Debug("sql", source, zap.Duration("duration", duration),
+			zap.String("query", fmt.Sprint(values[3])), zap.Int64("rows", rows))
+	default:
+		g.Logger.Warn("database", source, zap.String("message", fmt.Sprint(values[2:]...)))
+	}
+}
@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/khelechy/rielzapi/config"
+)
+
+func TestNew(t *testing.T) {
+	for _, cfg := range []config.Log{{Level: "debug", Format: "json"}, {Level: "warn", Format: "console"}} {
+		if _, err := New(cfg); err != nil {
+			t.Errorf("%v: %v", cfg, err)
+		}
+	}
+	if _, err := New(config.Log{Level: "loud", Format: "json"}); err == nil {
+		t.Error("unknown level was accepted")
+	}
+}
+
+// TestGormLogger logs statements without the values bound to them
+func TestGormLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := GormLogger{Logger: zap.New(core)}
+
+	logger.Print("sql", "models/user.go:42", 3*time.Millisecond, `SELECT * FROM "users" WHERE (email = $1)`, []interface{}{"secret-hash"}, int64(1))
+	logger.Print("log", "models/user.go:50", "pq: relation \"users\" does not exist")
+	logger.Print("sql") // too short to be an entry
+
+	entries := logs.TakeAll()
+	if len(entries) != 2 {
+		t.Fatalf("logged %d lines, want 2", len(entries))
+	}
+	statement := entries[0]
+	if statement.Level != zapcore.DebugLevel || statement.ContextMap()["rows"] != int64(1) || statement.ContextMap()["source"] != "models/user.go:42" {
+		t.Errorf("statement logged as %s with %v", statement.Level, statement.ContextMap())
+	}
+	for _, field := range statement.Context {
+		if field.String == "secret-hash" {
+			t.Error("a bound value was logged")
+		}
+	}
+	if entries[1].Level != zapcore.WarnLevel || entries[1].ContextMap()["message"] != `pq: relation "users" does not exist` {
+		t.Errorf("database error logged as %s with %v", entries[1].Level, entries[1].ContextMap())
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	if RequestID(ctx) != "" || FromContext(ctx) == nil {
+		t.Error("a context outside of a request needs no ID and a usable logger")
+	}
+	logger := zap.NewExample()
+	ctx = WithLogger(WithRequestID(ctx, "req-1"), logger)
+	if RequestID(ctx) != "req-1" || FromContext(ctx) != logger {
+		t.Error("request ID or logger was not carried by the context")
+	}
+}
@@ -0,0 +1,144 @@
+// Package mailer delivers the emails the API sends to users. Production uses SMTP;
+// the file and memory mailers keep messages local for development and tests.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/khelechy/rielzapi/api/tracing"
+	"github.com/khelechy/rielzapi/config"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// render formats a message as an RFC 5322 email
+func render(from string, msg Message) []byte {
+	header := strings.NewReplacer("\r", "", "\n", "") // keep user input from adding headers
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", header.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", header.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", header.Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// SMTPMailer sends messages through an SMTP server, authenticating when a username is set
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers the message to the SMTP server
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, m.From, []string{msg.To}, render(m.From, msg))
+}
+
+// FileMailer writes each message as an .eml file into a directory
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// Send writes the message to a new file
+func (m *FileMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To))
+	return ioutil.WriteFile(filepath.Join(m.Dir, name), render(m.From, msg), 0o644)
+}
+
+// MemoryMailer keeps sent messages in memory so tests can inspect them
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send records the message
+func (m *MemoryMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// Traced records a span, a child of any span in ctx, for each message sent
+// through m. Recipients are left out of the span as they are personal data.
+func Traced(ctx context.Context, m Mailer) Mailer {
+	return tracedMailer{ctx: ctx, next: m}
+}
+
+type tracedMailer struct {
+	ctx  context.Context
+	next Mailer
+}
+
+func (t tracedMailer) Send(msg Message) error {
+	_, span := tracing.Start(t.ctx, "Mailer.Send", attribute.String("mailer", fmt.Sprintf("%T", t.next)))
+	err := t.next.Send(msg)
+	tracing.End(span, err)
+	return err
+}
+
+// FromConfig builds the mailer selected by the mail settings: smtp, file, which
+// writes messages under the mail directory, or memory
+func FromConfig(cfg config.Mail) (Mailer, error) {
+	switch strings.ToLower(cfg.Mailer) {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, errors.New("SMTP_HOST must be set when MAILER is smtp")
+		}
+		return &SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}, nil
+	case "memory":
+		return &MemoryMailer{}, nil
+	case "", "file":
+		return &FileMailer{Dir: cfg.Dir, From: cfg.From}, nil
+	}
+	return nil, fmt.Errorf("unknown MAILER %q, expected smtp, file or memory", cfg.Mailer)
+}
@@ -0,0 +1,71 @@
+// Package mergepatch applies JSON Merge Patches (RFC 7396), the body of PATCH
+// requests. A patch is a JSON object holding the members to change: a member
+// set to null is removed, an object is merged into the object it replaces and
+// anything else replaces the member outright. Members the patch leaves out are
+// kept as they are.
+package mergepatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// ContentType is the media type of merge patches
+const ContentType = "application/merge-patch+json"
+
+// ErrNotObject is returned for patches that are not JSON objects. The RFC
+// lets any other value replace the whole document, which a resource never
+// wants.
+var ErrNotObject = errors.New("merge patch must be a JSON object")
+
+// Apply patches the JSON object doc and returns the patched document
+func Apply(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := decode(patch)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := changes.(map[string]interface{}); !ok {
+		return nil, ErrNotObject
+	}
+	return json.Marshal(merge(target, changes))
+}
+
+// merge is the MergePatch function of RFC 7396
+func merge(target, patch interface{}) interface{} {
+	changes, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	members, ok := target.(map[string]interface{})
+	if !ok {
+		members = map[string]interface{}{}
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(members, name)
+			continue
+		}
+		members[name] = merge(members[name], value)
+	}
+	return members
+}
+
+// decode reads a JSON value, keeping numbers as they were written
+func decode(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return value, nil
+}
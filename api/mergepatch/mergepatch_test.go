@@ -0,0 +1,100 @@
+package mergepatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// TestApply runs the examples of RFC 7396 Appendix A whose patch is an object
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		patch  string
+		result string
+	}{
+		{"member replaced", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"member added", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"null removes a member", `{"a":"b"}`, `{"a":null}`, `{}`},
+		{"null removes only that member", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"array replaced by a string", `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"string replaced by an array", `{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{"nested objects merge", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{"arrays replace", `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{"null in the document is kept", `{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{"array document replaced by an object", `[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{"nulls are dropped from new objects", `{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameJSON(t, got, []byte(tt.result)) {
+				t.Errorf("got %s, want %s", got, tt.result)
+			}
+		})
+	}
+}
+
+// TestApplyKeepsNumbers checks that numbers too large for a float64 survive
+func TestApplyKeepsNumbers(t *testing.T) {
+	got, err := Apply([]byte(`{"id":12345678901234567890}`), []byte(`{"rooms":3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(got, []byte(`"id":12345678901234567890`)) {
+		t.Errorf("got %s, want the id as written", got)
+	}
+}
+
+// TestApplyNotObject refuses the Appendix A patches that would replace the
+// whole document
+func TestApplyNotObject(t *testing.T) {
+	for _, patch := range []string{`["c","d"]`, `["c"]`, `null`, `"bar"`, `1`} {
+		if _, err := Apply([]byte(`{"a":"foo"}`), []byte(patch)); err != ErrNotObject {
+			t.Errorf("patch %s returned %v, want ErrNotObject", patch, err)
+		}
+	}
+}
+
+func TestApplyTrailingData(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+	}{
+		{"second patch object", `{"a":"b"}`, `{"a":"c"} {"b":"d"}`},
+		{"stray brace", `{"a":"b"}`, `{"a":"c"}}`},
+		{"stray bracket", `{"a":"b"}`, `{"a":"c"}]`},
+		{"trailing text", `{"a":"b"}`, `{"a":"c"} x`},
+		{"in the document", `{"a":"b"} {}`, `{"a":"c"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Apply([]byte(tt.doc), []byte(tt.patch)); err == nil {
+				t.Errorf("got %s, want an error", got)
+			}
+		})
+	}
+
+	if _, err := Apply([]byte(`{"a":"b"}`), []byte("{\"a\":\"c\"}\n")); err != nil {
+		t.Errorf("patch ending in a newline returned %v", err)
+	}
+}
+
+// sameJSON reports whether a and b hold the same JSON value
+func sameJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var x, y interface{}
+	if err := json.Unmarshal(a, &x); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &y); err != nil {
+		t.Fatal(err)
+	}
+	return reflect.DeepEqual(x, y)
+}
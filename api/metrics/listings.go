@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/khelechy/rielzapi/api/repository"
+)
+
+var (
+	housesDesc   = prometheus.NewDesc(namespace+"_houses", "Houses listed, by state.", []string{"state"}, nil)
+	roomsDesc    = prometheus.NewDesc(namespace+"_rooms", "Rooms in listed houses, by state.", []string{"state"}, nil)
+	occupiedDesc = prometheus.NewDesc(namespace+"_rooms_occupied", "Rooms let out to tenants, by state.", []string{"state"}, nil)
+	tenantsDesc  = prometheus.NewDesc(namespace+"_tenants", "Tenants living in listed houses, by state.", []string{"state"}, nil)
+)
+
+// listingCollector reads the listing gauges from the repository on every scrape
+type listingCollector struct {
+	houses repository.HouseRepository
+}
+
+func newListingCollector(houses repository.HouseRepository) *listingCollector {
+	return &listingCollector{houses: houses}
+}
+
+func (c *listingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- housesDesc
+	ch <- roomsDesc
+	ch <- occupiedDesc
+	ch <- tenantsDesc
+}
+
+func (c *listingCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.houses.GetStateStats()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(housesDesc, err)
+		return
+	}
+	for _, state := range *stats {
+		ch <- prometheus.MustNewConstMetric(housesDesc, prometheus.GaugeValue, float64(state.Houses), state.State)
+		ch <- prometheus.MustNewConstMetric(roomsDesc, prometheus.GaugeValue, float64(state.Rooms), state.State)
+		ch <- prometheus.MustNewConstMetric(occupiedDesc, prometheus.GaugeValue, float64(state.OccupiedRooms()), state.State)
+		ch <- prometheus.MustNewConstMetric(tenantsDesc, prometheus.GaugeValue, float64(state.Tenants), state.State)
+	}
+}
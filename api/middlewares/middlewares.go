@@ -2,13 +2,20 @@ package middlewares
 
 import (
     "context"
+    "crypto/rand"
+    "encoding/hex"
     "net/http"
-    "os"
+    "strconv"
     "strings"
+    "time"
 
-    jwt "github.com/dgrijalva/jwt-go"
+    "github.com/gorilla/mux"
+    "go.uber.org/zap"
 
+    "github.com/khelechy/rielzapi/api/apperr"
+    "github.com/khelechy/rielzapi/api/logging"
     "github.com/khelechy/rielzapi/api/responses"
+    "github.com/khelechy/rielzapi/utils"
 )
 
 // SetContentTypeMiddleware sets content-type to json
@@ -19,31 +26,189 @@ func SetContentTypeMiddleware(next http.Handler) http.Handler {
     })
 }
 
-// AuthJwtVerify verify token and add userID to the request context
-func AuthJwtVerify(next http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        var resp = map[string]interface{}{"status": "failed", "message": "Missing authorization token"}
+// LimitBodySize rejects request bodies larger than max bytes, or than the
+// limit set in larger for the route matched, such as an upload. Declared
+// lengths are refused up front; bodies of unknown length fail when read past
+// the limit.
+func LimitBodySize(max int64, larger map[*mux.Route]int64) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            max := max
+            if limit, ok := larger[mux.CurrentRoute(r)]; ok {
+                max = limit
+            }
+            if r.ContentLength > max {
+                responses.ERROR(w, r, apperr.New(apperr.KindTooLarge, apperr.CodeBodyTooLarge, "Request body is too large"))
+                return
+            }
+            r.Body = http.MaxBytesReader(w, r.Body, max)
+            next.ServeHTTP(w, r)
+        })
+    }
+}
 
-        var header = r.Header.Get("Authorization")
-        header = strings.TrimSpace(header)
+// RequestIDHeader carries the ID that ties a request to its log lines
+const RequestIDHeader = "X-Request-ID"
 
-        if header == "" {
-            responses.JSON(w, http.StatusForbidden, resp)
-            return
-        }
+// RequestID keeps the X-Request-ID sent by a proxy or client, or makes one up,
+// echoes it in the response and puts it, along with a logger tagged with it,
+// into the request context
+func RequestID(logger *zap.Logger) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            id := r.Header.Get(RequestIDHeader)
+            if !validRequestID(id) {
+                id = newRequestID()
+            }
+            w.Header().Set(RequestIDHeader, id)
 
-        token, err := jwt.Parse(header, func(token *jwt.Token) (interface{}, error) {
-            return []byte(os.Getenv("SECRET")), nil
+            ctx := logging.WithRequestID(r.Context(), id)
+            ctx = logging.WithLogger(ctx, logger.With(zap.String("request_id", id)))
+            next.ServeHTTP(w, r.WithContext(ctx))
         })
-        if err != nil {
-            resp["status"] = "failed"
-            resp["message"] = "Invalid token, please login"
-            responses.JSON(w, http.StatusForbidden, resp)
-            return
+    }
+}
+
+// validRequestID accepts IDs short enough and plain enough to log safely
+func validRequestID(id string) bool {
+    if id == "" || len(id) > 128 {
+        return false
+    }
+    for _, c := range id {
+        if c < '!' || c > '~' {
+            return false
         }
-        claims, _ := token.Claims.(jwt.MapClaims)
+    }
+    return true
+}
+
+func newRequestID() string {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return strconv.FormatInt(time.Now().UnixNano(), 36)
+    }
+    return hex.EncodeToString(b)
+}
 
-        ctx := context.WithValue(r.Context(), "userID", claims["userID"]) // adding the user ID to the context
-        next.ServeHTTP(w, r.WithContext(ctx))
+// responseRecorder remembers the status and size of a response
+type responseRecorder struct {
+    http.ResponseWriter
+    status int
+    bytes  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+    r.status = status
+    r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+    n, err := r.ResponseWriter.Write(b)
+    r.bytes += n
+    return n, err
+}
+
+// AccessLog logs every request once it has been answered, using the request's
+// logger so the line carries its request ID
+func AccessLog(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+        start := time.Now()
+        next.ServeHTTP(recorder, r)
+
+        logger := logging.FromContext(r.Context())
+        fields := []zap.Field{
+            zap.String("method", r.Method),
+            zap.String("path", r.URL.Path),
+            zap.Int("status", recorder.status),
+            zap.Int("bytes", recorder.bytes),
+            zap.Duration("duration", time.Since(start)),
+            zap.String("remote_addr", r.RemoteAddr),
+            zap.String("user_agent", r.UserAgent()),
+        }
+        if recorder.status >= http.StatusInternalServerError {
+            logger.Error("request", fields...)
+        } else {
+            logger.Info("request", fields...)
+        }
     })
+}
+
+// RevocationCheck reports whether an otherwise valid token has been logged out
+type RevocationCheck func(ctx context.Context, jti string, userID uint, issuedAt int64) (bool, error)
+
+// AuthJwtVerify verify token, reject revoked ones and add userID to the request context
+func AuthJwtVerify(isRevoked RevocationCheck) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            var header = r.Header.Get("Authorization")
+            header = strings.TrimSpace(header)
+
+            if header == "" {
+                responses.ERROR(w, r, apperr.Unauthorized("missing_token", "Missing authorization token"))
+                return
+            }
+
+            claims, err := utils.ParseAuthToken(strings.TrimPrefix(header, "Bearer "))
+            if err != nil {
+                responses.ERROR(w, r, apperr.Unauthorized("invalid_token", "Invalid token, please login"))
+                return
+            }
+
+            revoked, err := isRevoked(r.Context(), claims.ID, claims.UserID, claims.IssuedAt.Unix())
+            if err != nil {
+                responses.ERROR(w, r, err)
+                return
+            }
+            if revoked {
+                responses.ERROR(w, r, apperr.Unauthorized("token_revoked", "Token has been revoked, please login"))
+                return
+            }
+
+            next.ServeHTTP(w, r.WithContext(withClaims(r.Context(), claims)))
+        })
+    }
+}
+
+// AuthJwtOptional adds the user of a valid token to the request context like AuthJwtVerify,
+// but lets requests without a usable token through anonymously
+func AuthJwtOptional(isRevoked RevocationCheck) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            header := strings.TrimSpace(r.Header.Get("Authorization"))
+            if header != "" {
+                if claims, err := utils.ParseAuthToken(strings.TrimPrefix(header, "Bearer ")); err == nil {
+                    if revoked, err := isRevoked(r.Context(), claims.ID, claims.UserID, claims.IssuedAt.Unix()); err == nil && !revoked {
+                        r = r.WithContext(withClaims(r.Context(), claims))
+                    }
+                }
+            }
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+// withClaims adds the user ID, role and token details to the context
+func withClaims(ctx context.Context, claims *utils.AuthClaims) context.Context {
+    ctx = context.WithValue(ctx, "userID", float64(claims.UserID)) // adding the user ID to the context
+    ctx = context.WithValue(ctx, "role", claims.Role)
+    ctx = context.WithValue(ctx, "jti", claims.ID)
+    return context.WithValue(ctx, "tokenExpiresAt", claims.ExpiresAt.Time)
+}
+
+// RequireRole only lets through requests whose token carries one of the given roles.
+// It must run after AuthJwtVerify.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            role, _ := r.Context().Value("role").(string)
+            for _, allowed := range roles {
+                if role == allowed {
+                    next.ServeHTTP(w, r)
+                    return
+                }
+            }
+            responses.ERROR(w, r, apperr.Forbidden("role_not_allowed", "Your role is not allowed to access this resource"))
+        })
+    }
 }
\ No newline at end of file
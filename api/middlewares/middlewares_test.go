@@ -0,0 +1,131 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/khelechy/rielzapi/api/logging"
+	"github.com/khelechy/rielzapi/api/responses"
+)
+
+var generatedRequestID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// TestRequestID keeps a plain ID sent with the request and makes one up for
+// anything else
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		sent string
+		kept bool
+	}{
+		{"sent by a proxy", "req-7f3a9c", true},
+		{"longest kept", strings.Repeat("a", 128), true},
+		{"none sent", "", false},
+		{"too long", strings.Repeat("a", 129), false},
+		{"with spaces", "req 7f3a9c", false},
+		{"with a line break", "req\n7f3a9c", false},
+		{"not ASCII", "réq-7f3a9c", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := RequestID(zap.NewNop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = logging.RequestID(r.Context())
+			}))
+			req := httptest.NewRequest("GET", "/api/houses", nil)
+			if tt.sent != "" {
+				req.Header.Set(RequestIDHeader, tt.sent)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(RequestIDHeader)
+			if seen != id {
+				t.Errorf("handler saw request ID %q, response carries %q", seen, id)
+			}
+			if tt.kept && id != tt.sent {
+				t.Errorf("got request ID %q, want %q kept", id, tt.sent)
+			}
+			if !tt.kept && !generatedRequestID.MatchString(id) {
+				t.Errorf("got request ID %q, want a new one", id)
+			}
+		})
+	}
+}
+
+// TestAccessLog checks that each request is logged once, with its request ID,
+// along with any failure logged while handling it
+func TestAccessLog(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	handler := RequestID(zap.New(core))(AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			responses.ERROR(w, r, errors.New("connection refused"))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":"success"}`))
+	})))
+
+	tests := []struct {
+		path   string
+		status int
+		level  zapcore.Level
+		lines  int // logged for the request, the access log included
+	}{
+		{"/ok", http.StatusCreated, zapcore.InfoLevel, 1},
+		{"/fail", http.StatusInternalServerError, zapcore.ErrorLevel, 2},
+	}
+	for _, tt := range tests {
+		logs.TakeAll()
+		req := httptest.NewRequest("POST", tt.path, nil)
+		req.Header.Set(RequestIDHeader, "req-"+tt.path[1:])
+		req.Header.Set("User-Agent", "rielz-test")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		entries := logs.TakeAll()
+		if len(entries) != tt.lines {
+			t.Fatalf("%s: logged %d lines, want %d", tt.path, len(entries), tt.lines)
+		}
+		for _, entry := range entries {
+			if id := entry.ContextMap()["request_id"]; id != "req-"+tt.path[1:] {
+				t.Errorf("%s: line %q carries request ID %v", tt.path, entry.Message, id)
+			}
+		}
+		access := entries[len(entries)-1]
+		fields := access.ContextMap()
+		if access.Message != "request" || access.Level != tt.level {
+			t.Errorf("%s: access log is %s %q, want %s \"request\"", tt.path, access.Level, access.Message, tt.level)
+		}
+		if fields["method"] != "POST" || fields["path"] != tt.path || fields["status"] != int64(tt.status) || fields["user_agent"] != "rielz-test" {
+			t.Errorf("%s: access log has fields %v", tt.path, fields)
+		}
+		if fields["bytes"] != int64(rec.Body.Len()) {
+			t.Errorf("%s: access log counts %v bytes, %d were sent", tt.path, fields["bytes"], rec.Body.Len())
+		}
+	}
+
+	// failures give the client the request ID to quote, not the cause
+	logs.TakeAll()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/fail", nil))
+	problem := responses.Problem{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.RequestID == "" || problem.RequestID != rec.Header().Get(RequestIDHeader) {
+		t.Errorf("problem carries request ID %q, response %q", problem.RequestID, rec.Header().Get(RequestIDHeader))
+	}
+	if strings.Contains(rec.Body.String(), "connection refused") {
+		t.Errorf("cause of the failure was sent to the client: %s", rec.Body.String())
+	}
+}
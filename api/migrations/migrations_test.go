@@ -0,0 +1,252 @@
+package migrations
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+func TestLoad(t *testing.T) {
+	script := func(sql string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(sql)} }
+	tests := []struct {
+		name  string
+		fsys  fstest.MapFS
+		want  string // versions and names in order
+		fails bool
+	}{
+		{"ordered by version, not by name", fstest.MapFS{
+			"sqlite/0010_tenth.up.sql":    script("CREATE TABLE tenth (id integer)"),
+			"sqlite/0002_second.up.sql":   script("CREATE TABLE second (id integer)"),
+			"sqlite/0002_second.down.sql": script("DROP TABLE second"),
+			"sqlite/1_first.up.sql":       script("CREATE TABLE first (id integer)"),
+		}, "[1_first 2_second 10_tenth]", false},
+		{"other files are ignored", fstest.MapFS{
+			"sqlite/0001_first.up.sql":   script("CREATE TABLE first (id integer)"),
+			"sqlite/README.md":           script("notes"),
+			"sqlite/0002_Second.up.sql":  script("CREATE TABLE second (id integer)"),
+			"sqlite/0003_third.sql":      script("CREATE TABLE third (id integer)"),
+			"sqlite/0004_old/up.sql":     script("CREATE TABLE old (id integer)"),
+			"postgres/0005_other.up.sql": script("CREATE TABLE other (id integer)"),
+		}, "[1_first]", false},
+		{"one version with two names", fstest.MapFS{
+			"sqlite/0001_first.up.sql":   script("CREATE TABLE first (id integer)"),
+			"sqlite/0001_premier.up.sql": script("CREATE TABLE premier (id integer)"),
+		}, "", true},
+		{"up script and down script with different names", fstest.MapFS{
+			"sqlite/0001_first.up.sql":     script("CREATE TABLE first (id integer)"),
+			"sqlite/0001_premier.down.sql": script("DROP TABLE first"),
+		}, "", true},
+		{"down script without an up script", fstest.MapFS{
+			"sqlite/0001_first.down.sql": script("DROP TABLE first"),
+		}, "", true},
+		{"empty up script", fstest.MapFS{
+			"sqlite/0001_first.up.sql": script("  \n"),
+		}, "", true},
+		{"no directory for the dialect", fstest.MapFS{
+			"postgres/0001_first.up.sql": script("CREATE TABLE first (id integer)"),
+		}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := Load(tt.fsys, "sqlite")
+			if (err != nil) != tt.fails {
+				t.Fatalf("got error %v, want failure %v", err, tt.fails)
+			}
+			if tt.fails {
+				return
+			}
+			got := []string{}
+			for _, m := range migrations {
+				got = append(got, fmt.Sprintf("%d_%s", m.Version, m.Name))
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("got %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEmbeddedDialectsMatch keeps every dialect on the same list of migrations,
+// each of which can be rolled back
+func TestEmbeddedDialectsMatch(t *testing.T) {
+	lists := map[string]string{}
+	for _, dialect := range Dialects {
+		migrations, err := Load(files, dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, m := range migrations {
+			names = append(names, fmt.Sprintf("%04d_%s", m.Version, m.Name))
+			if m.Down == "" {
+				t.Errorf("%s migration %04d_%s has no down script", dialect, m.Version, m.Name)
+			}
+		}
+		lists[dialect] = fmt.Sprint(names)
+	}
+	for _, dialect := range Dialects[1:] {
+		if lists[dialect] != lists[Dialects[0]] {
+			t.Errorf("%s has migrations %s, but %s has %s", dialect, lists[dialect], Dialects[0], lists[Dialects[0]])
+		}
+	}
+}
+
+// openSQLite opens an empty in-memory database on a single connection, which
+// every query then sees
+func openSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB().SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestMigratorUpDown applies and rolls back the embedded SQLite migrations.
+// Each step depends on the ones before it.
+func TestMigratorUpDown(t *testing.T) {
+	db := openSQLite(t)
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := len(m.migrations)
+	if total < 2 {
+		t.Fatalf("only %d migrations are embedded", total)
+	}
+
+	if _, err := m.Pending(); err == nil {
+		t.Error("Pending succeeded before schema_migrations was created")
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			t.Errorf("migration %04d is applied on an empty database", status.Version)
+		}
+	}
+	expectPending(t, m, total)
+
+	applied, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != total {
+		t.Errorf("Up applied %d migrations, want %d", len(applied), total)
+	}
+	expectPending(t, m, 0)
+	if !db.HasTable("users") || !db.HasTable("houses") {
+		t.Error("tables are missing after Up")
+	}
+	if again, err := m.Up(); err != nil || len(again) != 0 {
+		t.Errorf("second Up applied %d migrations, %v; want none", len(again), err)
+	}
+
+	rolledBack, err := m.Down(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rolledBack) != 2 || rolledBack[0].Version != m.migrations[total-1].Version || rolledBack[1].Version != m.migrations[total-2].Version {
+		t.Errorf("Down(2) rolled back %v, want the last two, newest first", rolledBack)
+	}
+	expectPending(t, m, 2)
+	statuses, err = m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, status := range statuses {
+		if applied := status.AppliedAt != nil; applied != (i < total-2) {
+			t.Errorf("migration %04d applied is %v after Down(2)", status.Version, applied)
+		}
+	}
+
+	if rolledBack, err = m.Down(total + 5); err != nil || len(rolledBack) != total-2 {
+		t.Fatalf("Down past the first migration rolled back %d, %v; want %d", len(rolledBack), err, total-2)
+	}
+	expectPending(t, m, total)
+	if db.HasTable("users") || db.HasTable("houses") {
+		t.Error("tables are left after every migration was rolled back")
+	}
+
+	if applied, err = m.Up(); err != nil || len(applied) != total {
+		t.Errorf("Up after rolling back applied %d migrations, %v; want %d", len(applied), err, total)
+	}
+}
+
+// TestMigratorFailedUp keeps a migration that fails out of schema_migrations,
+// with the ones before it applied
+func TestMigratorFailedUp(t *testing.T) {
+	m := &Migrator{db: openSQLite(t), migrations: []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE first (id integer)", Down: "DROP TABLE first"},
+		{Version: 2, Name: "broken", Up: "CREATE TABLE first (id integer)", Down: "DROP TABLE first"},
+	}}
+	applied, err := m.Up()
+	if err == nil {
+		t.Fatal("Up succeeded with a broken migration")
+	}
+	if len(applied) != 1 || applied[0].Version != 1 {
+		t.Errorf("Up applied %v before failing, want the first migration", applied)
+	}
+	expectPending(t, m, 1)
+}
+
+func expectPending(t *testing.T, m *Migrator, want int) {
+	t.Helper()
+	pending, err := m.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != want {
+		t.Errorf("%d migrations are pending, want %d", pending, want)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"sqlite/0001_baseline.up.sql", "sqlite/0002_indexes.up.sql", "postgres/0001_baseline.up.sql"} {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte("SELECT 1"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := Create(dir, "Add  parking spaces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{}
+	for _, dialect := range Dialects {
+		for _, direction := range []string{"up", "down"} {
+			want = append(want, filepath.Join(dir, dialect, "0003_add_parking_spaces."+direction+".sql"))
+		}
+	}
+	if fmt.Sprint(paths) != fmt.Sprint(want) {
+		t.Errorf("Create wrote %v, want %v", paths, want)
+	}
+	for _, dialect := range Dialects {
+		migrations, err := Load(os.DirFS(dir), dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last := migrations[len(migrations)-1]; last.Version != 3 || last.Name != "add_parking_spaces" {
+			t.Errorf("last %s migration is %04d_%s, want 0003_add_parking_spaces", dialect, last.Version, last.Name)
+		}
+	}
+
+	if _, err := Create(dir, "drop users; --"); err == nil {
+		t.Error("a name with punctuation was accepted")
+	}
+}
@@ -0,0 +1,121 @@
+-- Baseline: the schema AutoMigrate created from the models before migrations were
+-- versioned. Every statement is guarded so databases created by AutoMigrate adopt
+-- the baseline without changes.
+
+CREATE TABLE IF NOT EXISTS users (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    email varchar(100),
+    first_name varchar(100) NOT NULL,
+    last_name varchar(100) NOT NULL,
+    password varchar(100) NOT NULL,
+    phone_number varchar(100) NOT NULL,
+    role varchar(20) NOT NULL DEFAULT 'landlord',
+    suspended boolean NOT NULL DEFAULT false,
+    tokens_valid_after timestamp with time zone,
+    email_verified_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_users_email ON users (email);
+
+CREATE TABLE IF NOT EXISTS houses (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    house_type varchar(100) NOT NULL,
+    state text NOT NULL,
+    description text NOT NULL,
+    location varchar(100) NOT NULL,
+    rooms integer NOT NULL,
+    available_rooms integer NOT NULL,
+    bath_rooms integer NOT NULL,
+    price integer NOT NULL,
+    long_lat text NOT NULL,
+    latitude numeric NOT NULL DEFAULT 0,
+    longitude numeric NOT NULL DEFAULT 0,
+    user_id integer NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_houses_deleted_at ON houses (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_houses_coordinates ON houses (latitude, longitude);
+
+CREATE TABLE IF NOT EXISTS tenants (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    email text NOT NULL,
+    first_name varchar(100) NOT NULL,
+    last_name varchar(100) NOT NULL,
+    phone_number varchar(100) NOT NULL,
+    house_id integer NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_tenants_deleted_at ON tenants (deleted_at);
+
+CREATE TABLE IF NOT EXISTS leases (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    tenant_id integer NOT NULL,
+    house_id integer NOT NULL,
+    start_date timestamp with time zone NOT NULL,
+    end_date timestamp with time zone,
+    rent_amount integer NOT NULL,
+    status varchar(20) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_leases_deleted_at ON leases (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_leases_tenant_id ON leases (tenant_id);
+CREATE INDEX IF NOT EXISTS idx_leases_house_id ON leases (house_id);
+CREATE INDEX IF NOT EXISTS idx_leases_status ON leases (status);
+
+CREATE TABLE IF NOT EXISTS agent_links (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    agent_id integer NOT NULL,
+    landlord_id integer NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_agent_links_deleted_at ON agent_links (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_agent_links_pair ON agent_links (agent_id, landlord_id);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    user_id integer NOT NULL,
+    token_hash varchar(64) NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    revoked_at timestamp with time zone,
+    rotated boolean NOT NULL DEFAULT false
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_deleted_at ON refresh_tokens (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    id serial PRIMARY KEY,
+    jti varchar(64) NOT NULL,
+    expires_at timestamp with time zone NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_revoked_tokens_jti ON revoked_tokens (jti);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    user_id integer NOT NULL,
+    purpose varchar(30) NOT NULL,
+    token_hash varchar(64) NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS idx_user_tokens_deleted_at ON user_tokens (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON user_tokens (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_user_tokens_token_hash ON user_tokens (token_hash);
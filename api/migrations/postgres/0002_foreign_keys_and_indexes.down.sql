@@ -0,0 +1,14 @@
+DROP INDEX IF EXISTS idx_agent_links_landlord_id;
+DROP INDEX IF EXISTS idx_tenants_email;
+DROP INDEX IF EXISTS idx_tenants_house_id;
+DROP INDEX IF EXISTS idx_houses_state;
+DROP INDEX IF EXISTS idx_houses_user_id;
+
+ALTER TABLE user_tokens DROP CONSTRAINT IF EXISTS fk_user_tokens_user_id;
+ALTER TABLE refresh_tokens DROP CONSTRAINT IF EXISTS fk_refresh_tokens_user_id;
+ALTER TABLE agent_links DROP CONSTRAINT IF EXISTS fk_agent_links_landlord_id;
+ALTER TABLE agent_links DROP CONSTRAINT IF EXISTS fk_agent_links_agent_id;
+ALTER TABLE leases DROP CONSTRAINT IF EXISTS fk_leases_house_id;
+ALTER TABLE leases DROP CONSTRAINT IF EXISTS fk_leases_tenant_id;
+ALTER TABLE tenants DROP CONSTRAINT IF EXISTS fk_tenants_house_id;
+ALTER TABLE houses DROP CONSTRAINT IF EXISTS fk_houses_user_id;
@@ -0,0 +1,18 @@
+-- Foreign keys AutoMigrate never created. They are added NOT VALID so that rows
+-- written before them are left alone while every new row is checked; run
+-- ALTER TABLE ... VALIDATE CONSTRAINT once old orphans have been cleaned up.
+ALTER TABLE houses ADD CONSTRAINT fk_houses_user_id FOREIGN KEY (user_id) REFERENCES users (id) NOT VALID;
+ALTER TABLE tenants ADD CONSTRAINT fk_tenants_house_id FOREIGN KEY (house_id) REFERENCES houses (id) NOT VALID;
+ALTER TABLE leases ADD CONSTRAINT fk_leases_tenant_id FOREIGN KEY (tenant_id) REFERENCES tenants (id) NOT VALID;
+ALTER TABLE leases ADD CONSTRAINT fk_leases_house_id FOREIGN KEY (house_id) REFERENCES houses (id) NOT VALID;
+ALTER TABLE agent_links ADD CONSTRAINT fk_agent_links_agent_id FOREIGN KEY (agent_id) REFERENCES users (id) NOT VALID;
+ALTER TABLE agent_links ADD CONSTRAINT fk_agent_links_landlord_id FOREIGN KEY (landlord_id) REFERENCES users (id) NOT VALID;
+ALTER TABLE refresh_tokens ADD CONSTRAINT fk_refresh_tokens_user_id FOREIGN KEY (user_id) REFERENCES users (id) NOT VALID;
+ALTER TABLE user_tokens ADD CONSTRAINT fk_user_tokens_user_id FOREIGN KEY (user_id) REFERENCES users (id) NOT VALID;
+
+-- Lookups by landlord, state, house and tenant email scanned whole tables
+CREATE INDEX IF NOT EXISTS idx_houses_user_id ON houses (user_id);
+CREATE INDEX IF NOT EXISTS idx_houses_state ON houses (state);
+CREATE INDEX IF NOT EXISTS idx_tenants_house_id ON tenants (house_id);
+CREATE INDEX IF NOT EXISTS idx_tenants_email ON tenants (email);
+CREATE INDEX IF NOT EXISTS idx_agent_links_landlord_id ON agent_links (landlord_id);
@@ -0,0 +1,35 @@
+-- A catalog of amenities, such as parking or running water, that houses are
+-- listed with and renters filter by. It starts with the rows of
+-- models.StarterAmenities; admins manage it from then on.
+CREATE TABLE IF NOT EXISTS amenities (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    slug varchar(50) NOT NULL,
+    name varchar(100) NOT NULL,
+    category varchar(50) NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_amenities_deleted_at ON amenities (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_amenities_slug ON amenities (slug);
+
+CREATE TABLE IF NOT EXISTS house_amenities (
+    house_id integer NOT NULL REFERENCES houses (id),
+    amenity_id integer NOT NULL REFERENCES amenities (id),
+    PRIMARY KEY (house_id, amenity_id)
+);
+CREATE INDEX IF NOT EXISTS idx_house_amenities_amenity_id ON house_amenities (amenity_id);
+
+INSERT INTO amenities (created_at, updated_at, slug, name, category) VALUES
+    (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'parking', 'Parking', 'facilities'),
+    (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'furnished', 'Furnished', 'comfort'),
+    (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'water', 'Running water', 'utilities'),
+    (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'electricity', 'Steady electricity', 'utilities'),
+    (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'generator', 'Backup generator', 'utilities'),
+    (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'internet', 'Internet', 'utilities'),
+    (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'air_conditioning', 'Air conditioning', 'comfort'),
+    (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'security', 'Security', 'safety'),
+    (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'gated', 'Gated estate', 'safety'),
+    (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'pets_allowed', 'Pets allowed', 'rules'),
+    (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'swimming_pool', 'Swimming pool', 'facilities'),
+    (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'gym', 'Gym', 'facilities');
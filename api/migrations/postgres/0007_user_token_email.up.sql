@@ -0,0 +1,5 @@
+-- Tokens remember the address they were mailed to, so a verification link sent
+-- before an email change cannot verify the new address. Outstanding links were
+-- sent without it and are expired; users can ask for a new one.
+ALTER TABLE user_tokens ADD COLUMN IF NOT EXISTS email varchar(100) NOT NULL DEFAULT '';
+UPDATE user_tokens SET used_at = now() WHERE purpose = 'email_verification' AND used_at IS NULL;
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS user_tokens;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS agent_links;
+DROP TABLE IF EXISTS leases;
+DROP TABLE IF EXISTS tenants;
+DROP TABLE IF EXISTS houses;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_agent_links_landlord_id;
+DROP INDEX IF EXISTS idx_tenants_email;
+DROP INDEX IF EXISTS idx_tenants_house_id;
+DROP INDEX IF EXISTS idx_houses_state;
+DROP INDEX IF EXISTS idx_houses_user_id;
@@ -0,0 +1,7 @@
+-- SQLite only accepts foreign keys declared along with a table, so this version
+-- adds the indexes and leaves the constraints to Postgres.
+CREATE INDEX IF NOT EXISTS idx_houses_user_id ON houses (user_id);
+CREATE INDEX IF NOT EXISTS idx_houses_state ON houses (state);
+CREATE INDEX IF NOT EXISTS idx_tenants_house_id ON tenants (house_id);
+CREATE INDEX IF NOT EXISTS idx_tenants_email ON tenants (email);
+CREATE INDEX IF NOT EXISTS idx_agent_links_landlord_id ON agent_links (landlord_id);
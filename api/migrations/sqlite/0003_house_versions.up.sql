@@ -0,0 +1,3 @@
+-- Houses count their changes so clients can send If-Match with the ETag they
+-- read and never overwrite an edit they have not seen.
+ALTER TABLE houses ADD COLUMN version integer NOT NULL DEFAULT 1;
@@ -0,0 +1,21 @@
+-- Photos, floor plans and documents uploaded for houses. The files live in the
+-- blob store; rows keep their keys, order and which photo is the cover.
+CREATE TABLE IF NOT EXISTS house_media (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    house_id integer NOT NULL REFERENCES houses (id),
+    kind varchar(20) NOT NULL,
+    file_name varchar(255) NOT NULL,
+    content_type varchar(100) NOT NULL,
+    size bigint NOT NULL,
+    width integer NOT NULL DEFAULT 0,
+    height integer NOT NULL DEFAULT 0,
+    key varchar(255) NOT NULL,
+    thumbnail_key varchar(255) NOT NULL,
+    position integer NOT NULL DEFAULT 0,
+    cover bool NOT NULL DEFAULT false
+);
+CREATE INDEX IF NOT EXISTS idx_house_media_deleted_at ON house_media (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_house_media_house_id ON house_media (house_id);
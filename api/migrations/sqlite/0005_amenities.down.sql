@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS house_amenities;
+DROP TABLE IF EXISTS amenities;
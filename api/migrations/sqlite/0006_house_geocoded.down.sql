@@ -0,0 +1,31 @@
+-- The SQLite this is built with cannot drop a column, so the table is rebuilt
+-- without it.
+CREATE TABLE houses_without_geocoded (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    house_type varchar(100) NOT NULL,
+    state varchar(255) NOT NULL,
+    description varchar(255) NOT NULL,
+    location varchar(100) NOT NULL,
+    rooms integer NOT NULL,
+    available_rooms integer NOT NULL,
+    bath_rooms integer NOT NULL,
+    price integer NOT NULL,
+    long_lat varchar(255) NOT NULL,
+    latitude real NOT NULL DEFAULT 0,
+    longitude real NOT NULL DEFAULT 0,
+    user_id integer NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+INSERT INTO houses_without_geocoded
+    SELECT id, created_at, updated_at, deleted_at, house_type, state, description, location,
+        rooms, available_rooms, bath_rooms, price, long_lat, latitude, longitude, user_id, version
+    FROM houses;
+DROP TABLE houses;
+ALTER TABLE houses_without_geocoded RENAME TO houses;
+CREATE INDEX IF NOT EXISTS idx_houses_deleted_at ON houses (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_houses_coordinates ON houses (latitude, longitude);
+CREATE INDEX IF NOT EXISTS idx_houses_user_id ON houses (user_id);
+CREATE INDEX IF NOT EXISTS idx_houses_state ON houses (state);
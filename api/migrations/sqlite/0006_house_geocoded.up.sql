@@ -0,0 +1,4 @@
+-- Houses whose LongLat could be parsed are flagged, so legacy rows left at 0,0
+-- do not turn up in searches near that point.
+ALTER TABLE houses ADD COLUMN geocoded bool NOT NULL DEFAULT false;
+UPDATE houses SET geocoded = true WHERE latitude <> 0 OR longitude <> 0;
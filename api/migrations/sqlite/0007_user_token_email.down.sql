@@ -0,0 +1,21 @@
+-- The SQLite this is built with cannot drop a column, so the table is rebuilt
+-- without it.
+CREATE TABLE user_tokens_without_email (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    user_id integer NOT NULL,
+    purpose varchar(30) NOT NULL,
+    token_hash varchar(64) NOT NULL,
+    expires_at datetime NOT NULL,
+    used_at datetime
+);
+INSERT INTO user_tokens_without_email
+    SELECT id, created_at, updated_at, deleted_at, user_id, purpose, token_hash, expires_at, used_at
+    FROM user_tokens;
+DROP TABLE user_tokens;
+ALTER TABLE user_tokens_without_email RENAME TO user_tokens;
+CREATE INDEX IF NOT EXISTS idx_user_tokens_deleted_at ON user_tokens (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON user_tokens (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_user_tokens_token_hash ON user_tokens (token_hash);
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// AgentLink lets an agent manage the houses of a landlord on their behalf
+type AgentLink struct {
+	gorm.Model
+	AgentID    uint `gorm:"not null;unique_index:idx_agent_links_pair" json:"agent_id"`
+	LandlordID uint `gorm:"not null;unique_index:idx_agent_links_pair" json:"landlord_id"`
+}
+
+// LinkAgent links an agent to a landlord, doing nothing if they are already linked
+func LinkAgent(agentID, landlordID uint, db *gorm.DB) (*AgentLink, error) {
+	link := &AgentLink{}
+	err := db.Where(AgentLink{AgentID: agentID, LandlordID: landlordID}).FirstOrCreate(link).Error
+	if err != nil {
+		return &AgentLink{}, err
+	}
+	return link, nil
+}
+
+// UnlinkAgent removes the link between an agent and a landlord
+func UnlinkAgent(agentID, landlordID uint, db *gorm.DB) error {
+	return db.Unscoped().Where("agent_id = ? AND landlord_id = ?", agentID, landlordID).
+		Delete(&AgentLink{}).Error
+}
+
+// GetAgentsByLandlord returns the agents linked to a landlord
+func GetAgentsByLandlord(landlordID uint, db *gorm.DB) (*[]User, error) {
+	users := []User{}
+	if err := db.Table("users").
+		Where("id IN (?)", db.Model(&AgentLink{}).Select("agent_id").Where("landlord_id = ?", landlordID).QueryExpr()).
+		Find(&users).Error; err != nil {
+		return &[]User{}, err
+	}
+	return &users, nil
+}
+
+// GetLinkedLandlordIDs returns the landlords an agent works for
+func GetLinkedLandlordIDs(agentID uint, db *gorm.DB) ([]uint, error) {
+	ids := []uint{}
+	if err := db.Model(&AgentLink{}).Where("agent_id = ?", agentID).Pluck("landlord_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
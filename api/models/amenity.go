@@ -0,0 +1,242 @@
+package models
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/khelechy/rielzapi/api/apperr"
+	"github.com/khelechy/rielzapi/api/validation"
+)
+
+// amenitySlug is the form of the slugs naming amenities, such as pets_allowed
+var amenitySlug = regexp.MustCompile(`^[a-z0-9]+(_[a-z0-9]+)*$`)
+
+func init() {
+	validation.RegisterRule("slug", "must be lowercase letters and digits joined by underscores, such as pets_allowed", amenitySlug.MatchString)
+}
+
+// ErrAmenityExists is returned when adding an amenity under a slug already in the catalog
+var ErrAmenityExists = apperr.Conflict("amenity_exists", "An amenity with this slug already exists")
+
+// Amenity is an entry of the catalog of features houses are listed with, such
+// as parking or a water supply. Houses and filters name amenities by slug.
+type Amenity struct {
+	gorm.Model
+	Slug     string `gorm:"size:50;not null;unique_index" json:"slug"     validate:"required,max=50,slug"`
+	Name     string `gorm:"size:100;not null"             json:"name"     validate:"required,max=100"`
+	Category string `gorm:"size:50;not null;default:''"   json:"category" validate:"max=50"`
+}
+
+// StarterAmenities are the catalog a new database starts with; migration
+// 0005_amenities inserts the same rows
+var StarterAmenities = []Amenity{
+	{Slug: "parking", Name: "Parking", Category: "facilities"},
+	{Slug: "furnished", Name: "Furnished", Category: "comfort"},
+	{Slug: "water", Name: "Running water", Category: "utilities"},
+	{Slug: "electricity", Name: "Steady electricity", Category: "utilities"},
+	{Slug: "generator", Name: "Backup generator", Category: "utilities"},
+	{Slug: "internet", Name: "Internet", Category: "utilities"},
+	{Slug: "air_conditioning", Name: "Air conditioning", Category: "comfort"},
+	{Slug: "security", Name: "Security", Category: "safety"},
+	{Slug: "gated", Name: "Gated estate", Category: "safety"},
+	{Slug: "pets_allowed", Name: "Pets allowed", Category: "rules"},
+	{Slug: "swimming_pool", Name: "Swimming pool", Category: "facilities"},
+	{Slug: "gym", Name: "Gym", Category: "facilities"},
+}
+
+// UnmarshalJSON also reads an amenity from its slug alone, which is how
+// clients name the amenities of the houses they list
+func (a *Amenity) UnmarshalJSON(data []byte) error {
+	var slug string
+	if err := json.Unmarshal(data, &slug); err == nil {
+		*a = Amenity{Slug: slug}
+		return nil
+	}
+	type amenity Amenity // drops this method so unmarshalling does not recurse
+	return json.Unmarshal(data, (*amenity)(a))
+}
+
+// Prepare tidies the amenity for saving
+func (a *Amenity) Prepare() {
+	a.Slug = strings.ToLower(strings.TrimSpace(a.Slug))
+	a.Name = strings.TrimSpace(a.Name)
+	a.Category = strings.ToLower(strings.TrimSpace(a.Category))
+}
+
+// Validate checks the amenity against the rules in its validate tags
+func (a *Amenity) Validate() error {
+	return validation.Struct(a)
+}
+
+// AmenitySlugs tidies the slugs of amenities named by a client, dropping blanks and repeats
+func AmenitySlugs(amenities []Amenity) []string {
+	slugs := []string{}
+	seen := map[string]bool{}
+	for _, amenity := range amenities {
+		slug := strings.ToLower(strings.TrimSpace(amenity.Slug))
+		if slug != "" && !seen[slug] {
+			seen[slug] = true
+			slugs = append(slugs, slug)
+		}
+	}
+	return slugs
+}
+
+// UnknownAmenity is the error for a house naming an amenity missing from the catalog
+func UnknownAmenity(slug string) error {
+	return apperr.InvalidField("amenities", "Unknown amenity "+slug+", see GET /api/amenities for the catalog")
+}
+
+// byName orders amenities the way they are listed. Queries for houses pass it
+// when preloading their amenities.
+func byName(db *gorm.DB) *gorm.DB {
+	return db.Order("amenities.name asc")
+}
+
+// houseDetails loads the media and amenities of the houses found
+func houseDetails(db *gorm.DB) *gorm.DB {
+	return db.Preload("Media", inPosition).Preload("Amenities", byName)
+}
+
+// findAmenities looks up the amenities a client named by slug, failing with a
+// validation error on the first slug missing from the catalog
+func findAmenities(named []Amenity, db *gorm.DB) ([]Amenity, error) {
+	slugs := AmenitySlugs(named)
+	amenities := []Amenity{}
+	if len(slugs) == 0 {
+		return amenities, nil
+	}
+	if err := byName(db).Where("slug IN (?)", slugs).Find(&amenities).Error; err != nil {
+		return nil, err
+	}
+	found := map[string]bool{}
+	for _, amenity := range amenities {
+		found[amenity.Slug] = true
+	}
+	for _, slug := range slugs {
+		if !found[slug] {
+			return nil, UnknownAmenity(slug)
+		}
+	}
+	return amenities, nil
+}
+
+// setHouseAmenities links a house to exactly the amenities given
+func setHouseAmenities(houseID uint, amenities []Amenity, db *gorm.DB) error {
+	if err := db.Exec("DELETE FROM house_amenities WHERE house_id = ?", houseID).Error; err != nil {
+		return err
+	}
+	for _, amenity := range amenities {
+		if err := db.Exec("INSERT INTO house_amenities (house_id, amenity_id) VALUES (?, ?)", houseID, amenity.ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// housesWithAmenities selects the ids of the houses that have every amenity named by slug
+func housesWithAmenities(slugs []string, db *gorm.DB) interface{} {
+	return db.Table("house_amenities").Select("house_amenities.house_id").
+		Joins("JOIN amenities ON amenities.id = house_amenities.amenity_id").
+		Where("amenities.slug IN (?)", slugs).
+		Group("house_amenities.house_id").
+		Having("COUNT(DISTINCT amenities.id) = ?", len(slugs)).QueryExpr()
+}
+
+// touchHousesWith moves on the version of the houses listed with an amenity,
+// as the amenity clients read with them changed
+func touchHousesWith(amenityID uint, db *gorm.DB) error {
+	return db.Model(&House{}).
+		Where("id IN (?)", db.Table("house_amenities").Select("house_id").Where("amenity_id = ?", amenityID).QueryExpr()).
+		UpdateColumn("version", gorm.Expr("version + 1")).Error
+}
+
+// GetAmenities returns the catalog by category, then name
+func GetAmenities(db *gorm.DB) (*[]Amenity, error) {
+	amenities := []Amenity{}
+	if err := db.Order("category asc").Order("name asc").Find(&amenities).Error; err != nil {
+		return &[]Amenity{}, err
+	}
+	return &amenities, nil
+}
+
+// GetAmenityById returns an amenity of the catalog
+func GetAmenityById(id int, db *gorm.DB) (*Amenity, error) {
+	amenity := &Amenity{}
+	if err := db.Where("id = ?", id).First(amenity).Error; err != nil {
+		return nil, err
+	}
+	return amenity, nil
+}
+
+// slugTaken reports whether an amenity other than the one with id has the slug
+func slugTaken(slug string, id uint, db *gorm.DB) (bool, error) {
+	count := 0
+	err := db.Model(&Amenity{}).Where("slug = ? AND id <> ?", slug, id).Count(&count).Error
+	return count > 0, err
+}
+
+// SaveAmenity adds an amenity to the catalog
+func (a *Amenity) SaveAmenity(db *gorm.DB) (*Amenity, error) {
+	taken, err := slugTaken(a.Slug, 0, db)
+	if err != nil {
+		return &Amenity{}, err
+	}
+	if taken {
+		return &Amenity{}, ErrAmenityExists
+	}
+	if err := db.Create(a).Error; err != nil {
+		return &Amenity{}, err
+	}
+	return a, nil
+}
+
+// UpdateAmenity renames or recategorises an amenity. Houses keep it under its new slug.
+func (a *Amenity) UpdateAmenity(id int, db *gorm.DB) (*Amenity, error) {
+	taken, err := slugTaken(a.Slug, uint(id), db)
+	if err != nil {
+		return &Amenity{}, err
+	}
+	if taken {
+		return &Amenity{}, ErrAmenityExists
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Amenity{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"slug":       a.Slug,
+			"name":       a.Name,
+			"category":   a.Category,
+			"updated_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		return touchHousesWith(uint(id), tx)
+	})
+	if err != nil {
+		return &Amenity{}, err
+	}
+	return GetAmenityById(id, db)
+}
+
+// DeleteAmenity removes an amenity from the catalog and from every house listed with it
+func DeleteAmenity(id int, db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := touchHousesWith(uint(id), tx); err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM house_amenities WHERE amenity_id = ?", id).Error; err != nil {
+			return err
+		}
+		// removed for good, so that the slug can be used again
+		return tx.Unscoped().Where("id = ?", id).Delete(&Amenity{}).Error
+	})
+}
+
+// SortAmenities orders amenities the way they are listed on houses
+func SortAmenities(amenities []Amenity) {
+	sort.SliceStable(amenities, func(i, j int) bool { return amenities[i].Name < amenities[j].Name })
+}
@@ -24,6 +24,149 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/.well-known/jwks.json": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Public keys that verify access tokens, as a JSON Web Key Set",
+                "responses": {}
+            }
+        },
+        "/api/admin/amenities": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Add an amenity to the catalog",
+                "responses": {}
+            }
+        },
+        "/api/admin/amenities/{id}": {
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Change the slug, name or category of an amenity; houses listed with it keep it",
+                "responses": {}
+            },
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Remove an amenity from the catalog and from every house listed with it",
+                "responses": {}
+            }
+        },
+        "/api/admin/users/{id}/reinstate": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Lift the suspension of a user",
+                "responses": {}
+            }
+        },
+        "/api/admin/users/{id}/role": {
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Change the role of a user, ending their sessions so no token carries the old role",
+                "responses": {}
+            }
+        },
+        "/api/admin/users/{id}/suspend": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Suspend a user so they can no longer log in",
+                "responses": {}
+            }
+        },
+        "/api/agents": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List the agents linked to a landlord",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Landlord to list agents for (admins only)",
+                        "name": "landlord_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Let an agent manage the landlord's houses",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Landlord to link the agent to (admins only)",
+                        "name": "landlord_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/agents/{id}": {
+            "delete": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Stop an agent managing the landlord's houses",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Landlord to unlink the agent from (admins only)",
+                        "name": "landlord_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/amenities": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List the amenities houses can be listed and filtered with, by category then name",
+                "responses": {}
+            }
+        },
         "/api/houses": {
             "get": {
                 "consumes": [
@@ -33,6 +176,80 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "summary": "Get All Houses",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 20, max 100)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor returned as next_cursor by the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Sort by price, created_at or available_rooms",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "asc or desc",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by house type",
+                        "name": "house_type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by state",
+                        "name": "state",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Minimum price",
+                        "name": "min_price",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum price",
+                        "name": "max_price",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Minimum number of rooms",
+                        "name": "min_rooms",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Minimum number of bathrooms",
+                        "name": "min_bathrooms",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only houses with available rooms",
+                        "name": "available",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated slugs of amenities houses must all have, such as parking,water",
+                        "name": "amenities",
+                        "in": "query"
+                    }
+                ],
                 "responses": {}
             },
             "post": {
@@ -42,7 +259,7 @@ const docTemplate = `{
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Create House for landlord",
+                "summary": "Create House for landlord. available_rooms starts at rooms and then follows the leases.",
                 "responses": {}
             }
         },
@@ -55,6 +272,14 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "summary": "Get All Houses By Id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ETag of the copy the client holds, answered with 304 while it is current",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
                 "responses": {}
             },
             "put": {
@@ -64,7 +289,15 @@ const docTemplate = `{
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Update Houses By Landlord",
+                "summary": "Replace a house listing. Every field is required, as on create, except available_rooms, which follows the leases and cannot be set.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ETag the edit is based on, answered with 412 once the house has changed",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
                 "responses": {}
             },
             "delete": {
@@ -75,6 +308,32 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "summary": "Delete house By Landlord",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ETag the edit is based on, answered with 412 once the house has changed",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {}
+            },
+            "patch": {
+                "consumes": [
+                    "application/merge-patch+json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Change some fields of a house listing with a JSON Merge Patch, where null clears a field. available_rooms follows the leases and cannot be set.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ETag the edit is based on, answered with 412 once the house has changed",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
                 "responses": {}
             }
         },
@@ -102,6 +361,46 @@ const docTemplate = `{
                 "responses": {}
             }
         },
+        "/api/houses/nearby": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get Houses near a point, closest first",
+                "parameters": [
+                    {
+                        "type": "number",
+                        "description": "Latitude of the point",
+                        "name": "lat",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Longitude of the point",
+                        "name": "lng",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Search radius in kilometres (default 10, max 100)",
+                        "name": "radius_km",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of houses (default 20, max 100)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
         "/api/houses/state": {
             "get": {
                 "consumes": [
@@ -126,6 +425,189 @@ const docTemplate = `{
                 "responses": {}
             }
         },
+        "/api/houses/{id}/media": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List the photos, floor plans and documents of a house in the order they are shown",
+                "responses": {}
+            },
+            "post": {
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Upload a photo, floor plan or document of a house as multipart/form-data. Photos are JPEG, PNG or WebP images, documents PDFs and floor plans either; images get a thumbnail and the first photo becomes the cover.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "photo, floor_plan or document",
+                        "name": "kind",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "file",
+                        "description": "The file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag the upload is based on, answered with 412 once the house has changed",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/houses/{id}/media/order": {
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Set the order media of a house are shown in, listing the ID of each exactly once",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ETag the change is based on, answered with 412 once the house has changed",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/houses/{id}/media/{mediaId}": {
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete a photo, floor plan or document of a house along with its files",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ETag the change is based on, answered with 412 once the house has changed",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/houses/{id}/media/{mediaId}/cover": {
+            "put": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Make a photo the cover of its house",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ETag the change is based on, answered with 412 once the house has changed",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/houses/{id}/tenants": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List tenants of a house with their leases",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "current, past or empty for all",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/leases/{id}/end": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "End or terminate a lease, freeing its room",
+                "responses": {}
+            }
+        },
+        "/api/me/leases": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List the leases of the logged in tenant, found by their email once they have verified it",
+                "responses": {}
+            }
+        },
+        "/api/tenants/{id}": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get Tenant By Id",
+                "responses": {}
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Update Tenant details",
+                "responses": {}
+            },
+            "delete": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Remove Tenant, terminating the active lease",
+                "responses": {}
+            }
+        },
+        "/api/tenants/{id}/transfer": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Move Tenant to another house",
+                "responses": {}
+            }
+        },
         "/api/users": {
             "post": {
                 "consumes": [
@@ -156,7 +638,50 @@ const docTemplate = `{
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Update landlords",
+                "summary": "Replace the profile of a user. Every field is required, as on signup.",
+                "responses": {}
+            },
+            "patch": {
+                "consumes": [
+                    "application/merge-patch+json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Change some fields of the profile of a user with a JSON Merge Patch",
+                "responses": {}
+            }
+        },
+        "/email/verify": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Confirm an email with a mailed verification token",
+                "responses": {}
+            }
+        },
+        "/email/verify/resend": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Email a new verification link to the logged in user",
+                "responses": {}
+            }
+        },
+        "/healthz": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Liveness probe, succeeds while the process can serve requests",
                 "responses": {}
             }
         },
@@ -172,6 +697,63 @@ const docTemplate = `{
                 "responses": {}
             }
         },
+        "/logout": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Revoke the current access token and, when given, its refresh token",
+                "responses": {}
+            }
+        },
+        "/logout/all": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Revoke every access and refresh token of the logged in user",
+                "responses": {}
+            }
+        },
+        "/password/forgot": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Email a password reset link",
+                "responses": {}
+            }
+        },
+        "/password/reset": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Set a new password with a mailed reset token, logging out every session",
+                "responses": {}
+            }
+        },
+        "/readyz": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Readiness probe, succeeds when the database answers and its schema is current",
+                "responses": {}
+            }
+        },
         "/signup": {
             "post": {
                 "consumes": [
@@ -183,6 +765,27 @@ const docTemplate = `{
                 "summary": "Registration for landlords",
                 "responses": {}
             }
+        },
+        "/token/refresh": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Exchange a refresh token for a new access and refresh token",
+                "responses": {}
+            }
+        },
+        "/version": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Version, commit, build time and Go version of the running binary",
+                "responses": {}
+            }
         }
     }
 }`
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/khelechy/rielzapi/api/validation"
+)
+
+const earthRadiusKm = 6371.0
+
+func init() {
+	validation.RegisterRule("longlat", "must be a longitude,latitude pair on the globe, such as 3.3792,6.5244", func(value string) bool {
+		_, _, err := ParseLongLat(value)
+		return err == nil
+	})
+}
+
+// NearbyHouse is a house along with its distance from the point that was searched
+type NearbyHouse struct {
+	House
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// ParseLongLat reads a "longitude,latitude" pair and checks both are in range
+func ParseLongLat(value string) (float64, float64, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("LongLat must be in the form longitude,latitude")
+	}
+	lng, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return 0, 0, errors.New("Longitude of house is invalid")
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return 0, 0, errors.New("Latitude of house is invalid")
+	}
+	if err := ValidateCoordinates(lat, lng); err != nil {
+		return 0, 0, err
+	}
+	return lng, lat, nil
+}
+
+// ValidateCoordinates checks that a latitude and longitude lie on the globe
+func ValidateCoordinates(lat, lng float64) error {
+	if lat < -90 || lat > 90 {
+		return errors.New("Latitude must be between -90 and 90")
+	}
+	if lng < -180 || lng > 180 {
+		return errors.New("Longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// DistanceKm returns the great-circle distance between two points using the haversine formula
+func DistanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	dLat := toRadians(lat2 - lat1)
+	dLng := toRadians(lng2 - lng1)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// GetHousesNearby returns up to limit houses within radiusKm of the point, closest first.
+// A bounding box narrows the rows in SQL before exact distances are computed.
+func GetHousesNearby(lat, lng, radiusKm float64, limit int, db *gorm.DB) (*[]NearbyHouse, error) {
+	latDelta := radiusKm / earthRadiusKm * 180 / math.Pi
+	query := db.Model(&House{}).
+		Where("geocoded = ?", true).
+		Where("latitude BETWEEN ? AND ?", lat-latDelta, lat+latDelta)
+
+	// near the poles or across the antimeridian the longitude box is not worth the trouble
+	if cos := math.Cos(toRadians(lat)); cos > 0.01 {
+		lngDelta := latDelta / cos
+		if lng-lngDelta >= -180 && lng+lngDelta <= 180 {
+			query = query.Where("longitude BETWEEN ? AND ?", lng-lngDelta, lng+lngDelta)
+		}
+	}
+
+	houses := []House{}
+	if err := houseDetails(query).Find(&houses).Error; err != nil {
+		return &[]NearbyHouse{}, err
+	}
+
+	nearby := []NearbyHouse{}
+	for _, house := range houses {
+		distance := DistanceKm(lat, lng, house.Latitude, house.Longitude)
+		if distance <= radiusKm {
+			nearby = append(nearby, NearbyHouse{House: house, DistanceKm: math.Round(distance*1000) / 1000})
+		}
+	}
+	sort.SliceStable(nearby, func(i, j int) bool {
+		return nearby[i].DistanceKm < nearby[j].DistanceKm
+	})
+	if limit > 0 && len(nearby) > limit {
+		nearby = nearby[:limit]
+	}
+	return &nearby, nil
+}
+
+// BackfillCoordinates fills latitude and longitude for houses saved before they
+// were parsed from LongLat. Houses whose LongLat cannot be parsed stay
+// ungeocoded and out of nearby searches.
+func BackfillCoordinates(db *gorm.DB) error {
+	houses := []House{}
+	if err := db.Model(&House{}).
+		Where("geocoded = ? AND long_lat <> ''", false).
+		Find(&houses).Error; err != nil {
+		return err
+	}
+	for _, house := range houses {
+		lng, lat, err := ParseLongLat(house.LongLat)
+		if err != nil {
+			continue // leave unparseable legacy values for the landlord to correct
+		}
+		if err := db.Model(&House{}).Where("id = ?", house.ID).
+			Updates(map[string]interface{}{"latitude": lat, "longitude": lng, "geocoded": true, "version": gorm.Expr("version + 1")}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDistanceKm(t *testing.T) {
+	degree := earthRadiusKm * math.Pi / 180 // along a great circle
+	tests := []struct {
+		name                   string
+		lat1, lng1, lat2, lng2 float64
+		want                   float64
+	}{
+		{"same point", 6.5095, 3.3792, 6.5095, 3.3792, 0},
+		{"a degree of latitude", 0, 0, 1, 0, degree},
+		{"a degree of longitude on the equator", 0, 0, 0, 1, degree},
+		{"across the antimeridian", 0, 179.5, 0, -179.5, degree},
+		{"at the pole, whatever the longitude", 90, 0, 90, 120, 0},
+		{"over the pole", 89.5, 0, 89.5, 180, degree},
+		{"opposite sides of the globe", 0, 0, 0, 180, math.Pi * earthRadiusKm},
+		{"Yaba to Abuja", 6.5095, 3.3792, 9.0765, 7.3986, 526.8},
+	}
+	for _, tt := range tests {
+		if got := DistanceKm(tt.lat1, tt.lng1, tt.lat2, tt.lng2); math.Abs(got-tt.want) > 0.5 {
+			t.Errorf("%s: got %.3f km, want %.3f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseLongLat(t *testing.T) {
+	tests := []struct {
+		value    string
+		lng, lat float64
+		fails    bool
+	}{
+		{"3.3792,6.5095", 3.3792, 6.5095, false},
+		{" 3.3792 , 6.5095 ", 3.3792, 6.5095, false},
+		{"180,90", 180, 90, false},
+		{"-180,-90", -180, -90, false},
+		{"180.0001,0", 0, 0, true},
+		{"0,-90.5", 0, 0, true},
+		{"3.3792", 0, 0, true},
+		{"3.3792,6.5095,0", 0, 0, true},
+		{"east,north", 0, 0, true},
+		{"", 0, 0, true},
+	}
+	for _, tt := range tests {
+		lng, lat, err := ParseLongLat(tt.value)
+		if (err != nil) != tt.fails {
+			t.Errorf("%q: got error %v, want failure %v", tt.value, err, tt.fails)
+			continue
+		}
+		if lng != tt.lng || lat != tt.lat {
+			t.Errorf("%q: got %v,%v, want %v,%v", tt.value, lng, lat, tt.lng, tt.lat)
+		}
+	}
+}
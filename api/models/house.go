@@ -2,77 +2,187 @@
 package models
 
 import (
-    "errors"
     "github.com/jinzhu/gorm"
     "strings"
+    "time"
+
+    "github.com/khelechy/rielzapi/api/apperr"
+    "github.com/khelechy/rielzapi/api/validation"
 )
 
 type House struct {
     gorm.Model
-    HouseType        string `gorm:"size:100;not null;" json:"house_type"`
-    State string `gorm:"not null"                 json:"state"`
-	Description 	string `gorm:"not null"         json:"description"`
-    Location    string `gorm:"size:100;not null"        json:"location"`
-    Rooms    int    `gorm:"not null"                 json:"rooms"`
-	AvailableRooms    int    `gorm:"not null"                 json:"available_rooms"`
-	BathRooms    int    `gorm:"not null"                 json:"bathrooms"`
-    Price    int `gorm:"not null"        json:"price"`
-	LongLat    string    `gorm:"not null"                 json:"long_lat"`
-    CreatedBy   User   `gorm:"foreignKey:UserID;"       json:"-"`
+    HouseType        string `gorm:"size:100;not null;" json:"house_type" validate:"required,max=100"`
+    State string `gorm:"not null"                 json:"state" validate:"required,max=100"`
+	Description 	string `gorm:"not null"         json:"description" validate:"required"`
+    Location    string `gorm:"size:100;not null"        json:"location" validate:"required,max=100"`
+    Rooms    int    `gorm:"not null"                 json:"rooms" validate:"gt=0"`
+	AvailableRooms    int    `gorm:"not null"                 json:"available_rooms" validate:"gte=0,ltefield=Rooms"`
+	BathRooms    int    `gorm:"not null"                 json:"bathrooms" validate:"gte=0"`
+    Price    int `gorm:"not null"        json:"price" validate:"gt=0"`
+	LongLat    string    `gorm:"not null"                 json:"long_lat" validate:"required,longlat"`
+    Latitude    float64 `gorm:"not null;default:0;index:idx_houses_coordinates" json:"latitude"`
+    Longitude   float64 `gorm:"not null;default:0;index:idx_houses_coordinates" json:"longitude"`
+    Geocoded    bool    `gorm:"not null;default:false" json:"-"` // set once Latitude and Longitude were parsed from LongLat
+    CreatedBy   User   `gorm:"foreignKey:UserID;"       json:"-" validate:"-"`
     UserID      uint   `gorm:"not null"                 json:"user_id"`
+    Version     uint   `gorm:"not null;default:1"       json:"version"` // counts the changes to the house, for ETags
+    Media       []HouseMedia `gorm:"foreignKey:HouseID;association_autoupdate:false;association_autocreate:false" json:"media" validate:"-"`
+    Amenities   []Amenity    `gorm:"many2many:house_amenities;save_associations:false" json:"amenities" validate:"-"` // sent as slugs, such as ["parking","water"]
 }
 
+var (
+    // ErrHouseModified is returned when saving a house that was changed since it was read
+    ErrHouseModified = apperr.PreconditionFailed("house_modified", "House was changed since it was read, fetch it again and retry")
+    // ErrRoomsOccupied is returned when a house is given fewer rooms than it has let
+    ErrRoomsOccupied = apperr.Conflict("rooms_occupied", "House cannot have fewer rooms than it has active leases")
+)
+
 func (v *House) Prepare() {
     v.HouseType = strings.TrimSpace(v.HouseType)
     v.Description = strings.TrimSpace(v.Description)
     v.Location = strings.TrimSpace(v.Location)
+    v.LongLat = strings.TrimSpace(v.LongLat)
     v.CreatedBy = User{}
+    v.Media = []HouseMedia{} // uploaded separately
+    amenities := []Amenity{}
+    for _, slug := range AmenitySlugs(v.Amenities) {
+        amenities = append(amenities, Amenity{Slug: slug})
+    }
+    v.Amenities = amenities
 }
 
-func (v *House) Validate() error {
-    if v.HouseType == "" {
-        return errors.New("HouseType is required")
-    }
-    if v.Description == "" {
-        return errors.New("Description about house is required")
-    }
-    if v.Location == "" {
-        return errors.New("Location of house is required")
-    }
-    if v.Price < 0 {
-        return errors.New("Price of house is invalid")
-    }
-    if v.Rooms < 0 {
-        return errors.New("Number of Rooms of house is invalid")
-    }
-	if v.BathRooms < 0 {
-        return errors.New("Number of BathRooms of house is invalid")
+// ParseLongLat fills Latitude and Longitude from the LongLat of the house
+func (v *House) ParseLongLat() error {
+    lng, lat, err := ParseLongLat(v.LongLat)
+    if err != nil {
+        return err
     }
+    v.Longitude, v.Latitude = lng, lat
+    v.Geocoded = true
     return nil
 }
 
-func (v *House) Save(db *gorm.DB) (*House, error) {
-    var err error
+// Validate checks the house against the rules in its validate tags and fills
+// in its coordinates. Creates and updates both call it.
+func (v *House) Validate() error {
+    if err := validation.Struct(v); err != nil {
+        return err
+    }
+    return v.ParseLongLat()
+}
 
-    // Debug a single operation, show detailed log for this operation
-    err = db.Debug().Create(&v).Error
+// Save adds a new house. It has no leases yet, so every room is available
+// whatever AvailableRooms says.
+func (v *House) Save(db *gorm.DB) (*House, error) {
+    v.AvailableRooms = v.Rooms
+    err := db.Transaction(func(tx *gorm.DB) error {
+        amenities, err := findAmenities(v.Amenities, tx)
+        if err != nil {
+            return err
+        }
+        if err = tx.Create(&v).Error; err != nil {
+            return err
+        }
+        v.Amenities = amenities
+        return setHouseAmenities(v.ID, amenities, tx)
+    })
     if err != nil {
         return &House{}, err
     }
     return v, nil
 }
 
-func GetHouses(db *gorm.DB) (*[]House, error) {
+// HouseFilter narrows down, orders and pages the houses returned by GetHouses
+type HouseFilter struct {
+    HouseType    string
+    State        string
+    MinPrice     int
+    MaxPrice     int
+    MinRooms     int
+    MinBathRooms int
+    Available    bool
+    Amenities    []string // slugs of amenities houses must all have
+    SortBy       string
+    Descending   bool
+    Limit        int
+    Offset       int
+}
+
+// houseSortColumns maps the sort keys accepted by the API to table columns
+var houseSortColumns = map[string]string{
+    "price":           "price",
+    "created_at":      "created_at",
+    "available_rooms": "available_rooms",
+}
+
+// ValidHouseSort reports whether houses can be sorted by the given key
+func ValidHouseSort(key string) bool {
+    _, ok := houseSortColumns[key]
+    return ok
+}
+
+// GetHouses returns a page of houses matching the filter along with the total number of matches
+func GetHouses(filter HouseFilter, db *gorm.DB) (*[]House, int, error) {
     houses := []House{}
-    if err := db.Debug().Table("houses").Find(&houses).Error; err != nil {
-        return &[]House{}, err
+    query := db.Model(&House{})
+
+    if filter.HouseType != "" {
+        query = query.Where("house_type = ?", filter.HouseType)
+    }
+    if filter.State != "" {
+        query = query.Where("state = ?", filter.State)
+    }
+    if filter.MinPrice > 0 {
+        query = query.Where("price >= ?", filter.MinPrice)
     }
-    return &houses, nil
+    if filter.MaxPrice > 0 {
+        query = query.Where("price <= ?", filter.MaxPrice)
+    }
+    if filter.MinRooms > 0 {
+        query = query.Where("rooms >= ?", filter.MinRooms)
+    }
+    if filter.MinBathRooms > 0 {
+        query = query.Where("bath_rooms >= ?", filter.MinBathRooms)
+    }
+    if filter.Available {
+        query = query.Where("available_rooms > 0")
+    }
+    if len(filter.Amenities) > 0 {
+        query = query.Where("id IN (?)", housesWithAmenities(filter.Amenities, db))
+    }
+
+    total := 0
+    if err := query.Count(&total).Error; err != nil {
+        return &[]House{}, 0, err
+    }
+
+    column, ok := houseSortColumns[filter.SortBy]
+    if !ok {
+        column = "created_at"
+    }
+    direction := "asc"
+    if filter.Descending {
+        direction = "desc"
+    }
+    query = query.Order(column + " " + direction).Order("id " + direction) // id keeps pages stable on ties
+
+    if filter.Limit > 0 {
+        query = query.Limit(filter.Limit)
+    }
+    if filter.Offset > 0 {
+        query = query.Offset(filter.Offset)
+    }
+
+    if err := houseDetails(query).Find(&houses).Error; err != nil {
+        return &[]House{}, 0, err
+    }
+    return &houses, total, nil
 }
 
 func GetHousesByLandLord(id uint, db *gorm.DB) (*[]House, error){
 	houses := []House{}
-	if err := db.Debug().Table("houses").Where("user_id = ?", id).Find(&houses).Error; err != nil {
+	if err := houseDetails(db).Table("houses").Where("user_id = ?", id).Find(&houses).Error; err != nil {
 		return &[]House{}, err
 	}
 
@@ -81,7 +191,7 @@ func GetHousesByLandLord(id uint, db *gorm.DB) (*[]House, error){
 
 func GetHousesByState(name string, db *gorm.DB) (*[]House, error){
 	houses := []House{}
-	if err := db.Debug().Table("houses").Where("state = ?", name).Find(&houses).Error; err != nil {
+	if err := houseDetails(db).Table("houses").Where("state = ?", name).Find(&houses).Error; err != nil {
 		return &[]House{}, err
 	}
 
@@ -90,29 +200,96 @@ func GetHousesByState(name string, db *gorm.DB) (*[]House, error){
 
 func GetHouseById(id int, db *gorm.DB) (*House, error) {
     house := &House{}
-    if err := db.Debug().Table("houses").Where("id = ?", id).First(house).Error; err != nil {
+    if err := houseDetails(db).Table("houses").Where("id = ?", id).First(house).Error; err != nil {
         return nil, err
     }
     return house, nil
 }
 
+// UpdateHouse writes every editable field of the house, zero values included,
+// so callers pass the house as it should be saved, amenities too. The house
+// must still be at the version it was read at, otherwise ErrHouseModified is
+// returned and nothing is written. Available rooms are not editable; they are
+// recomputed from the active leases, which the rooms may not drop below.
 func (v *House) UpdateHouse(id int, db *gorm.DB) (*House, error) {
-    if err := db.Debug().Table("houses").Where("id = ?", id).Updates(House{
-        HouseType:        v.HouseType,
-        Description: v.Description,
-        Location:    v.Location,
-        Rooms:    v.Rooms,
-        AvailableRooms: v.AvailableRooms,
-		BathRooms:	v.BathRooms,
-        Price:    v.Price}).Error; err != nil {
+    err := db.Transaction(func(tx *gorm.DB) error {
+        if _, err := lockHouse(id, tx); err != nil {
+            return err
+        }
+        occupied, err := CountActiveLeases(uint(id), tx)
+        if err != nil {
+            return err
+        }
+        if v.Rooms < occupied {
+            return ErrRoomsOccupied
+        }
+        amenities, err := findAmenities(v.Amenities, tx)
+        if err != nil {
+            return err
+        }
+        if err = v.update(id, tx); err != nil {
+            return err
+        }
+        if v.AvailableRooms, err = RecomputeAvailableRooms(uint(id), tx); err != nil {
+            return err
+        }
+        v.Version++
+        v.Amenities = amenities
+        return setHouseAmenities(uint(id), amenities, tx)
+    })
+    if err != nil {
         return &House{}, err
     }
     return v, nil
 }
 
-func DeleteHouse(id int, db *gorm.DB) error {
-    if err := db.Debug().Table("houses").Where("id = ?", id).Delete(&House{}).Error; err != nil {
+func (v *House) update(id int, db *gorm.DB) error {
+    result := db.Table("houses").Where("id = ? AND version = ?", id, v.Version).Updates(map[string]interface{}{
+        "house_type":      v.HouseType,
+        "state":           v.State,
+        "description":     v.Description,
+        "location":        v.Location,
+        "rooms":           v.Rooms,
+        "bath_rooms":      v.BathRooms,
+        "price":           v.Price,
+        "long_lat":        v.LongLat,
+        "latitude":        v.Latitude,
+        "longitude":       v.Longitude,
+        "geocoded":        v.Geocoded,
+        "version":         gorm.Expr("version + 1"),
+        "updated_at":      time.Now()})
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return missedHouse(id, db)
+    }
+    v.Version++
+    return nil
+}
+
+// missedHouse tells why a write guarded by the version of a house touched no row:
+// ErrHouseModified when the house is still there, gorm.ErrRecordNotFound otherwise
+func missedHouse(id int, db *gorm.DB) error {
+    count := 0
+    if err := db.Model(&House{}).Where("id = ?", id).Count(&count).Error; err != nil {
         return err
     }
+    if count > 0 {
+        return ErrHouseModified
+    }
+    return gorm.ErrRecordNotFound
+}
+
+// DeleteHouse deletes a house that is still at the version it was read at,
+// otherwise ErrHouseModified is returned and the house is kept
+func DeleteHouse(id int, version uint, db *gorm.DB) error {
+    result := db.Where("id = ? AND version = ?", id, version).Delete(&House{})
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return missedHouse(id, db)
+    }
     return nil
 }
\ No newline at end of file
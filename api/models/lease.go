@@ -0,0 +1,322 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/khelechy/rielzapi/api/apperr"
+)
+
+// Lease statuses
+const (
+	LeaseActive     = "active"
+	LeaseEnded      = "ended"
+	LeaseTerminated = "terminated"
+)
+
+var (
+	// ErrLeaseNotActive is returned when ending or transferring a lease that is already over
+	ErrLeaseNotActive = apperr.Conflict("lease_not_active", "Lease is not active")
+	// ErrNoAvailableRooms is returned when a house has no room left for another lease
+	ErrNoAvailableRooms = apperr.Conflict("no_available_rooms", "There are no available rooms")
+	// ErrLeaseEndsBeforeStart is returned when ending a lease on a date before it started
+	ErrLeaseEndsBeforeStart = apperr.InvalidField("end_date", "End date of lease cannot be before its start date")
+)
+
+// Lease records a tenant's occupancy of a room in a house
+type Lease struct {
+	gorm.Model
+	Tenant     *Tenant    `gorm:"foreignKey:TenantID;association_autoupdate:false;association_autocreate:false" json:"tenant,omitempty"`
+	TenantID   uint       `gorm:"not null;index"          json:"tenant_id"`
+	House      *House     `gorm:"foreignKey:HouseID;association_autoupdate:false;association_autocreate:false" json:"house,omitempty"`
+	HouseID    uint       `gorm:"not null;index"          json:"house_id"`
+	StartDate  time.Time  `gorm:"not null"                json:"start_date"`
+	EndDate    *time.Time `                               json:"end_date"`
+	RentAmount int        `gorm:"not null"                json:"rent_amount"`
+	Status     string     `gorm:"size:20;not null;index"  json:"status"`
+}
+
+// Prepare fills in defaults for a new lease
+func (l *Lease) Prepare() {
+	l.Status = strings.ToLower(strings.TrimSpace(l.Status))
+	if l.Status == "" {
+		l.Status = LeaseActive
+	}
+	if l.StartDate.IsZero() {
+		l.StartDate = time.Now()
+	}
+	l.Tenant = nil
+	l.House = nil
+}
+
+// Validate lease input
+func (l *Lease) Validate() error {
+	if l.RentAmount < 0 {
+		return errors.New("Rent amount of lease is invalid")
+	}
+	if l.EndDate != nil && l.EndDate.Before(l.StartDate) {
+		return errors.New("End date of lease cannot be before its start date")
+	}
+	switch l.Status {
+	case LeaseActive, LeaseEnded, LeaseTerminated:
+	default:
+		return errors.New("Status of lease must be active, ended or terminated")
+	}
+	return nil
+}
+
+// SaveLease adds a lease to the database
+func (l *Lease) SaveLease(db *gorm.DB) (*Lease, error) {
+	if err := db.Create(&l).Error; err != nil {
+		return &Lease{}, err
+	}
+	return l, nil
+}
+
+// GetLeaseById returns a lease along with its tenant
+func GetLeaseById(id int, db *gorm.DB) (*Lease, error) {
+	lease := &Lease{}
+	if err := db.Preload("Tenant").Where("id = ?", id).First(lease).Error; err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// GetActiveLease returns the lease a tenant currently holds
+func GetActiveLease(tenantID uint, db *gorm.DB) (*Lease, error) {
+	lease := &Lease{}
+	if err := db.Where("tenant_id = ? AND status = ?", tenantID, LeaseActive).First(lease).Error; err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// GetLeasesByHouse returns the leases of a house with their tenants, newest first.
+// current selects active leases, past selects ended and terminated ones and
+// neither returns every lease.
+func GetLeasesByHouse(houseID uint, status string, db *gorm.DB) (*[]Lease, error) {
+	leases := []Lease{}
+	query := db.Preload("Tenant").Where("house_id = ?", houseID)
+	switch status {
+	case "current":
+		query = query.Where("status = ?", LeaseActive)
+	case "past":
+		query = query.Where("status <> ?", LeaseActive)
+	}
+	if err := query.Order("start_date desc").Find(&leases).Error; err != nil {
+		return &[]Lease{}, err
+	}
+	return &leases, nil
+}
+
+// GetLeasesByTenantEmail returns the leases, with their houses, of every tenant
+// record registered under the email, newest first
+func GetLeasesByTenantEmail(email string, db *gorm.DB) (*[]Lease, error) {
+	leases := []Lease{}
+	if err := db.Preload("House").Preload("House.Media", inPosition).Preload("House.Amenities", byName).
+		Where("tenant_id IN (?)", db.Model(&Tenant{}).Select("id").Where("email = ?", email).QueryExpr()).
+		Order("start_date desc").Find(&leases).Error; err != nil {
+		return &[]Lease{}, err
+	}
+	return &leases, nil
+}
+
+// CountActiveLeases returns the number of rooms currently let in a house
+func CountActiveLeases(houseID uint, db *gorm.DB) (int, error) {
+	count := 0
+	err := db.Model(&Lease{}).Where("house_id = ? AND status = ?", houseID, LeaseActive).Count(&count).Error
+	return count, err
+}
+
+// RecomputeAvailableRooms sets the available rooms of a house from its active leases
+func RecomputeAvailableRooms(houseID uint, db *gorm.DB) (int, error) {
+	house := &House{}
+	if err := db.Where("id = ?", houseID).First(house).Error; err != nil {
+		return 0, err
+	}
+	occupied, err := CountActiveLeases(houseID, db)
+	if err != nil {
+		return 0, err
+	}
+	available := house.Rooms - occupied
+	if available < 0 {
+		available = 0
+	}
+	// UpdateColumns so that zero is written and UpdatedAt is left alone. The
+	// version still moves on, as the house clients read has changed.
+	if err := db.Model(house).UpdateColumns(map[string]interface{}{
+		"available_rooms": available,
+		"version":         gorm.Expr("version + 1"),
+	}).Error; err != nil {
+		return 0, err
+	}
+	return available, nil
+}
+
+// AddTenant saves a new tenant and opens its lease in a single transaction, so a
+// tenant is never left without a room and two requests cannot take the same one
+func AddTenant(tenant *Tenant, lease *Lease, db *gorm.DB) (*Tenant, *Lease, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockHouse(tenant.HouseId, tx); err != nil {
+			return err
+		}
+		if _, err := tenant.SaveTenant(tx); err != nil {
+			return err
+		}
+		return startLease(tenant, lease, tx)
+	})
+	if err != nil {
+		return &Tenant{}, &Lease{}, err
+	}
+	return tenant, lease, nil
+}
+
+// lockHouse loads a house and holds its row until the transaction ends, so that
+// concurrent allocations for the same house queue up behind each other. SQLite
+// has no row locks; its stores keep a single connection, which a transaction
+// holds until it ends, so allocations run one at a time there anyway.
+func lockHouse(id int, tx *gorm.DB) (*House, error) {
+	query := tx
+	if tx.Dialect().GetName() == "postgres" {
+		query = query.Set("gorm:query_option", "FOR UPDATE")
+	}
+	house := &House{}
+	if err := query.Where("id = ?", id).First(house).Error; err != nil {
+		return nil, err
+	}
+	return house, nil
+}
+
+// lockHouses locks several houses in order of id, so that transactions locking
+// the same houses, such as transfers in opposite directions, cannot deadlock
+func lockHouses(tx *gorm.DB, ids ...int) error {
+	sort.Ints(ids)
+	for _, id := range ids {
+		if _, err := lockHouse(id, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startLease opens a lease for the tenant in its house when a room is free.
+// Callers must already hold the lock on the house.
+func startLease(tenant *Tenant, lease *Lease, tx *gorm.DB) error {
+	house := &House{}
+	if err := tx.Where("id = ?", tenant.HouseId).First(house).Error; err != nil {
+		return err
+	}
+	occupied, err := CountActiveLeases(house.ID, tx)
+	if err != nil {
+		return err
+	}
+	if occupied >= house.Rooms {
+		return ErrNoAvailableRooms
+	}
+
+	lease.TenantID = tenant.ID
+	lease.HouseID = house.ID
+	lease.Status = LeaseActive
+	if _, err := lease.SaveLease(tx); err != nil {
+		return err
+	}
+	_, err = RecomputeAvailableRooms(house.ID, tx)
+	return err
+}
+
+// EndLease closes an active lease as ended or terminated and frees its room
+func EndLease(lease *Lease, status string, endDate time.Time, db *gorm.DB) (*Lease, error) {
+	if status != LeaseEnded && status != LeaseTerminated {
+		return &Lease{}, errors.New("Status of lease must be ended or terminated")
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return endLease(lease, status, endDate, tx)
+	})
+	if err != nil {
+		return &Lease{}, err
+	}
+	return lease, nil
+}
+
+// endLease locks the house of the lease before freeing its room, as allocation
+// does, so that a concurrent allocation cannot commit a stale count
+func endLease(lease *Lease, status string, endDate time.Time, tx *gorm.DB) error {
+	if _, err := lockHouse(int(lease.HouseID), tx); err != nil {
+		return err
+	}
+	if lease.Status != LeaseActive {
+		return ErrLeaseNotActive
+	}
+	if endDate.Before(lease.StartDate) {
+		return ErrLeaseEndsBeforeStart
+	}
+	lease.Status = status
+	lease.EndDate = &endDate
+	if err := tx.Model(lease).Updates(map[string]interface{}{
+		"status":   status,
+		"end_date": endDate,
+	}).Error; err != nil {
+		return err
+	}
+	_, err := RecomputeAvailableRooms(lease.HouseID, tx)
+	return err
+}
+
+// TransferTenant ends the tenant's active lease and opens a new one in another house
+func TransferTenant(tenant *Tenant, houseID int, lease *Lease, db *gorm.DB) (*Lease, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		current, err := GetActiveLease(tenant.ID, tx)
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return ErrLeaseNotActive
+			}
+			return err
+		}
+		if err := lockHouses(tx, int(current.HouseID), houseID); err != nil {
+			return err
+		}
+		if err := endLease(current, LeaseEnded, lease.StartDate, tx); err != nil {
+			return err
+		}
+
+		tenant.HouseId = houseID
+		if err := tx.Model(tenant).UpdateColumn("house_id", houseID).Error; err != nil {
+			return err
+		}
+		return startLease(tenant, lease, tx)
+	})
+	if err != nil {
+		return &Lease{}, err
+	}
+	return lease, nil
+}
+
+// BackfillLeases opens an active lease for every tenant added before leases were tracked
+func BackfillLeases(db *gorm.DB) error {
+	tenants := []Tenant{}
+	if err := db.Where("id NOT IN (?)", db.Model(&Lease{}).Select("tenant_id").QueryExpr()).
+		Find(&tenants).Error; err != nil {
+		return err
+	}
+	for _, tenant := range tenants {
+		house := &House{}
+		if err := db.Where("id = ?", tenant.HouseId).First(house).Error; err != nil {
+			continue // the house is gone, so there is no room to hold
+		}
+		lease := &Lease{
+			TenantID:   tenant.ID,
+			HouseID:    house.ID,
+			StartDate:  tenant.CreatedAt,
+			RentAmount: house.Price,
+			Status:     LeaseActive,
+		}
+		if _, err := lease.SaveLease(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
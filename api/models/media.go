@@ -0,0 +1,223 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/khelechy/rielzapi/api/apperr"
+)
+
+// Kinds of house media
+const (
+	MediaPhoto     = "photo"
+	MediaFloorPlan = "floor_plan"
+	MediaDocument  = "document"
+)
+
+// ErrCoverNotPhoto is returned when making a floor plan or document the cover of a house
+var ErrCoverNotPhoto = apperr.InvalidField("cover", "Only a photo can be the cover of a house")
+
+// HouseMedia is a photo, floor plan or document uploaded for a house. The file
+// itself lives in the blob store under Key; images also have a thumbnail.
+type HouseMedia struct {
+	gorm.Model
+	HouseID      uint   `gorm:"not null;index"         json:"house_id"`
+	Kind         string `gorm:"size:20;not null"       json:"kind"`
+	FileName     string `gorm:"size:255;not null"      json:"file_name"`
+	ContentType  string `gorm:"size:100;not null"      json:"content_type"`
+	Size         int64  `gorm:"not null"               json:"size"`
+	Width        int    `gorm:"not null;default:0"     json:"width,omitempty"`
+	Height       int    `gorm:"not null;default:0"     json:"height,omitempty"`
+	Key          string `gorm:"size:255;not null"      json:"-"`
+	ThumbnailKey string `gorm:"size:255;not null"      json:"-"`
+	Position     int    `gorm:"not null;default:0"     json:"position"`
+	Cover        bool   `gorm:"not null;default:false" json:"cover"`
+}
+
+// TableName keeps the table name singular, as media is already plural
+func (HouseMedia) TableName() string {
+	return "house_media"
+}
+
+// blobURL links a blob key to where clients download it
+var blobURL = func(key string) string { return key }
+
+// UseBlobURLs sets how the keys of uploaded files become the URLs in responses
+func UseBlobURLs(url func(key string) string) {
+	blobURL = url
+}
+
+// MarshalJSON adds the URLs of the file and its thumbnail
+func (m HouseMedia) MarshalJSON() ([]byte, error) {
+	type houseMedia HouseMedia // drops this method so marshalling does not recurse
+	body := struct {
+		houseMedia
+		URL          string `json:"url"`
+		ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	}{houseMedia: houseMedia(m), URL: blobURL(m.Key)}
+	if m.ThumbnailKey != "" {
+		body.ThumbnailURL = blobURL(m.ThumbnailKey)
+	}
+	return json.Marshal(body)
+}
+
+// inPosition orders media the way they are shown. Queries for houses pass it
+// when preloading their media.
+func inPosition(db *gorm.DB) *gorm.DB {
+	return db.Order("position asc").Order("id asc")
+}
+
+// touchHouse moves the version of a house on, as the media clients read with it changed
+func touchHouse(houseID uint, db *gorm.DB) error {
+	return db.Model(&House{}).Where("id = ?", houseID).
+		UpdateColumn("version", gorm.Expr("version + 1")).Error
+}
+
+// SaveHouseMedia adds media to the end of its house's list. The first photo
+// becomes the cover.
+func (m *HouseMedia) SaveHouseMedia(db *gorm.DB) (*HouseMedia, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockHouse(int(m.HouseID), tx); err != nil {
+			return err
+		}
+		var last struct{ Position int }
+		if err := tx.Model(&HouseMedia{}).Select("COALESCE(MAX(position), -1) AS position").
+			Where("house_id = ?", m.HouseID).Scan(&last).Error; err != nil {
+			return err
+		}
+		m.Position = last.Position + 1
+		m.Cover = false
+		if m.Kind == MediaPhoto {
+			covers := 0
+			if err := tx.Model(&HouseMedia{}).Where("house_id = ? AND cover = ?", m.HouseID, true).
+				Count(&covers).Error; err != nil {
+				return err
+			}
+			m.Cover = covers == 0
+		}
+		if err := tx.Create(m).Error; err != nil {
+			return err
+		}
+		return touchHouse(m.HouseID, tx)
+	})
+	if err != nil {
+		return &HouseMedia{}, err
+	}
+	return m, nil
+}
+
+// GetHouseMedia returns the media of a house in the order they are shown
+func GetHouseMedia(houseID uint, db *gorm.DB) (*[]HouseMedia, error) {
+	media := []HouseMedia{}
+	if err := inPosition(db).Where("house_id = ?", houseID).Find(&media).Error; err != nil {
+		return &[]HouseMedia{}, err
+	}
+	return &media, nil
+}
+
+// GetHouseMediaById returns one of the media of a house
+func GetHouseMediaById(houseID uint, id int, db *gorm.DB) (*HouseMedia, error) {
+	media := &HouseMedia{}
+	if err := db.Where("house_id = ? AND id = ?", houseID, id).First(media).Error; err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// ReorderHouseMedia shows the media of a house in the order of ids, which must
+// list each of them exactly once
+func ReorderHouseMedia(houseID uint, ids []uint, db *gorm.DB) (*[]HouseMedia, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockHouse(int(houseID), tx); err != nil {
+			return err
+		}
+		current, err := GetHouseMedia(houseID, tx)
+		if err != nil {
+			return err
+		}
+		if err := CheckMediaOrder(*current, ids); err != nil {
+			return err
+		}
+		for position, id := range ids {
+			if err := tx.Model(&HouseMedia{}).Where("id = ?", id).
+				UpdateColumn("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return touchHouse(houseID, tx)
+	})
+	if err != nil {
+		return &[]HouseMedia{}, err
+	}
+	return GetHouseMedia(houseID, db)
+}
+
+// CheckMediaOrder reports whether ids lists each of the media exactly once
+func CheckMediaOrder(media []HouseMedia, ids []uint) error {
+	invalid := apperr.InvalidField("ids", "ids must list every media of the house exactly once")
+	if len(ids) != len(media) {
+		return invalid
+	}
+	listed := map[uint]bool{}
+	for _, id := range ids {
+		listed[id] = true
+	}
+	for _, m := range media {
+		if !listed[m.ID] {
+			return invalid
+		}
+	}
+	return nil
+}
+
+// SetHouseCover makes a photo the cover of its house in place of the current one
+func SetHouseCover(media *HouseMedia, db *gorm.DB) (*HouseMedia, error) {
+	if media.Kind != MediaPhoto {
+		return &HouseMedia{}, ErrCoverNotPhoto
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockHouse(int(media.HouseID), tx); err != nil {
+			return err
+		}
+		if err := tx.Model(&HouseMedia{}).Where("house_id = ? AND id <> ?", media.HouseID, media.ID).
+			UpdateColumn("cover", false).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&HouseMedia{}).Where("id = ?", media.ID).
+			UpdateColumn("cover", true).Error; err != nil {
+			return err
+		}
+		return touchHouse(media.HouseID, tx)
+	})
+	if err != nil {
+		return &HouseMedia{}, err
+	}
+	media.Cover = true
+	return media, nil
+}
+
+// DeleteHouseMedia removes media from its house. When it was the cover, the
+// next photo takes its place. The files are left for the caller to delete.
+func DeleteHouseMedia(media *HouseMedia, db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockHouse(int(media.HouseID), tx); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(media).Error; err != nil {
+			return err
+		}
+		if media.Cover {
+			next := &HouseMedia{}
+			err := inPosition(tx).Where("house_id = ? AND kind = ?", media.HouseID, MediaPhoto).
+				First(next).Error
+			if err == nil {
+				err = tx.Model(next).UpdateColumn("cover", true).Error
+			}
+			if err != nil && !gorm.IsRecordNotFoundError(err) {
+				return err
+			}
+		}
+		return touchHouse(media.HouseID, tx)
+	})
+}
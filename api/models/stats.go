@@ -0,0 +1,52 @@
+package models
+
+import (
+	"sort"
+
+	"github.com/jinzhu/gorm"
+)
+
+// StateStats sums up the listings and tenants of one state. Tenants counts the
+// active leases, so tenants who moved out are left out.
+type StateStats struct {
+	State          string `json:"state"`
+	Houses         int    `json:"houses"`
+	Rooms          int    `json:"rooms"`
+	AvailableRooms int    `json:"available_rooms"`
+	Tenants        int    `json:"tenants"`
+}
+
+// OccupiedRooms is the number of rooms let out
+func (s StateStats) OccupiedRooms() int {
+	return s.Rooms - s.AvailableRooms
+}
+
+// GetStateStats returns the statistics of every state with a house, ordered by state
+func GetStateStats(db *gorm.DB) (*[]StateStats, error) {
+	houses := []StateStats{}
+	if err := db.Table("houses").
+		Select("state, COUNT(*) AS houses, SUM(rooms) AS rooms, SUM(available_rooms) AS available_rooms").
+		Where("deleted_at IS NULL").Group("state").Scan(&houses).Error; err != nil {
+		return &[]StateStats{}, err
+	}
+
+	tenants := []StateStats{}
+	if err := db.Table("leases").
+		Select("houses.state AS state, COUNT(*) AS tenants").
+		Joins("JOIN houses ON houses.id = leases.house_id AND houses.deleted_at IS NULL").
+		Where("leases.status = ? AND leases.deleted_at IS NULL", LeaseActive).Group("houses.state").Scan(&tenants).Error; err != nil {
+		return &[]StateStats{}, err
+	}
+
+	byState := map[string]*StateStats{}
+	for i := range houses {
+		byState[houses[i].State] = &houses[i]
+	}
+	for _, row := range tenants {
+		if stats, ok := byState[row.State]; ok {
+			stats.Tenants = row.Tenants
+		}
+	}
+	sort.Slice(houses, func(i, j int) bool { return houses[i].State < houses[j].State })
+	return &houses, nil
+}
@@ -2,56 +2,79 @@ package models
 
 
 import (
-    "errors"
     "strings"
+    "time"
 
     "github.com/jinzhu/gorm"
+
+    "github.com/khelechy/rielzapi/api/validation"
 )
 
 type Tenant struct {
     gorm.Model
-    Email        string `gorm:"not null" json:"email"`
-    FirstName    string `gorm:"size:100;not null"              json:"firstname"`
-    LastName     string `gorm:"size:100;not null"              json:"lastname"`
-    PhoneNumber  string `gorm:"size:100;not null"              json:"phonenumber"`
-    Apartment   House   `gorm:"foreignKey:HouseId;"       json:"-"`
-	HouseId  int `gorm:"not null"              json:"house_id"`
+    Email        string `gorm:"not null" json:"email"                           validate:"required,email"`
+    FirstName    string `gorm:"size:100;not null"              json:"firstname"   validate:"required,max=100"`
+    LastName     string `gorm:"size:100;not null"              json:"lastname"    validate:"required,max=100"`
+    PhoneNumber  string `gorm:"size:100;not null"              json:"phonenumber" validate:"required,e164"`
+    Apartment   House   `gorm:"foreignKey:HouseId;"       json:"-"              validate:"-"`
+	HouseId  int `gorm:"not null"              json:"house_id"                      validate:"gt=0"`
 }
 
 
 func (v *Tenant) Prepare() {
     v.FirstName = strings.TrimSpace(v.FirstName)
     v.LastName = strings.TrimSpace(v.LastName)
-    v.PhoneNumber = strings.TrimSpace(v.PhoneNumber)
+    v.PhoneNumber = NormalizePhoneNumber(v.PhoneNumber)
     v.Email = strings.TrimSpace(v.Email)
 }
 
+// Validate checks the tenant against the rules in its validate tags
 func (v *Tenant) Validate() error {
-    if v.FirstName == "" {
-        return errors.New("Firstname is required")
-    }
-    if v.LastName == "" {
-        return errors.New("Lastname is required")
-    }
-    if v.PhoneNumber == "" {
-        return errors.New("PhoneNumber is required")
-    }
-    if v.Email == "" {
-        return errors.New("Email is required")
-    }
-    if v.HouseId < 0 {
-        return errors.New("HouseId of house is invalid")
-    }
-    return nil
+    return validation.Struct(v)
 }
 
 func (u *Tenant) SaveTenant(db *gorm.DB) (*Tenant, error) {
     var err error
 
-    // Debug a single operation, show detailed log for this operation
-    err = db.Debug().Create(&u).Error
+    err = db.Create(&u).Error
     if err != nil {
         return &Tenant{}, err
     }
     return u, nil
-}
\ No newline at end of file
+}
+
+// GetTenantById returns a tenant by id
+func GetTenantById(id int, db *gorm.DB) (*Tenant, error) {
+    tenant := &Tenant{}
+    if err := db.Table("tenants").Where("id = ?", id).First(tenant).Error; err != nil {
+        return nil, err
+    }
+    return tenant, nil
+}
+
+// UpdateTenant updates the contact details of a tenant
+func (v *Tenant) UpdateTenant(id int, db *gorm.DB) (*Tenant, error) {
+    if err := db.Table("tenants").Where("id = ?", id).Updates(Tenant{
+        FirstName:   v.FirstName,
+        LastName:    v.LastName,
+        PhoneNumber: v.PhoneNumber,
+        Email:       v.Email}).Error; err != nil {
+        return &Tenant{}, err
+    }
+    return v, nil
+}
+
+// DeleteTenant terminates the tenant's active lease, if any, and removes the tenant
+func DeleteTenant(tenant *Tenant, db *gorm.DB) error {
+    return db.Transaction(func(tx *gorm.DB) error {
+        lease, err := GetActiveLease(tenant.ID, tx)
+        if err == nil {
+            if err = endLease(lease, LeaseTerminated, time.Now(), tx); err != nil {
+                return err
+            }
+        } else if !gorm.IsRecordNotFoundError(err) {
+            return err
+        }
+        return tx.Table("tenants").Where("id = ?", tenant.ID).Delete(&Tenant{}).Error
+    })
+}
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/khelechy/rielzapi/api/apperr"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for refresh tokens that are unknown, expired or revoked
+	ErrInvalidRefreshToken = apperr.Unauthorized("invalid_refresh_token", "Invalid refresh token, please login")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again,
+	// which means it has leaked; every session of the user is revoked when that happens
+	ErrRefreshTokenReused = apperr.Unauthorized("refresh_token_reused", "Refresh token was already used, all sessions have been logged out")
+)
+
+// RefreshToken is a long lived token a client exchanges for new access tokens.
+// Only a hash of the token is stored.
+type RefreshToken struct {
+	gorm.Model
+	UserID    uint      `gorm:"not null;index"`
+	TokenHash string    `gorm:"size:64;not null;unique_index"`
+	ExpiresAt time.Time `gorm:"not null"`
+	RevokedAt *time.Time
+	// Rotated is set when the token was revoked by exchanging it rather than by a logout
+	Rotated bool `gorm:"not null;default:false"`
+}
+
+// RevokedToken is an access token, identified by its jti claim, that was logged out
+// before it expired. Rows can be dropped once ExpiresAt has passed.
+type RevokedToken struct {
+	ID        uint      `gorm:"primary_key"`
+	JTI       string    `gorm:"column:jti;size:64;not null;unique_index"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// SaveRefreshToken stores the hash of a new refresh token for the user
+func SaveRefreshToken(userID uint, tokenHash string, expiresAt time.Time, db *gorm.DB) (*RefreshToken, error) {
+	token := &RefreshToken{UserID: userID, TokenHash: tokenHash, ExpiresAt: expiresAt}
+	if err := db.Create(token).Error; err != nil {
+		return &RefreshToken{}, err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken revokes the refresh token with the given hash and stores its replacement,
+// returning the user the tokens belong to
+func RotateRefreshToken(tokenHash, newHash string, expiresAt time.Time, db *gorm.DB) (*User, error) {
+	user := &User{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		current := &RefreshToken{}
+		if err := tx.Where("token_hash = ?", tokenHash).First(current).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+		if current.RevokedAt != nil {
+			if current.Rotated {
+				return ErrRefreshTokenReused
+			}
+			return ErrInvalidRefreshToken
+		}
+		if time.Now().After(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+		if err := tx.Where("id = ?", current.UserID).First(user).Error; err != nil {
+			return err
+		}
+
+		// only the request that flips revoked_at gets to rotate, concurrent ones see a reuse
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", current.ID).
+			UpdateColumns(map[string]interface{}{"revoked_at": time.Now(), "rotated": true})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+		_, err := SaveRefreshToken(current.UserID, newHash, expiresAt, tx)
+		return err
+	})
+	if err == ErrRefreshTokenReused {
+		// outside the transaction so the revocation is kept even though the rotation failed
+		if ownerID, lookupErr := refreshTokenOwner(tokenHash, db); lookupErr == nil {
+			if revokeErr := RevokeAllSessions(ownerID, db); revokeErr != nil {
+				return nil, revokeErr
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func refreshTokenOwner(tokenHash string, db *gorm.DB) (uint, error) {
+	token := &RefreshToken{}
+	if err := db.Where("token_hash = ?", tokenHash).First(token).Error; err != nil {
+		return 0, err
+	}
+	return token.UserID, nil
+}
+
+// RevokeRefreshToken revokes one refresh token of the user
+func RevokeRefreshToken(userID uint, tokenHash string, db *gorm.DB) error {
+	return db.Model(&RefreshToken{}).
+		Where("user_id = ? AND token_hash = ? AND revoked_at IS NULL", userID, tokenHash).
+		UpdateColumn("revoked_at", time.Now()).Error
+}
+
+// RevokeAccessToken rejects the access token with the given jti until it expires
+func RevokeAccessToken(jti string, expiresAt time.Time, db *gorm.DB) error {
+	return db.Where(RevokedToken{JTI: jti}).
+		Attrs(RevokedToken{ExpiresAt: expiresAt}).
+		FirstOrCreate(&RevokedToken{}).Error
+}
+
+// RevokeAllSessions revokes every refresh token of the user and rejects every access token
+// issued to them before now
+func RevokeAllSessions(userID uint, db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return revokeAllSessions(userID, tx)
+	})
+}
+
+// revokeAllSessions revokes the sessions of the user within the caller's transaction
+func revokeAllSessions(userID uint, tx *gorm.DB) error {
+	now := time.Now()
+	if err := tx.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		UpdateColumn("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return tx.Table("users").Where("id = ?", userID).
+		UpdateColumn("tokens_valid_after", now).Error
+}
+
+// IsAccessTokenRevoked reports whether an access token was logged out on its own or
+// issued before its user logged out of every session
+func IsAccessTokenRevoked(jti string, userID uint, issuedAt int64, db *gorm.DB) (bool, error) {
+	count := 0
+	if err := db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	user := &User{}
+	if err := db.Table("users").Select("tokens_valid_after").Where("id = ?", userID).First(user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return true, nil // the account is gone
+		}
+		return false, err
+	}
+	// iat has second precision, so tokens issued in the same second as the logout survive until they expire
+	return user.TokensValidAfter != nil && issuedAt < user.TokensValidAfter.Unix(), nil
+}
+
+// PurgeExpiredTokens drops revocation and refresh token rows that can no longer be used
+func PurgeExpiredTokens(db *gorm.DB) error {
+	now := time.Now()
+	if err := db.Where("expires_at < ?", now).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return db.Unscoped().Where("expires_at < ?", now).Delete(&RefreshToken{}).Error
+}
@@ -5,20 +5,48 @@ package models
 import (
     "errors"
     "strings"
+    "time"
 
-    "github.com/badoux/checkmail"
     "github.com/jinzhu/gorm"
     "golang.org/x/crypto/bcrypt"
+
+    "github.com/khelechy/rielzapi/api/validation"
+)
+
+// User roles
+const (
+    RoleAdmin    = "admin"
+    RoleLandlord = "landlord"
+    RoleTenant   = "tenant"
+    RoleAgent    = "agent"
 )
 
+// ValidRole reports whether role is one of the known user roles
+func ValidRole(role string) bool {
+    switch role {
+    case RoleAdmin, RoleLandlord, RoleTenant, RoleAgent:
+        return true
+    }
+    return false
+}
+
 // User model
 type User struct {
     gorm.Model
-    Email        string `gorm:"type:varchar(100);unique_index" json:"email"`
-    FirstName    string `gorm:"size:100;not null"              json:"firstname"`
-    LastName     string `gorm:"size:100;not null"              json:"lastname"`
-    Password     string `gorm:"size:100;not null"              json:"password"`
-    PhoneNumber  string `gorm:"size:100;not null"              json:"phonenumber"`
+    Email        string `gorm:"type:varchar(100);unique_index" json:"email"       validate:"required,email,max=100"`
+    FirstName    string `gorm:"size:100;not null"              json:"firstname"   validate:"required,max=100"`
+    LastName     string `gorm:"size:100;not null"              json:"lastname"    validate:"required,max=100"`
+    Password     string `gorm:"size:100;not null"              json:"-"           validate:"required"`
+    PhoneNumber  string `gorm:"size:100;not null"              json:"phonenumber" validate:"required,e164"`
+    Role         string `gorm:"size:20;not null;default:'landlord'" json:"role" validate:"omitempty,oneof=admin landlord tenant agent"`
+    Suspended    bool   `gorm:"not null;default:false"         json:"suspended"`
+    TokensValidAfter *time.Time `                              json:"-"`
+    EmailVerifiedAt  *time.Time `                              json:"email_verified_at"`
+}
+
+// EmailVerified reports whether the user has confirmed they own their email
+func (u *User) EmailVerified() bool {
+    return u.EmailVerifiedAt != nil
 }
 
 // HashPassword hashes password from user input
@@ -52,40 +80,31 @@ func (u *User) Prepare() {
     u.Email = strings.TrimSpace(u.Email)
     u.FirstName = strings.TrimSpace(u.FirstName)
     u.LastName = strings.TrimSpace(u.LastName)
-    u.PhoneNumber = strings.TrimSpace(u.PhoneNumber)
+    u.PhoneNumber = NormalizePhoneNumber(u.PhoneNumber)
+    u.Role = strings.ToLower(strings.TrimSpace(u.Role))
+}
+
+// NormalizePhoneNumber drops the spaces, dashes, dots and brackets people
+// write phone numbers with, so "+234 801-234-5678" is stored as +2348012345678
+func NormalizePhoneNumber(phone string) string {
+    return strings.Map(func(r rune) rune {
+        switch r {
+        case ' ', '-', '.', '(', ')':
+            return -1
+        }
+        return r
+    }, strings.TrimSpace(phone))
 }
 
-// Validate user input
+// Validate user input against the rules in its validate tags. Logins only
+// carry an email and password; anything else, such as "" for signing up,
+// checks every field.
 func (u *User) Validate(action string) error {
     switch strings.ToLower(action) {
     case "login":
-        if u.Email == "" {
-            return errors.New("Email is required")
-        }
-        if u.Password == "" {
-            return errors.New("Password is required")
-        }
-        return nil
-    default: // this is for creating a user, where all fields are required
-        if u.FirstName == "" {
-            return errors.New("FirstName is required")
-        }
-        if u.LastName == "" {
-            return errors.New("LastName is required")
-        }
-        if u.Email == "" {
-            return errors.New("Email is required")
-        }
-        if u.Password == "" {
-            return errors.New("Password is required")
-        }
-        if u.PhoneNumber == ""{
-            return errors.New("Phone Number is required")
-        }
-        if err := checkmail.ValidateFormat(u.Email); err != nil {
-            return errors.New("Invalid Email")
-        }
-        return nil
+        return validation.Fields(u, "Email", "Password")
+    default:
+        return validation.Struct(u)
     }
 }
 
@@ -93,8 +112,7 @@ func (u *User) Validate(action string) error {
 func (u *User) SaveUser(db *gorm.DB) (*User, error) {
     var err error
 
-    // Debug a single operation, show detailed log for this operation
-    err = db.Debug().Create(&u).Error
+    err = db.Create(&u).Error
     if err != nil {
         return &User{}, err
     }
@@ -104,7 +122,7 @@ func (u *User) SaveUser(db *gorm.DB) (*User, error) {
 // GetUser returns a user based on email
 func (u *User) GetUser(db *gorm.DB) (*User, error) {
     account := &User{}
-    if err := db.Debug().Table("users").Where("email = ?", u.Email).First(account).Error; err != nil {
+    if err := db.Table("users").Where("email = ?", u.Email).First(account).Error; err != nil {
         return nil, err
     }
     return account, nil
@@ -113,7 +131,7 @@ func (u *User) GetUser(db *gorm.DB) (*User, error) {
 // GetAllUsers returns a list of all the user
 func GetAllUsers(db *gorm.DB) (*[]User, error) {
     users := []User{}
-    if err := db.Debug().Table("users").Find(&users).Error; err != nil {
+    if err := db.Table("users").Find(&users).Error; err != nil {
         return &[]User{}, err
     }
     return &users, nil
@@ -121,19 +139,45 @@ func GetAllUsers(db *gorm.DB) (*[]User, error) {
 
 func GetUserById(id int, db *gorm.DB) (*User, error) {
     user := &User{}
-    if err := db.Debug().Table("users").Where("id = ?", id).First(user).Error; err != nil {
+    if err := db.Table("users").Where("id = ?", id).First(user).Error; err != nil {
         return nil, err
     }
     return user, nil
 }
 
+// UpdateUser writes the profile fields of the user, zero values included.
+// Role, suspension and password have their own setters.
 func (v *User) UpdateUser(id int, db *gorm.DB) (*User, error) {
-    if err := db.Debug().Table("users").Where("id = ?", id).Updates(User{
-        FirstName:        v.FirstName,
-        LastName: v.LastName,
-        PhoneNumber:    v.PhoneNumber,
-        Email:    v.Email}).Error; err != nil {
+    err := db.Transaction(func(tx *gorm.DB) error {
+        // a new address has to be verified again, and links mailed to the old one must not verify it
+        changed := tx.Table("users").Where("id = ? AND email <> ?", id, v.Email).UpdateColumn("email_verified_at", nil)
+        if changed.Error != nil {
+            return changed.Error
+        }
+        if changed.RowsAffected > 0 {
+            if err := expireUserTokens(uint(id), EmailVerificationToken, tx); err != nil {
+                return err
+            }
+        }
+        return tx.Table("users").Where("id = ?", id).Updates(map[string]interface{}{
+            "first_name":   v.FirstName,
+            "last_name":    v.LastName,
+            "phone_number": v.PhoneNumber,
+            "email":        v.Email,
+            "updated_at":   time.Now()}).Error
+    })
+    if err != nil {
         return &User{}, err
     }
     return v, nil
+}
+
+// SetRole changes the role of a user
+func SetRole(id int, role string, db *gorm.DB) error {
+    return db.Table("users").Where("id = ?", id).UpdateColumn("role", role).Error
+}
+
+// SetSuspended suspends or reinstates a user
+func SetSuspended(id int, suspended bool, db *gorm.DB) error {
+    return db.Table("users").Where("id = ?", id).UpdateColumn("suspended", suspended).Error
 }
\ No newline at end of file
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/khelechy/rielzapi/api/apperr"
+)
+
+// Purposes of single use user tokens
+const (
+	PasswordResetToken     = "password_reset"
+	EmailVerificationToken = "email_verification"
+)
+
+// ErrInvalidUserToken is returned for user tokens that are unknown, used or expired
+var ErrInvalidUserToken = apperr.BadRequest("invalid_token", "Token is invalid or has expired")
+
+// UserToken is a single use token mailed to a user to reset their password or
+// verify their email. Only a hash of the token is stored, along with the
+// address it was mailed to.
+type UserToken struct {
+	gorm.Model
+	UserID    uint      `gorm:"not null;index"`
+	Email     string    `gorm:"type:varchar(100);not null;default:''"`
+	Purpose   string    `gorm:"size:30;not null"`
+	TokenHash string    `gorm:"size:64;not null;unique_index"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+// SaveUserToken stores a new token for the user, mailed to email, invalidating
+// earlier unused tokens with the same purpose
+func SaveUserToken(userID uint, email, purpose, tokenHash string, expiresAt time.Time, db *gorm.DB) (*UserToken, error) {
+	token := &UserToken{UserID: userID, Email: email, Purpose: purpose, TokenHash: tokenHash, ExpiresAt: expiresAt}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := expireUserTokens(userID, purpose, tx); err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+	if err != nil {
+		return &UserToken{}, err
+	}
+	return token, nil
+}
+
+// expireUserTokens marks the unused tokens of a user for a purpose as used
+func expireUserTokens(userID uint, purpose string, tx *gorm.DB) error {
+	return tx.Model(&UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		UpdateColumn("used_at", time.Now()).Error
+}
+
+// consumeUserToken marks a valid token as used and returns it
+func consumeUserToken(purpose, tokenHash string, tx *gorm.DB) (*UserToken, error) {
+	token := &UserToken{}
+	if err := tx.Where("token_hash = ? AND purpose = ?", tokenHash, purpose).First(token).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, ErrInvalidUserToken
+		}
+		return nil, err
+	}
+	if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return nil, ErrInvalidUserToken
+	}
+
+	// the guard on used_at makes concurrent uses of the same token fail
+	result := tx.Model(&UserToken{}).Where("id = ? AND used_at IS NULL", token.ID).UpdateColumn("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidUserToken
+	}
+	return token, nil
+}
+
+// ResetPassword sets a new password for the owner of a password reset token and logs
+// them out everywhere
+func ResetPassword(tokenHash, password string, db *gorm.DB) error {
+	hashed, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeUserToken(PasswordResetToken, tokenHash, tx)
+		if err != nil {
+			return err
+		}
+		if err := tx.Table("users").Where("id = ?", token.UserID).UpdateColumn("password", hashed).Error; err != nil {
+			return err
+		}
+		return revokeAllSessions(token.UserID, tx)
+	})
+}
+
+// VerifyEmail marks the email of the owner of a verification token as verified,
+// as long as it is still the address the token was mailed to
+func VerifyEmail(tokenHash string, db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeUserToken(EmailVerificationToken, tokenHash, tx)
+		if err != nil {
+			return err
+		}
+		result := tx.Table("users").Where("id = ? AND email = ?", token.UserID, token.Email).UpdateColumn("email_verified_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidUserToken
+		}
+		return nil
+	})
+}
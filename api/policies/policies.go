@@ -0,0 +1,117 @@
+// Package policies decides what the logged in user is allowed to do with each resource.
+// Handlers load the resource first, so a missing resource is reported as not found
+// before any policy is consulted.
+package policies
+
+import (
+	"github.com/khelechy/rielzapi/api/models"
+)
+
+// Actor is the user a request is made on behalf of
+type Actor struct {
+	UserID uint
+	Role   string
+	// Landlords holds the landlords an agent is linked to
+	Landlords []uint
+}
+
+// IsAdmin reports whether the actor moderates the platform
+func (a Actor) IsAdmin() bool {
+	return a.Role == models.RoleAdmin
+}
+
+// actsFor reports whether the actor may act as the given landlord
+func (a Actor) actsFor(landlordID uint) bool {
+	if a.UserID == landlordID {
+		return true
+	}
+	if a.Role == models.RoleAgent {
+		for _, id := range a.Landlords {
+			if id == landlordID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// manages reports whether the actor owns the house, is an agent of its landlord or is an admin
+func (a Actor) manages(house *models.House) bool {
+	return house != nil && (a.IsAdmin() || a.actsFor(house.UserID))
+}
+
+// CanCreateHouseFor reports whether the actor may list a house owned by the landlord
+func CanCreateHouseFor(actor Actor, landlordID uint) bool {
+	return actor.IsAdmin() || actor.actsFor(landlordID)
+}
+
+// CanUpdateHouse reports whether the actor may edit the house listing
+func CanUpdateHouse(actor Actor, house *models.House) bool {
+	return actor.manages(house)
+}
+
+// CanDeleteHouse reports whether the actor may remove the house listing
+func CanDeleteHouse(actor Actor, house *models.House) bool {
+	return actor.manages(house)
+}
+
+// CanAddTenant reports whether the actor may let a room of the house to a new tenant
+func CanAddTenant(actor Actor, house *models.House) bool {
+	return actor.manages(house)
+}
+
+// CanManageTenants reports whether the actor may view, edit, move or remove the
+// tenants and leases of the house
+func CanManageTenants(actor Actor, house *models.House) bool {
+	return actor.manages(house)
+}
+
+// CanUpdateUser reports whether the actor may edit the user's account
+func CanUpdateUser(actor Actor, user *models.User) bool {
+	return user != nil && (actor.IsAdmin() || user.ID == actor.UserID)
+}
+
+// CanLinkAgent reports whether the actor may link or unlink agents for the landlord
+func CanLinkAgent(actor Actor, landlordID uint) bool {
+	return actor.IsAdmin() || (actor.Role == models.RoleLandlord && actor.UserID == landlordID)
+}
+
+// CanModerateUsers reports whether the actor may suspend users or change their roles
+func CanModerateUsers(actor Actor) bool {
+	return actor.IsAdmin()
+}
+
+// CanManageAmenities reports whether the actor may add, change or remove amenities of the catalog
+func CanManageAmenities(actor Actor) bool {
+	return actor.IsAdmin()
+}
+
+// Visibility is how much of a user's profile the actor may see
+type Visibility int
+
+// Profile visibilities, each showing everything the one before it does
+const (
+	// VisibilityPublic shows the name and role that anyone may see
+	VisibilityPublic Visibility = iota
+	// VisibilityContact adds the email and phone number, for users who work together
+	VisibilityContact
+	// VisibilitySelf adds the account details only the user needs
+	VisibilitySelf
+	// VisibilityAdmin adds the moderation state of the account
+	VisibilityAdmin
+)
+
+// UserVisibility decides how much of the user's profile the actor may see
+func UserVisibility(actor Actor, user *models.User) Visibility {
+	switch {
+	case user == nil:
+		return VisibilityPublic
+	case actor.IsAdmin():
+		return VisibilityAdmin
+	case actor.UserID != 0 && actor.UserID == user.ID:
+		return VisibilitySelf
+	case actor.Role == models.RoleAgent && actor.actsFor(user.ID):
+		return VisibilityContact
+	}
+	return VisibilityPublic
+}
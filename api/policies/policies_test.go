@@ -0,0 +1,35 @@
+package policies
+
+import (
+	"testing"
+
+	"github.com/khelechy/rielzapi/api/models"
+)
+
+func TestUserVisibility(t *testing.T) {
+	landlord := &models.User{Role: models.RoleLandlord}
+	landlord.ID = 1
+
+	tests := []struct {
+		name  string
+		actor Actor
+		user  *models.User
+		want  Visibility
+	}{
+		{"anonymous", Actor{}, landlord, VisibilityPublic},
+		{"another landlord", Actor{UserID: 2, Role: models.RoleLandlord}, landlord, VisibilityPublic},
+		{"tenant", Actor{UserID: 3, Role: models.RoleTenant}, landlord, VisibilityPublic},
+		{"agent of another landlord", Actor{UserID: 4, Role: models.RoleAgent, Landlords: []uint{2}}, landlord, VisibilityPublic},
+		{"agent of the landlord", Actor{UserID: 4, Role: models.RoleAgent, Landlords: []uint{2, 1}}, landlord, VisibilityContact},
+		{"landlord claiming agents", Actor{UserID: 2, Role: models.RoleLandlord, Landlords: []uint{1}}, landlord, VisibilityPublic},
+		{"the user", Actor{UserID: 1, Role: models.RoleLandlord}, landlord, VisibilitySelf},
+		{"admin", Actor{UserID: 5, Role: models.RoleAdmin}, landlord, VisibilityAdmin},
+		{"admin viewing themselves", Actor{UserID: 1, Role: models.RoleAdmin}, landlord, VisibilityAdmin},
+		{"no user", Actor{UserID: 5, Role: models.RoleAdmin}, nil, VisibilityPublic},
+	}
+	for _, tt := range tests {
+		if got := UserVisibility(tt.actor, tt.user); got != tt.want {
+			t.Errorf("%s: got visibility %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres" //postgres
+	_ "github.com/jinzhu/gorm/dialects/sqlite"   //sqlite
+
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/config"
+)
+
+// gormRepository keeps data in a SQL database through the gorm functions of the models
+type gormRepository struct {
+	db *gorm.DB
+}
+
+// NewPostgresStore connects to a Postgres database and sizes its connection pool
+func NewPostgresStore(cfg config.Database) (*Store, error) {
+	uri := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=%s password=%s",
+		dsnValue(cfg.Host), dsnValue(cfg.Port), dsnValue(cfg.User), dsnValue(cfg.Name), dsnValue(cfg.SSLMode), dsnValue(cfg.Password))
+	db, err := gorm.Open("postgres", uri)
+	if err != nil {
+		return nil, err
+	}
+	db.DB().SetMaxOpenConns(cfg.MaxOpenConns)
+	db.DB().SetMaxIdleConns(cfg.MaxIdleConns)
+	db.DB().SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	return NewGormStore(db), nil
+}
+
+// dsnValue quotes a connection string value so spaces and quotes in it survive
+func dsnValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// NewSQLiteStore opens, creating when needed, a SQLite database file. A path of
+// ":memory:" keeps the database in memory for as long as the store is open.
+func NewSQLiteStore(path string) (*Store, error) {
+	db, err := gorm.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time, and every connection to :memory: would
+	// see a database of its own
+	db.DB().SetMaxOpenConns(1)
+	return NewGormStore(db), nil
+}
+
+// NewGormStore serves the repositories from an open gorm database
+func NewGormStore(db *gorm.DB) *Store {
+	repo := &gormRepository{db: db}
+	return &Store{Houses: repo, Amenities: repo, Users: repo, Tenants: repo, Tokens: repo, DB: db}
+}
+
+func (g *gormRepository) GetHouses(filter models.HouseFilter) (*[]models.House, int, error) {
+	return models.GetHouses(filter, g.db)
+}
+
+func (g *gormRepository) GetHouseById(id int) (*models.House, error) {
+	return models.GetHouseById(id, g.db)
+}
+
+func (g *gormRepository) GetHousesByLandlord(landlordID uint) (*[]models.House, error) {
+	return models.GetHousesByLandLord(landlordID, g.db)
+}
+
+func (g *gormRepository) GetHousesByState(state string) (*[]models.House, error) {
+	return models.GetHousesByState(state, g.db)
+}
+
+func (g *gormRepository) GetHousesNearby(lat, lng, radiusKm float64, limit int) (*[]models.NearbyHouse, error) {
+	return models.GetHousesNearby(lat, lng, radiusKm, limit, g.db)
+}
+
+func (g *gormRepository) SaveHouse(house *models.House) (*models.House, error) {
+	return house.Save(g.db)
+}
+
+func (g *gormRepository) UpdateHouse(id int, house *models.House) (*models.House, error) {
+	return house.UpdateHouse(id, g.db)
+}
+
+func (g *gormRepository) DeleteHouse(id int, version uint) error {
+	return models.DeleteHouse(id, version, g.db)
+}
+
+func (g *gormRepository) GetStateStats() (*[]models.StateStats, error) {
+	return models.GetStateStats(g.db)
+}
+
+func (g *gormRepository) SaveHouseMedia(media *models.HouseMedia) (*models.HouseMedia, error) {
+	return media.SaveHouseMedia(g.db)
+}
+
+func (g *gormRepository) GetHouseMediaById(houseID uint, id int) (*models.HouseMedia, error) {
+	return models.GetHouseMediaById(houseID, id, g.db)
+}
+
+func (g *gormRepository) ReorderHouseMedia(houseID uint, ids []uint) (*[]models.HouseMedia, error) {
+	return models.ReorderHouseMedia(houseID, ids, g.db)
+}
+
+func (g *gormRepository) SetHouseCover(media *models.HouseMedia) (*models.HouseMedia, error) {
+	return models.SetHouseCover(media, g.db)
+}
+
+func (g *gormRepository) DeleteHouseMedia(media *models.HouseMedia) error {
+	return models.DeleteHouseMedia(media, g.db)
+}
+
+func (g *gormRepository) GetAmenities() (*[]models.Amenity, error) {
+	return models.GetAmenities(g.db)
+}
+
+func (g *gormRepository) GetAmenityById(id int) (*models.Amenity, error) {
+	return models.GetAmenityById(id, g.db)
+}
+
+func (g *gormRepository) SaveAmenity(amenity *models.Amenity) (*models.Amenity, error) {
+	return amenity.SaveAmenity(g.db)
+}
+
+func (g *gormRepository) UpdateAmenity(id int, amenity *models.Amenity) (*models.Amenity, error) {
+	return amenity.UpdateAmenity(id, g.db)
+}
+
+func (g *gormRepository) DeleteAmenity(id int) error {
+	return models.DeleteAmenity(id, g.db)
+}
+
+func (g *gormRepository) GetUsers() (*[]models.User, error) {
+	return models.GetAllUsers(g.db)
+}
+
+func (g *gormRepository) GetUserById(id int) (*models.User, error) {
+	return models.GetUserById(id, g.db)
+}
+
+func (g *gormRepository) GetUserByEmail(email string) (*models.User, error) {
+	return (&models.User{Email: email}).GetUser(g.db)
+}
+
+func (g *gormRepository) SaveUser(user *models.User) (*models.User, error) {
+	return user.SaveUser(g.db)
+}
+
+func (g *gormRepository) UpdateUser(id int, user *models.User) (*models.User, error) {
+	return user.UpdateUser(id, g.db)
+}
+
+func (g *gormRepository) SetRole(id int, role string) error {
+	return models.SetRole(id, role, g.db)
+}
+
+func (g *gormRepository) SetSuspended(id int, suspended bool) error {
+	return models.SetSuspended(id, suspended, g.db)
+}
+
+func (g *gormRepository) LinkAgent(agentID, landlordID uint) (*models.AgentLink, error) {
+	return models.LinkAgent(agentID, landlordID, g.db)
+}
+
+func (g *gormRepository) UnlinkAgent(agentID, landlordID uint) error {
+	return models.UnlinkAgent(agentID, landlordID, g.db)
+}
+
+func (g *gormRepository) GetAgentsByLandlord(landlordID uint) (*[]models.User, error) {
+	return models.GetAgentsByLandlord(landlordID, g.db)
+}
+
+func (g *gormRepository) GetLinkedLandlordIDs(agentID uint) ([]uint, error) {
+	return models.GetLinkedLandlordIDs(agentID, g.db)
+}
+
+func (g *gormRepository) GetTenantById(id int) (*models.Tenant, error) {
+	return models.GetTenantById(id, g.db)
+}
+
+func (g *gormRepository) AddTenant(tenant *models.Tenant, lease *models.Lease) (*models.Tenant, *models.Lease, error) {
+	return models.AddTenant(tenant, lease, g.db)
+}
+
+func (g *gormRepository) UpdateTenant(id int, tenant *models.Tenant) (*models.Tenant, error) {
+	return tenant.UpdateTenant(id, g.db)
+}
+
+func (g *gormRepository) DeleteTenant(tenant *models.Tenant) error {
+	return models.DeleteTenant(tenant, g.db)
+}
+
+func (g *gormRepository) TransferTenant(tenant *models.Tenant, houseID int, lease *models.Lease) (*models.Lease, error) {
+	return models.TransferTenant(tenant, houseID, lease, g.db)
+}
+
+func (g *gormRepository) GetLeaseById(id int) (*models.Lease, error) {
+	return models.GetLeaseById(id, g.db)
+}
+
+func (g *gormRepository) GetActiveLease(tenantID uint) (*models.Lease, error) {
+	return models.GetActiveLease(tenantID, g.db)
+}
+
+func (g *gormRepository) GetLeasesByHouse(houseID uint, status string) (*[]models.Lease, error) {
+	return models.GetLeasesByHouse(houseID, status, g.db)
+}
+
+func (g *gormRepository) GetLeasesByTenantEmail(email string) (*[]models.Lease, error) {
+	return models.GetLeasesByTenantEmail(email, g.db)
+}
+
+func (g *gormRepository) EndLease(lease *models.Lease, status string, endDate time.Time) (*models.Lease, error) {
+	return models.EndLease(lease, status, endDate, g.db)
+}
+
+func (g *gormRepository) SaveRefreshToken(userID uint, tokenHash string, expiresAt time.Time) (*models.RefreshToken, error) {
+	return models.SaveRefreshToken(userID, tokenHash, expiresAt, g.db)
+}
+
+func (g *gormRepository) RotateRefreshToken(tokenHash, newHash string, expiresAt time.Time) (*models.User, error) {
+	return models.RotateRefreshToken(tokenHash, newHash, expiresAt, g.db)
+}
+
+func (g *gormRepository) RevokeRefreshToken(userID uint, tokenHash string) error {
+	return models.RevokeRefreshToken(userID, tokenHash, g.db)
+}
+
+func (g *gormRepository) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	return models.RevokeAccessToken(jti, expiresAt, g.db)
+}
+
+func (g *gormRepository) RevokeAllSessions(userID uint) error {
+	return models.RevokeAllSessions(userID, g.db)
+}
+
+func (g *gormRepository) IsAccessTokenRevoked(jti string, userID uint, issuedAt int64) (bool, error) {
+	return models.IsAccessTokenRevoked(jti, userID, issuedAt, g.db)
+}
+
+func (g *gormRepository) SaveUserToken(userID uint, email, purpose, tokenHash string, expiresAt time.Time) (*models.UserToken, error) {
+	return models.SaveUserToken(userID, email, purpose, tokenHash, expiresAt, g.db)
+}
+
+func (g *gormRepository) ResetPassword(tokenHash, password string) error {
+	return models.ResetPassword(tokenHash, password, g.db)
+}
+
+func (g *gormRepository) VerifyEmail(tokenHash string) error {
+	return models.VerifyEmail(tokenHash, g.db)
+}
@@ -0,0 +1,1257 @@
+package repository
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/khelechy/rielzapi/api/apperr"
+	"github.com/khelechy/rielzapi/api/models"
+)
+
+// errDuplicateEmail mirrors the unique index on users.email
+var errDuplicateEmail = apperr.Conflict("email_taken", "A user with this email already exists")
+
+// memoryData is everything the in-memory repository holds. Rows are kept by value
+// so callers never share memory with the store.
+type memoryData struct {
+	houses     map[uint]models.House
+	houseMedia map[uint]models.HouseMedia
+	amenities  map[uint]models.Amenity
+	// houseAmenities holds the ids of the amenities of each house, by house id
+	houseAmenities map[uint][]uint
+	users          map[uint]models.User
+	tenants        map[uint]models.Tenant
+	leases         map[uint]models.Lease
+	agentLinks     map[uint]models.AgentLink
+	refreshTokens  map[uint]models.RefreshToken
+	revokedTokens  map[uint]models.RevokedToken
+	userTokens     map[uint]models.UserToken
+	// sequences holds the last primary key handed out for each table
+	sequences map[string]uint
+}
+
+// nextID hands out the next primary key of a table
+func (d *memoryData) nextID(table string) uint {
+	d.sequences[table]++
+	return d.sequences[table]
+}
+
+func (d *memoryData) clone() *memoryData {
+	return &memoryData{
+		houses:     cloneMap(d.houses),
+		houseMedia: cloneMap(d.houseMedia),
+		amenities:  cloneMap(d.amenities),
+		// the id slices are replaced, never changed in place, so sharing them is safe
+		houseAmenities: cloneMap(d.houseAmenities),
+		users:          cloneMap(d.users),
+		tenants:        cloneMap(d.tenants),
+		leases:         cloneMap(d.leases),
+		agentLinks:     cloneMap(d.agentLinks),
+		refreshTokens:  cloneMap(d.refreshTokens),
+		revokedTokens:  cloneMap(d.revokedTokens),
+		userTokens:     cloneMap(d.userTokens),
+		sequences:      cloneSequences(d.sequences),
+	}
+}
+
+func cloneSequences(sequences map[string]uint) map[string]uint {
+	clone := make(map[string]uint, len(sequences))
+	for table, id := range sequences {
+		clone[table] = id
+	}
+	return clone
+}
+
+func cloneMap[T any](rows map[uint]T) map[uint]T {
+	clone := make(map[uint]T, len(rows))
+	for id, row := range rows {
+		clone[id] = row
+	}
+	return clone
+}
+
+// sortedIDs returns the keys of rows in ascending order, the order rows come back from a table
+func sortedIDs[T any](rows map[uint]T) []uint {
+	ids := make([]uint, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// memoryRepository keeps data in maps guarded by one lock. Writes that touch
+// several rows run in transaction, which puts the data back when they fail.
+type memoryRepository struct {
+	mu   sync.RWMutex
+	data *memoryData
+}
+
+// NewMemoryStore returns a store that lives as long as the process, empty but
+// for the starter amenities catalog
+func NewMemoryStore() *Store {
+	repo := &memoryRepository{data: &memoryData{
+		houses:         map[uint]models.House{},
+		houseMedia:     map[uint]models.HouseMedia{},
+		amenities:      map[uint]models.Amenity{},
+		houseAmenities: map[uint][]uint{},
+		users:          map[uint]models.User{},
+		tenants:        map[uint]models.Tenant{},
+		leases:         map[uint]models.Lease{},
+		agentLinks:     map[uint]models.AgentLink{},
+		refreshTokens:  map[uint]models.RefreshToken{},
+		revokedTokens:  map[uint]models.RevokedToken{},
+		userTokens:     map[uint]models.UserToken{},
+		sequences:      map[string]uint{},
+	}}
+	for _, amenity := range models.StarterAmenities {
+		repo.SaveAmenity(&amenity)
+	}
+	return &Store{Houses: repo, Amenities: repo, Users: repo, Tenants: repo, Tokens: repo}
+}
+
+// read runs fn holding the read lock
+func (m *memoryRepository) read(fn func(d *memoryData) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return fn(m.data)
+}
+
+// transaction runs fn holding the write lock and discards its changes when it fails
+func (m *memoryRepository) transaction(fn func(d *memoryData) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := m.data.clone()
+	if err := fn(m.data); err != nil {
+		m.data = snapshot
+		return err
+	}
+	return nil
+}
+
+func newModel(id uint) gorm.Model {
+	now := time.Now()
+	return gorm.Model{ID: id, CreatedAt: now, UpdatedAt: now}
+}
+
+func (m *memoryRepository) GetHouses(filter models.HouseFilter) (*[]models.House, int, error) {
+	houses := []models.House{}
+	m.read(func(d *memoryData) error {
+		for _, id := range sortedIDs(d.houses) {
+			house := withDetails(d, d.houses[id])
+			switch {
+			case filter.HouseType != "" && house.HouseType != filter.HouseType,
+				filter.State != "" && house.State != filter.State,
+				filter.MinPrice > 0 && house.Price < filter.MinPrice,
+				filter.MaxPrice > 0 && house.Price > filter.MaxPrice,
+				filter.MinRooms > 0 && house.Rooms < filter.MinRooms,
+				filter.MinBathRooms > 0 && house.BathRooms < filter.MinBathRooms,
+				filter.Available && house.AvailableRooms <= 0,
+				!hasAmenities(house, filter.Amenities):
+				continue
+			}
+			houses = append(houses, house)
+		}
+		return nil
+	})
+	total := len(houses)
+
+	less := func(a, b models.House) bool {
+		switch filter.SortBy {
+		case "price":
+			if a.Price != b.Price {
+				return a.Price < b.Price
+			}
+		case "available_rooms":
+			if a.AvailableRooms != b.AvailableRooms {
+				return a.AvailableRooms < b.AvailableRooms
+			}
+		default:
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+		}
+		return a.ID < b.ID // id keeps pages stable on ties
+	}
+	sort.SliceStable(houses, func(i, j int) bool {
+		if filter.Descending {
+			return less(houses[j], houses[i])
+		}
+		return less(houses[i], houses[j])
+	})
+
+	if filter.Offset > 0 {
+		if filter.Offset >= len(houses) {
+			houses = houses[:0]
+		} else {
+			houses = houses[filter.Offset:]
+		}
+	}
+	if filter.Limit > 0 && len(houses) > filter.Limit {
+		houses = houses[:filter.Limit]
+	}
+	return &houses, total, nil
+}
+
+func (m *memoryRepository) GetHouseById(id int) (*models.House, error) {
+	var house *models.House
+	err := m.read(func(d *memoryData) error {
+		found, ok := d.houses[uint(id)]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		found = withDetails(d, found)
+		house = &found
+		return nil
+	})
+	return house, err
+}
+
+func (m *memoryRepository) findHouses(match func(house models.House) bool) *[]models.House {
+	houses := []models.House{}
+	m.read(func(d *memoryData) error {
+		for _, id := range sortedIDs(d.houses) {
+			if match(d.houses[id]) {
+				houses = append(houses, withDetails(d, d.houses[id]))
+			}
+		}
+		return nil
+	})
+	return &houses
+}
+
+func (m *memoryRepository) GetHousesByLandlord(landlordID uint) (*[]models.House, error) {
+	return m.findHouses(func(house models.House) bool { return house.UserID == landlordID }), nil
+}
+
+func (m *memoryRepository) GetHousesByState(state string) (*[]models.House, error) {
+	return m.findHouses(func(house models.House) bool { return house.State == state }), nil
+}
+
+func (m *memoryRepository) GetHousesNearby(lat, lng, radiusKm float64, limit int) (*[]models.NearbyHouse, error) {
+	nearby := []models.NearbyHouse{}
+	for _, house := range *m.findHouses(func(house models.House) bool { return house.Geocoded }) {
+		distance := models.DistanceKm(lat, lng, house.Latitude, house.Longitude)
+		if distance <= radiusKm {
+			nearby = append(nearby, models.NearbyHouse{House: house, DistanceKm: math.Round(distance*1000) / 1000})
+		}
+	}
+	sort.SliceStable(nearby, func(i, j int) bool {
+		return nearby[i].DistanceKm < nearby[j].DistanceKm
+	})
+	if limit > 0 && len(nearby) > limit {
+		nearby = nearby[:limit]
+	}
+	return &nearby, nil
+}
+
+func (m *memoryRepository) SaveHouse(house *models.House) (*models.House, error) {
+	err := m.transaction(func(d *memoryData) error {
+		amenities, err := findAmenities(d, house.Amenities)
+		if err != nil {
+			return err
+		}
+		house.Model = newModel(d.nextID("houses"))
+		house.Version = 1
+		house.AvailableRooms = house.Rooms // no leases yet
+		house.Amenities = setHouseAmenities(d, house.ID, amenities)
+		d.houses[house.ID] = *house
+		return nil
+	})
+	if err != nil {
+		return &models.House{}, err
+	}
+	return house, nil
+}
+
+func (m *memoryRepository) UpdateHouse(id int, house *models.House) (*models.House, error) {
+	err := m.transaction(func(d *memoryData) error {
+		current, ok := d.houses[uint(id)]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		if current.Version != house.Version {
+			return models.ErrHouseModified
+		}
+		if house.Rooms < activeLeases(d, current.ID) {
+			return models.ErrRoomsOccupied
+		}
+		amenities, err := findAmenities(d, house.Amenities)
+		if err != nil {
+			return err
+		}
+		house.Amenities = setHouseAmenities(d, current.ID, amenities)
+		// every editable field is written, zero values included
+		current.HouseType = house.HouseType
+		current.State = house.State
+		current.Description = house.Description
+		current.Location = house.Location
+		current.Rooms = house.Rooms
+		current.BathRooms = house.BathRooms
+		current.Price = house.Price
+		current.LongLat = house.LongLat
+		current.Latitude = house.Latitude
+		current.Longitude = house.Longitude
+		current.Geocoded = house.Geocoded
+		current.Version++
+		current.UpdatedAt = time.Now()
+		d.houses[current.ID] = current
+		// available rooms follow the leases, whatever the update says
+		recomputeAvailableRooms(d, current.ID)
+		house.AvailableRooms = d.houses[current.ID].AvailableRooms
+		house.Version = d.houses[current.ID].Version
+		return nil
+	})
+	if err != nil {
+		return &models.House{}, err
+	}
+	return house, nil
+}
+
+func (m *memoryRepository) DeleteHouse(id int, version uint) error {
+	return m.transaction(func(d *memoryData) error {
+		current, ok := d.houses[uint(id)]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		if current.Version != version {
+			return models.ErrHouseModified
+		}
+		delete(d.houses, uint(id))
+		return nil
+	})
+}
+
+func (m *memoryRepository) GetStateStats() (*[]models.StateStats, error) {
+	stats := []models.StateStats{}
+	m.read(func(d *memoryData) error {
+		byState := map[string]*models.StateStats{}
+		for _, house := range d.houses {
+			state, ok := byState[house.State]
+			if !ok {
+				state = &models.StateStats{State: house.State}
+				byState[house.State] = state
+			}
+			state.Houses++
+			state.Rooms += house.Rooms
+			state.AvailableRooms += house.AvailableRooms
+		}
+		for _, lease := range d.leases {
+			if house, ok := d.houses[lease.HouseID]; ok && lease.Status == models.LeaseActive {
+				byState[house.State].Tenants++
+			}
+		}
+		for _, state := range byState {
+			stats = append(stats, *state)
+		}
+		return nil
+	})
+	sort.Slice(stats, func(i, j int) bool { return stats[i].State < stats[j].State })
+	return &stats, nil
+}
+
+// withDetails attaches the media of a house, in the order they are shown, and its amenities
+func withDetails(d *memoryData, house models.House) models.House {
+	house.Media = houseMedia(d, house.ID)
+	house.Amenities = []models.Amenity{}
+	for _, id := range d.houseAmenities[house.ID] {
+		house.Amenities = append(house.Amenities, d.amenities[id])
+	}
+	models.SortAmenities(house.Amenities)
+	return house
+}
+
+// findAmenities looks up the amenities a client named by slug
+func findAmenities(d *memoryData, named []models.Amenity) ([]models.Amenity, error) {
+	amenities := []models.Amenity{}
+	for _, slug := range models.AmenitySlugs(named) {
+		amenity, ok := amenityBySlug(d, slug)
+		if !ok {
+			return nil, models.UnknownAmenity(slug)
+		}
+		amenities = append(amenities, amenity)
+	}
+	models.SortAmenities(amenities)
+	return amenities, nil
+}
+
+func amenityBySlug(d *memoryData, slug string) (models.Amenity, bool) {
+	for _, amenity := range d.amenities {
+		if amenity.Slug == slug {
+			return amenity, true
+		}
+	}
+	return models.Amenity{}, false
+}
+
+// setHouseAmenities links a house to exactly the amenities given and returns them
+func setHouseAmenities(d *memoryData, houseID uint, amenities []models.Amenity) []models.Amenity {
+	ids := make([]uint, 0, len(amenities))
+	for _, amenity := range amenities {
+		ids = append(ids, amenity.ID)
+	}
+	d.houseAmenities[houseID] = ids
+	return amenities
+}
+
+// hasAmenities reports whether a house with its details attached has every amenity named by slug
+func hasAmenities(house models.House, slugs []string) bool {
+	for _, slug := range slugs {
+		found := false
+		for _, amenity := range house.Amenities {
+			found = found || amenity.Slug == slug
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// touchHousesWith moves on the version of the houses listed with an amenity
+func touchHousesWith(d *memoryData, amenityID uint) {
+	for houseID, ids := range d.houseAmenities {
+		for _, id := range ids {
+			if id == amenityID {
+				touchHouse(d, houseID)
+				break
+			}
+		}
+	}
+}
+
+func (m *memoryRepository) GetAmenities() (*[]models.Amenity, error) {
+	amenities := []models.Amenity{}
+	m.read(func(d *memoryData) error {
+		for _, id := range sortedIDs(d.amenities) {
+			amenities = append(amenities, d.amenities[id])
+		}
+		return nil
+	})
+	sort.SliceStable(amenities, func(i, j int) bool {
+		if amenities[i].Category != amenities[j].Category {
+			return amenities[i].Category < amenities[j].Category
+		}
+		return amenities[i].Name < amenities[j].Name
+	})
+	return &amenities, nil
+}
+
+func (m *memoryRepository) GetAmenityById(id int) (*models.Amenity, error) {
+	var amenity *models.Amenity
+	err := m.read(func(d *memoryData) error {
+		found, ok := d.amenities[uint(id)]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		amenity = &found
+		return nil
+	})
+	return amenity, err
+}
+
+func (m *memoryRepository) SaveAmenity(amenity *models.Amenity) (*models.Amenity, error) {
+	err := m.transaction(func(d *memoryData) error {
+		if _, taken := amenityBySlug(d, amenity.Slug); taken {
+			return models.ErrAmenityExists
+		}
+		amenity.Model = newModel(d.nextID("amenities"))
+		d.amenities[amenity.ID] = *amenity
+		return nil
+	})
+	if err != nil {
+		return &models.Amenity{}, err
+	}
+	return amenity, nil
+}
+
+func (m *memoryRepository) UpdateAmenity(id int, amenity *models.Amenity) (*models.Amenity, error) {
+	var updated models.Amenity
+	err := m.transaction(func(d *memoryData) error {
+		current, ok := d.amenities[uint(id)]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		if other, taken := amenityBySlug(d, amenity.Slug); taken && other.ID != current.ID {
+			return models.ErrAmenityExists
+		}
+		current.Slug = amenity.Slug
+		current.Name = amenity.Name
+		current.Category = amenity.Category
+		current.UpdatedAt = time.Now()
+		d.amenities[current.ID] = current
+		touchHousesWith(d, current.ID)
+		updated = current
+		return nil
+	})
+	if err != nil {
+		return &models.Amenity{}, err
+	}
+	return &updated, nil
+}
+
+func (m *memoryRepository) DeleteAmenity(id int) error {
+	return m.transaction(func(d *memoryData) error {
+		touchHousesWith(d, uint(id))
+		for houseID, ids := range d.houseAmenities {
+			kept := []uint{}
+			for _, amenityID := range ids {
+				if amenityID != uint(id) {
+					kept = append(kept, amenityID)
+				}
+			}
+			d.houseAmenities[houseID] = kept
+		}
+		delete(d.amenities, uint(id))
+		return nil
+	})
+}
+
+func houseMedia(d *memoryData, houseID uint) []models.HouseMedia {
+	media := []models.HouseMedia{}
+	for _, id := range sortedIDs(d.houseMedia) {
+		if d.houseMedia[id].HouseID == houseID {
+			media = append(media, d.houseMedia[id])
+		}
+	}
+	sort.SliceStable(media, func(i, j int) bool { return media[i].Position < media[j].Position })
+	return media
+}
+
+// touchHouse moves the version of a house on, as the media clients read with it changed
+func touchHouse(d *memoryData, houseID uint) {
+	house := d.houses[houseID]
+	house.Version++
+	d.houses[houseID] = house
+}
+
+func (m *memoryRepository) SaveHouseMedia(media *models.HouseMedia) (*models.HouseMedia, error) {
+	err := m.transaction(func(d *memoryData) error {
+		if _, ok := d.houses[media.HouseID]; !ok {
+			return gorm.ErrRecordNotFound
+		}
+		current := houseMedia(d, media.HouseID)
+		media.Position = 0
+		if len(current) > 0 {
+			media.Position = current[len(current)-1].Position + 1
+		}
+		media.Cover = media.Kind == models.MediaPhoto
+		for _, other := range current {
+			if other.Cover {
+				media.Cover = false
+			}
+		}
+		media.Model = newModel(d.nextID("house_media"))
+		d.houseMedia[media.ID] = *media
+		touchHouse(d, media.HouseID)
+		return nil
+	})
+	if err != nil {
+		return &models.HouseMedia{}, err
+	}
+	return media, nil
+}
+
+func (m *memoryRepository) GetHouseMediaById(houseID uint, id int) (*models.HouseMedia, error) {
+	var media *models.HouseMedia
+	err := m.read(func(d *memoryData) error {
+		found, ok := d.houseMedia[uint(id)]
+		if !ok || found.HouseID != houseID {
+			return gorm.ErrRecordNotFound
+		}
+		media = &found
+		return nil
+	})
+	return media, err
+}
+
+func (m *memoryRepository) ReorderHouseMedia(houseID uint, ids []uint) (*[]models.HouseMedia, error) {
+	var media []models.HouseMedia
+	err := m.transaction(func(d *memoryData) error {
+		if _, ok := d.houses[houseID]; !ok {
+			return gorm.ErrRecordNotFound
+		}
+		if err := models.CheckMediaOrder(houseMedia(d, houseID), ids); err != nil {
+			return err
+		}
+		for position, id := range ids {
+			stored := d.houseMedia[id]
+			stored.Position = position
+			d.houseMedia[id] = stored
+		}
+		touchHouse(d, houseID)
+		media = houseMedia(d, houseID)
+		return nil
+	})
+	if err != nil {
+		return &[]models.HouseMedia{}, err
+	}
+	return &media, nil
+}
+
+func (m *memoryRepository) SetHouseCover(media *models.HouseMedia) (*models.HouseMedia, error) {
+	if media.Kind != models.MediaPhoto {
+		return &models.HouseMedia{}, models.ErrCoverNotPhoto
+	}
+	m.transaction(func(d *memoryData) error {
+		for _, other := range houseMedia(d, media.HouseID) {
+			other.Cover = other.ID == media.ID
+			d.houseMedia[other.ID] = other
+		}
+		touchHouse(d, media.HouseID)
+		return nil
+	})
+	media.Cover = true
+	return media, nil
+}
+
+func (m *memoryRepository) DeleteHouseMedia(media *models.HouseMedia) error {
+	return m.transaction(func(d *memoryData) error {
+		delete(d.houseMedia, media.ID)
+		if media.Cover {
+			for _, next := range houseMedia(d, media.HouseID) {
+				if next.Kind == models.MediaPhoto {
+					next.Cover = true
+					d.houseMedia[next.ID] = next
+					break
+				}
+			}
+		}
+		touchHouse(d, media.HouseID)
+		return nil
+	})
+}
+
+func setString(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
+
+func (m *memoryRepository) GetUsers() (*[]models.User, error) {
+	users := []models.User{}
+	m.read(func(d *memoryData) error {
+		for _, id := range sortedIDs(d.users) {
+			users = append(users, d.users[id])
+		}
+		return nil
+	})
+	return &users, nil
+}
+
+func (m *memoryRepository) GetUserById(id int) (*models.User, error) {
+	var user *models.User
+	err := m.read(func(d *memoryData) error {
+		found, ok := d.users[uint(id)]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		user = &found
+		return nil
+	})
+	return user, err
+}
+
+func (m *memoryRepository) GetUserByEmail(email string) (*models.User, error) {
+	var user *models.User
+	err := m.read(func(d *memoryData) error {
+		for _, id := range sortedIDs(d.users) {
+			if d.users[id].Email == email {
+				found := d.users[id]
+				user = &found
+				return nil
+			}
+		}
+		return gorm.ErrRecordNotFound
+	})
+	return user, err
+}
+
+func (m *memoryRepository) SaveUser(user *models.User) (*models.User, error) {
+	err := m.transaction(func(d *memoryData) error {
+		for _, existing := range d.users {
+			if existing.Email == user.Email {
+				return errDuplicateEmail
+			}
+		}
+		if err := user.BeforeSave(); err != nil {
+			return err
+		}
+		if user.Role == "" {
+			user.Role = models.RoleLandlord
+		}
+		user.Model = newModel(d.nextID("users"))
+		d.users[user.ID] = *user
+		return nil
+	})
+	if err != nil {
+		return &models.User{}, err
+	}
+	return user, nil
+}
+
+func (m *memoryRepository) UpdateUser(id int, user *models.User) (*models.User, error) {
+	err := m.transaction(func(d *memoryData) error {
+		current, ok := d.users[uint(id)]
+		if !ok {
+			return nil
+		}
+		if user.Email != current.Email {
+			for _, existing := range d.users {
+				if existing.Email == user.Email {
+					return errDuplicateEmail
+				}
+			}
+			// a new address has to be verified again, and links mailed to the old one must not verify it
+			current.EmailVerifiedAt = nil
+			expireUserTokens(d, current.ID, models.EmailVerificationToken)
+		}
+		current.FirstName = user.FirstName
+		current.LastName = user.LastName
+		current.PhoneNumber = user.PhoneNumber
+		current.Email = user.Email
+		current.UpdatedAt = time.Now()
+		d.users[current.ID] = current
+		return nil
+	})
+	if err != nil {
+		return &models.User{}, err
+	}
+	return user, nil
+}
+
+// updateUser applies change to the user with the given id, if there is one
+func (m *memoryRepository) updateUser(id uint, change func(user *models.User)) error {
+	return m.transaction(func(d *memoryData) error {
+		if user, ok := d.users[id]; ok {
+			change(&user)
+			d.users[id] = user
+		}
+		return nil
+	})
+}
+
+func (m *memoryRepository) SetRole(id int, role string) error {
+	return m.updateUser(uint(id), func(user *models.User) { user.Role = role })
+}
+
+func (m *memoryRepository) SetSuspended(id int, suspended bool) error {
+	return m.updateUser(uint(id), func(user *models.User) { user.Suspended = suspended })
+}
+
+func (m *memoryRepository) LinkAgent(agentID, landlordID uint) (*models.AgentLink, error) {
+	link := &models.AgentLink{}
+	m.transaction(func(d *memoryData) error {
+		for _, existing := range d.agentLinks {
+			if existing.AgentID == agentID && existing.LandlordID == landlordID {
+				*link = existing
+				return nil
+			}
+		}
+		*link = models.AgentLink{Model: newModel(d.nextID("agent_links")), AgentID: agentID, LandlordID: landlordID}
+		d.agentLinks[link.ID] = *link
+		return nil
+	})
+	return link, nil
+}
+
+func (m *memoryRepository) UnlinkAgent(agentID, landlordID uint) error {
+	return m.transaction(func(d *memoryData) error {
+		for id, link := range d.agentLinks {
+			if link.AgentID == agentID && link.LandlordID == landlordID {
+				delete(d.agentLinks, id)
+			}
+		}
+		return nil
+	})
+}
+
+func (m *memoryRepository) GetAgentsByLandlord(landlordID uint) (*[]models.User, error) {
+	users := []models.User{}
+	m.read(func(d *memoryData) error {
+		linked := map[uint]bool{}
+		for _, link := range d.agentLinks {
+			if link.LandlordID == landlordID {
+				linked[link.AgentID] = true
+			}
+		}
+		for _, id := range sortedIDs(d.users) {
+			if linked[id] {
+				users = append(users, d.users[id])
+			}
+		}
+		return nil
+	})
+	return &users, nil
+}
+
+func (m *memoryRepository) GetLinkedLandlordIDs(agentID uint) ([]uint, error) {
+	ids := []uint{}
+	m.read(func(d *memoryData) error {
+		for _, id := range sortedIDs(d.agentLinks) {
+			if d.agentLinks[id].AgentID == agentID {
+				ids = append(ids, d.agentLinks[id].LandlordID)
+			}
+		}
+		return nil
+	})
+	return ids, nil
+}
+
+func (m *memoryRepository) GetTenantById(id int) (*models.Tenant, error) {
+	var tenant *models.Tenant
+	err := m.read(func(d *memoryData) error {
+		found, ok := d.tenants[uint(id)]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		tenant = &found
+		return nil
+	})
+	return tenant, err
+}
+
+func (m *memoryRepository) AddTenant(tenant *models.Tenant, lease *models.Lease) (*models.Tenant, *models.Lease, error) {
+	err := m.transaction(func(d *memoryData) error {
+		if _, ok := d.houses[uint(tenant.HouseId)]; !ok {
+			return gorm.ErrRecordNotFound
+		}
+		tenant.Model = newModel(d.nextID("tenants"))
+		d.tenants[tenant.ID] = *tenant
+		return startLease(d, tenant, lease)
+	})
+	if err != nil {
+		return &models.Tenant{}, &models.Lease{}, err
+	}
+	return tenant, lease, nil
+}
+
+// startLease opens a lease for the tenant in its house when a room is free
+func startLease(d *memoryData, tenant *models.Tenant, lease *models.Lease) error {
+	house, ok := d.houses[uint(tenant.HouseId)]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	if activeLeases(d, house.ID) >= house.Rooms {
+		return models.ErrNoAvailableRooms
+	}
+
+	lease.Model = newModel(d.nextID("leases"))
+	lease.TenantID = tenant.ID
+	lease.HouseID = house.ID
+	lease.Status = models.LeaseActive
+	d.leases[lease.ID] = *lease
+	recomputeAvailableRooms(d, house.ID)
+	return nil
+}
+
+// endLease closes an active lease and frees its room
+func endLease(d *memoryData, lease *models.Lease, status string, endDate time.Time) error {
+	if lease.Status != models.LeaseActive {
+		return models.ErrLeaseNotActive
+	}
+	if endDate.Before(lease.StartDate) {
+		return models.ErrLeaseEndsBeforeStart
+	}
+	lease.Status = status
+	lease.EndDate = &endDate
+	if stored, ok := d.leases[lease.ID]; ok {
+		stored.Status = status
+		stored.EndDate = &endDate
+		stored.UpdatedAt = time.Now()
+		d.leases[lease.ID] = stored
+	}
+	recomputeAvailableRooms(d, lease.HouseID)
+	return nil
+}
+
+func activeLeases(d *memoryData, houseID uint) int {
+	count := 0
+	for _, lease := range d.leases {
+		if lease.HouseID == houseID && lease.Status == models.LeaseActive {
+			count++
+		}
+	}
+	return count
+}
+
+func activeLease(d *memoryData, tenantID uint) (models.Lease, bool) {
+	for _, id := range sortedIDs(d.leases) {
+		if lease := d.leases[id]; lease.TenantID == tenantID && lease.Status == models.LeaseActive {
+			return lease, true
+		}
+	}
+	return models.Lease{}, false
+}
+
+// recomputeAvailableRooms sets the available rooms of a house from its active leases
+func recomputeAvailableRooms(d *memoryData, houseID uint) {
+	house, ok := d.houses[houseID]
+	if !ok {
+		return
+	}
+	house.AvailableRooms = house.Rooms - activeLeases(d, houseID)
+	if house.AvailableRooms < 0 {
+		house.AvailableRooms = 0
+	}
+	house.Version++
+	d.houses[houseID] = house
+}
+
+func (m *memoryRepository) UpdateTenant(id int, tenant *models.Tenant) (*models.Tenant, error) {
+	m.transaction(func(d *memoryData) error {
+		current, ok := d.tenants[uint(id)]
+		if !ok {
+			return nil
+		}
+		setString(&current.FirstName, tenant.FirstName)
+		setString(&current.LastName, tenant.LastName)
+		setString(&current.PhoneNumber, tenant.PhoneNumber)
+		setString(&current.Email, tenant.Email)
+		current.UpdatedAt = time.Now()
+		d.tenants[current.ID] = current
+		return nil
+	})
+	return tenant, nil
+}
+
+func (m *memoryRepository) DeleteTenant(tenant *models.Tenant) error {
+	return m.transaction(func(d *memoryData) error {
+		if lease, ok := activeLease(d, tenant.ID); ok {
+			if err := endLease(d, &lease, models.LeaseTerminated, time.Now()); err != nil {
+				return err
+			}
+		}
+		delete(d.tenants, tenant.ID)
+		return nil
+	})
+}
+
+func (m *memoryRepository) TransferTenant(tenant *models.Tenant, houseID int, lease *models.Lease) (*models.Lease, error) {
+	err := m.transaction(func(d *memoryData) error {
+		current, ok := activeLease(d, tenant.ID)
+		if !ok {
+			return models.ErrLeaseNotActive
+		}
+		if err := endLease(d, &current, models.LeaseEnded, lease.StartDate); err != nil {
+			return err
+		}
+
+		stored, ok := d.tenants[tenant.ID]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		stored.HouseId = houseID
+		d.tenants[tenant.ID] = stored
+		tenant.HouseId = houseID
+		return startLease(d, tenant, lease)
+	})
+	if err != nil {
+		return &models.Lease{}, err
+	}
+	return lease, nil
+}
+
+// withTenant returns the lease with its tenant attached, as Preload("Tenant") does
+func withTenant(d *memoryData, lease models.Lease) models.Lease {
+	if tenant, ok := d.tenants[lease.TenantID]; ok {
+		lease.Tenant = &tenant
+	}
+	return lease
+}
+
+func (m *memoryRepository) GetLeaseById(id int) (*models.Lease, error) {
+	var lease *models.Lease
+	err := m.read(func(d *memoryData) error {
+		found, ok := d.leases[uint(id)]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		found = withTenant(d, found)
+		lease = &found
+		return nil
+	})
+	return lease, err
+}
+
+func (m *memoryRepository) GetActiveLease(tenantID uint) (*models.Lease, error) {
+	var lease *models.Lease
+	err := m.read(func(d *memoryData) error {
+		found, ok := activeLease(d, tenantID)
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		lease = &found
+		return nil
+	})
+	return lease, err
+}
+
+// newestFirst orders leases by start date, latest first
+func newestFirst(leases []models.Lease) {
+	sort.SliceStable(leases, func(i, j int) bool {
+		return leases[i].StartDate.After(leases[j].StartDate)
+	})
+}
+
+func (m *memoryRepository) GetLeasesByHouse(houseID uint, status string) (*[]models.Lease, error) {
+	leases := []models.Lease{}
+	m.read(func(d *memoryData) error {
+		for _, id := range sortedIDs(d.leases) {
+			lease := d.leases[id]
+			if lease.HouseID != houseID ||
+				(status == "current" && lease.Status != models.LeaseActive) ||
+				(status == "past" && lease.Status == models.LeaseActive) {
+				continue
+			}
+			leases = append(leases, withTenant(d, lease))
+		}
+		return nil
+	})
+	newestFirst(leases)
+	return &leases, nil
+}
+
+func (m *memoryRepository) GetLeasesByTenantEmail(email string) (*[]models.Lease, error) {
+	leases := []models.Lease{}
+	m.read(func(d *memoryData) error {
+		for _, id := range sortedIDs(d.leases) {
+			lease := d.leases[id]
+			if tenant, ok := d.tenants[lease.TenantID]; !ok || tenant.Email != email {
+				continue
+			}
+			if house, ok := d.houses[lease.HouseID]; ok {
+				house = withDetails(d, house)
+				lease.House = &house
+			}
+			leases = append(leases, lease)
+		}
+		return nil
+	})
+	newestFirst(leases)
+	return &leases, nil
+}
+
+func (m *memoryRepository) EndLease(lease *models.Lease, status string, endDate time.Time) (*models.Lease, error) {
+	if status != models.LeaseEnded && status != models.LeaseTerminated {
+		return &models.Lease{}, errors.New("Status of lease must be ended or terminated")
+	}
+	err := m.transaction(func(d *memoryData) error {
+		return endLease(d, lease, status, endDate)
+	})
+	if err != nil {
+		return &models.Lease{}, err
+	}
+	return lease, nil
+}
+
+func (m *memoryRepository) SaveRefreshToken(userID uint, tokenHash string, expiresAt time.Time) (*models.RefreshToken, error) {
+	token := &models.RefreshToken{}
+	m.transaction(func(d *memoryData) error {
+		*token = saveRefreshToken(d, userID, tokenHash, expiresAt)
+		return nil
+	})
+	return token, nil
+}
+
+func saveRefreshToken(d *memoryData, userID uint, tokenHash string, expiresAt time.Time) models.RefreshToken {
+	token := models.RefreshToken{Model: newModel(d.nextID("refresh_tokens")), UserID: userID, TokenHash: tokenHash, ExpiresAt: expiresAt}
+	d.refreshTokens[token.ID] = token
+	return token
+}
+
+func findRefreshToken(d *memoryData, tokenHash string) (models.RefreshToken, bool) {
+	for _, token := range d.refreshTokens {
+		if token.TokenHash == tokenHash {
+			return token, true
+		}
+	}
+	return models.RefreshToken{}, false
+}
+
+func (m *memoryRepository) RotateRefreshToken(tokenHash, newHash string, expiresAt time.Time) (*models.User, error) {
+	user := &models.User{}
+	var ownerID uint
+	err := m.transaction(func(d *memoryData) error {
+		current, ok := findRefreshToken(d, tokenHash)
+		if !ok {
+			return models.ErrInvalidRefreshToken
+		}
+		ownerID = current.UserID
+		if current.RevokedAt != nil {
+			if current.Rotated {
+				return models.ErrRefreshTokenReused
+			}
+			return models.ErrInvalidRefreshToken
+		}
+		if time.Now().After(current.ExpiresAt) {
+			return models.ErrInvalidRefreshToken
+		}
+		owner, ok := d.users[current.UserID]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		*user = owner
+
+		now := time.Now()
+		current.RevokedAt = &now
+		current.Rotated = true
+		d.refreshTokens[current.ID] = current
+		saveRefreshToken(d, current.UserID, newHash, expiresAt)
+		return nil
+	})
+	if err == models.ErrRefreshTokenReused {
+		if revokeErr := m.RevokeAllSessions(ownerID); revokeErr != nil {
+			return nil, revokeErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (m *memoryRepository) RevokeRefreshToken(userID uint, tokenHash string) error {
+	return m.transaction(func(d *memoryData) error {
+		if token, ok := findRefreshToken(d, tokenHash); ok && token.UserID == userID && token.RevokedAt == nil {
+			now := time.Now()
+			token.RevokedAt = &now
+			d.refreshTokens[token.ID] = token
+		}
+		return nil
+	})
+}
+
+func (m *memoryRepository) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	return m.transaction(func(d *memoryData) error {
+		for _, revoked := range d.revokedTokens {
+			if revoked.JTI == jti {
+				return nil
+			}
+		}
+		id := d.nextID("revoked_tokens")
+		d.revokedTokens[id] = models.RevokedToken{ID: id, JTI: jti, ExpiresAt: expiresAt}
+		return nil
+	})
+}
+
+func (m *memoryRepository) RevokeAllSessions(userID uint) error {
+	return m.transaction(func(d *memoryData) error {
+		revokeAllSessions(d, userID)
+		return nil
+	})
+}
+
+func revokeAllSessions(d *memoryData, userID uint) {
+	now := time.Now()
+	for id, token := range d.refreshTokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			d.refreshTokens[id] = token
+		}
+	}
+	if user, ok := d.users[userID]; ok {
+		user.TokensValidAfter = &now
+		d.users[userID] = user
+	}
+}
+
+func (m *memoryRepository) IsAccessTokenRevoked(jti string, userID uint, issuedAt int64) (bool, error) {
+	revoked := false
+	m.read(func(d *memoryData) error {
+		for _, token := range d.revokedTokens {
+			if token.JTI == jti {
+				revoked = true
+				return nil
+			}
+		}
+		user, ok := d.users[userID]
+		if !ok {
+			revoked = true // the account is gone
+			return nil
+		}
+		revoked = user.TokensValidAfter != nil && issuedAt < user.TokensValidAfter.Unix()
+		return nil
+	})
+	return revoked, nil
+}
+
+func (m *memoryRepository) SaveUserToken(userID uint, email, purpose, tokenHash string, expiresAt time.Time) (*models.UserToken, error) {
+	token := &models.UserToken{}
+	m.transaction(func(d *memoryData) error {
+		expireUserTokens(d, userID, purpose)
+		*token = models.UserToken{Model: newModel(d.nextID("user_tokens")), UserID: userID, Email: email, Purpose: purpose, TokenHash: tokenHash, ExpiresAt: expiresAt}
+		d.userTokens[token.ID] = *token
+		return nil
+	})
+	return token, nil
+}
+
+// expireUserTokens marks the unused tokens of a user for a purpose as used
+func expireUserTokens(d *memoryData, userID uint, purpose string) {
+	now := time.Now()
+	for id, earlier := range d.userTokens {
+		if earlier.UserID == userID && earlier.Purpose == purpose && earlier.UsedAt == nil {
+			earlier.UsedAt = &now
+			d.userTokens[id] = earlier
+		}
+	}
+}
+
+// consumeUserToken marks a valid token as used and returns it
+func consumeUserToken(d *memoryData, purpose, tokenHash string) (models.UserToken, error) {
+	for id, token := range d.userTokens {
+		if token.TokenHash != tokenHash || token.Purpose != purpose {
+			continue
+		}
+		if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+			break
+		}
+		now := time.Now()
+		token.UsedAt = &now
+		d.userTokens[id] = token
+		return token, nil
+	}
+	return models.UserToken{}, models.ErrInvalidUserToken
+}
+
+func (m *memoryRepository) ResetPassword(tokenHash, password string) error {
+	hashed, err := models.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return m.transaction(func(d *memoryData) error {
+		token, err := consumeUserToken(d, models.PasswordResetToken, tokenHash)
+		if err != nil {
+			return err
+		}
+		if user, ok := d.users[token.UserID]; ok {
+			user.Password = hashed
+			d.users[user.ID] = user
+		}
+		revokeAllSessions(d, token.UserID)
+		return nil
+	})
+}
+
+func (m *memoryRepository) VerifyEmail(tokenHash string) error {
+	return m.transaction(func(d *memoryData) error {
+		token, err := consumeUserToken(d, models.EmailVerificationToken, tokenHash)
+		if err != nil {
+			return err
+		}
+		user, ok := d.users[token.UserID]
+		if !ok || user.Email != token.Email { // mailed to an address the user no longer has
+			return models.ErrInvalidUserToken
+		}
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		d.users[user.ID] = user
+		return nil
+	})
+}
@@ -0,0 +1,184 @@
+// Package repository hides where the API keeps its data behind one interface per
+// aggregate. Postgres and SQLite are served by the same gorm backed implementation;
+// the in-memory one needs no setup at all and suits tests and local runs.
+//
+// Lookups of missing records fail with gorm.ErrRecordNotFound whatever the backend,
+// so callers can keep using gorm.IsRecordNotFoundError.
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"go.uber.org/zap"
+
+	"github.com/khelechy/rielzapi/api/logging"
+	"github.com/khelechy/rielzapi/api/migrations"
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/config"
+)
+
+// HouseRepository stores house listings and the media uploaded for them
+type HouseRepository interface {
+	GetHouses(filter models.HouseFilter) (*[]models.House, int, error)
+	GetHouseById(id int) (*models.House, error)
+	GetHousesByLandlord(landlordID uint) (*[]models.House, error)
+	GetHousesByState(state string) (*[]models.House, error)
+	GetHousesNearby(lat, lng, radiusKm float64, limit int) (*[]models.NearbyHouse, error)
+	SaveHouse(house *models.House) (*models.House, error)
+	UpdateHouse(id int, house *models.House) (*models.House, error)
+	DeleteHouse(id int, version uint) error
+	GetStateStats() (*[]models.StateStats, error)
+	SaveHouseMedia(media *models.HouseMedia) (*models.HouseMedia, error)
+	GetHouseMediaById(houseID uint, id int) (*models.HouseMedia, error)
+	ReorderHouseMedia(houseID uint, ids []uint) (*[]models.HouseMedia, error)
+	SetHouseCover(media *models.HouseMedia) (*models.HouseMedia, error)
+	DeleteHouseMedia(media *models.HouseMedia) error
+}
+
+// AmenityRepository stores the catalog of amenities houses are listed with
+type AmenityRepository interface {
+	GetAmenities() (*[]models.Amenity, error)
+	GetAmenityById(id int) (*models.Amenity, error)
+	SaveAmenity(amenity *models.Amenity) (*models.Amenity, error)
+	UpdateAmenity(id int, amenity *models.Amenity) (*models.Amenity, error)
+	DeleteAmenity(id int) error
+}
+
+// UserRepository stores user accounts and the links between agents and landlords
+type UserRepository interface {
+	GetUsers() (*[]models.User, error)
+	GetUserById(id int) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, error)
+	SaveUser(user *models.User) (*models.User, error)
+	UpdateUser(id int, user *models.User) (*models.User, error)
+	SetRole(id int, role string) error
+	SetSuspended(id int, suspended bool) error
+	LinkAgent(agentID, landlordID uint) (*models.AgentLink, error)
+	UnlinkAgent(agentID, landlordID uint) error
+	GetAgentsByLandlord(landlordID uint) (*[]models.User, error)
+	GetLinkedLandlordIDs(agentID uint) ([]uint, error)
+}
+
+// TenantRepository stores tenants and the leases that give them a room
+type TenantRepository interface {
+	GetTenantById(id int) (*models.Tenant, error)
+	AddTenant(tenant *models.Tenant, lease *models.Lease) (*models.Tenant, *models.Lease, error)
+	UpdateTenant(id int, tenant *models.Tenant) (*models.Tenant, error)
+	DeleteTenant(tenant *models.Tenant) error
+	TransferTenant(tenant *models.Tenant, houseID int, lease *models.Lease) (*models.Lease, error)
+	GetLeaseById(id int) (*models.Lease, error)
+	GetActiveLease(tenantID uint) (*models.Lease, error)
+	GetLeasesByHouse(houseID uint, status string) (*[]models.Lease, error)
+	GetLeasesByTenantEmail(email string) (*[]models.Lease, error)
+	EndLease(lease *models.Lease, status string, endDate time.Time) (*models.Lease, error)
+}
+
+// TokenRepository stores sessions, revoked access tokens and the single use tokens mailed to users
+type TokenRepository interface {
+	SaveRefreshToken(userID uint, tokenHash string, expiresAt time.Time) (*models.RefreshToken, error)
+	RotateRefreshToken(tokenHash, newHash string, expiresAt time.Time) (*models.User, error)
+	RevokeRefreshToken(userID uint, tokenHash string) error
+	RevokeAccessToken(jti string, expiresAt time.Time) error
+	RevokeAllSessions(userID uint) error
+	IsAccessTokenRevoked(jti string, userID uint, issuedAt int64) (bool, error)
+	SaveUserToken(userID uint, email, purpose, tokenHash string, expiresAt time.Time) (*models.UserToken, error)
+	ResetPassword(tokenHash, password string) error
+	VerifyEmail(tokenHash string) error
+}
+
+// Store is a storage backend and the repositories it serves
+type Store struct {
+	Houses    HouseRepository
+	Amenities AmenityRepository
+	Users     UserRepository
+	Tenants   TenantRepository
+	Tokens    TokenRepository
+
+	// DB is the database behind the repositories, nil for in-memory storage
+	DB *gorm.DB
+}
+
+// Open opens the storage backend named by the driver: postgres, sqlite, which
+// keeps the database in the file named by the database name, or memory
+func Open(cfg config.Database) (*Store, error) {
+	switch cfg.Driver {
+	case "", "postgres":
+		return NewPostgresStore(cfg)
+	case "sqlite":
+		if cfg.Name == "" {
+			cfg.Name = "rielzapi.db"
+		}
+		return NewSQLiteStore(cfg.Name)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q, expected postgres, sqlite or memory", cfg.Driver)
+	}
+}
+
+// Migrate applies pending schema migrations and repairs rows saved by older versions
+func (s *Store) Migrate() error {
+	if s.DB == nil {
+		return nil
+	}
+	migrator, err := migrations.New(s.DB)
+	if err != nil {
+		return err
+	}
+	if _, err = migrator.Up(); err != nil {
+		return err
+	}
+	if err := models.BackfillCoordinates(s.DB); err != nil {
+		return fmt.Errorf("cannot backfill house coordinates: %v", err)
+	}
+	if err := models.BackfillLeases(s.DB); err != nil {
+		return fmt.Errorf("cannot backfill tenant leases: %v", err)
+	}
+	if err := models.PurgeExpiredTokens(s.DB); err != nil {
+		return fmt.Errorf("cannot purge expired tokens: %v", err)
+	}
+	return nil
+}
+
+// UseLogger sends the database's output to logger: statements when it logs at
+// debug level, otherwise only errors
+func (s *Store) UseLogger(logger *zap.Logger) {
+	if s.DB == nil {
+		return
+	}
+	s.DB.SetLogger(logging.GormLogger{Logger: logger})
+	if logger.Core().Enabled(zap.DebugLevel) {
+		s.DB.LogMode(true)
+	}
+}
+
+// Ping checks that the database answers; in-memory storage always does
+func (s *Store) Ping(ctx context.Context) error {
+	if s.DB == nil {
+		return nil
+	}
+	return s.DB.DB().PingContext(ctx)
+}
+
+// PendingMigrations counts the schema migrations not applied to the database
+func (s *Store) PendingMigrations() (int, error) {
+	if s.DB == nil {
+		return 0, nil
+	}
+	migrator, err := migrations.New(s.DB)
+	if err != nil {
+		return 0, err
+	}
+	return migrator.Pending()
+}
+
+// Close releases the database connections
+func (s *Store) Close() error {
+	if s.DB == nil {
+		return nil
+	}
+	return s.DB.Close()
+}
@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/khelechy/rielzapi/api/models"
+)
+
+// testStores opens each backend the repositories are tested against
+var testStores = []struct {
+	name string
+	open func(t *testing.T) *Store
+}{
+	{"memory", func(t *testing.T) *Store { return NewMemoryStore() }},
+	{"sqlite", func(t *testing.T) *Store {
+		store, err := NewSQLiteStore(":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		if err = store.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}},
+}
+
+func saveTestLandlord(t *testing.T, store *Store) *models.User {
+	t.Helper()
+	user, err := store.Users.SaveUser(&models.User{Email: "landlord@example.com", FirstName: "Test", LastName: "User", Password: "hashed", Role: models.RoleLandlord})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func saveTestHouse(t *testing.T, store *Store, landlord *models.User) *models.House {
+	t.Helper()
+	house, err := store.Houses.SaveHouse(&models.House{HouseType: "Flat", State: "Lagos", Description: "Flat", Location: "Yaba",
+		Rooms: 1, AvailableRooms: 1, Price: 1200000, LongLat: "3.3792,6.5095", UserID: landlord.ID, Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return house
+}
+
+// TestSaveHouseFreesEveryRoom ignores the available rooms of a new house, such
+// as the counts carried by an exported listing
+func TestSaveHouseFreesEveryRoom(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			landlord := saveTestLandlord(t, s)
+			house, err := s.Houses.SaveHouse(&models.House{HouseType: "Flat", State: "Lagos", Description: "Flat", Location: "Yaba",
+				Rooms: 3, AvailableRooms: 0, Price: 1200000, LongLat: "3.3792,6.5095", UserID: landlord.ID, Version: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, err := s.Houses.GetHouseById(int(house.ID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.AvailableRooms != 3 {
+				t.Errorf("new house has %d available rooms, want 3", stored.AvailableRooms)
+			}
+		})
+	}
+}
+
+// TestDeleteHouseChecksVersion keeps a house that changed since it was read
+func TestDeleteHouseChecksVersion(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			house := saveTestHouse(t, s, saveTestLandlord(t, s))
+			id := int(house.ID)
+
+			if err := s.Houses.DeleteHouse(id, house.Version+1); err != models.ErrHouseModified {
+				t.Fatalf("deleting at a stale version returned %v, want ErrHouseModified", err)
+			}
+			if _, err := s.Houses.GetHouseById(id); err != nil {
+				t.Fatalf("house is gone after a stale delete: %v", err)
+			}
+
+			if err := s.Houses.DeleteHouse(id, house.Version); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Houses.GetHouseById(id); !gorm.IsRecordNotFoundError(err) {
+				t.Errorf("house lookup after delete returned %v, want record not found", err)
+			}
+			if err := s.Houses.DeleteHouse(id, house.Version); !gorm.IsRecordNotFoundError(err) {
+				t.Errorf("deleting a deleted house returned %v, want record not found", err)
+			}
+		})
+	}
+}
+
+// TestTransferTenantBeforeLeaseStart refuses to end the current lease of a
+// tenant before it started
+func TestTransferTenantBeforeLeaseStart(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			landlord := saveTestLandlord(t, s)
+			from, to := saveTestHouse(t, s, landlord), saveTestHouse(t, s, landlord)
+			tenant := &models.Tenant{Email: "tenant@example.com", FirstName: "Tobi", LastName: "Tenant", PhoneNumber: "+2348000000003", HouseId: int(from.ID)}
+			lease := &models.Lease{StartDate: time.Now(), RentAmount: from.Price, Status: models.LeaseActive}
+			if _, _, err := s.Tenants.AddTenant(tenant, lease); err != nil {
+				t.Fatal(err)
+			}
+
+			moved := &models.Lease{StartDate: lease.StartDate.AddDate(0, -1, 0), RentAmount: to.Price, Status: models.LeaseActive}
+			if _, err := s.Tenants.TransferTenant(tenant, int(to.ID), moved); err != models.ErrLeaseEndsBeforeStart {
+				t.Fatalf("back-dated transfer returned %v, want ErrLeaseEndsBeforeStart", err)
+			}
+			if current, err := s.Tenants.GetActiveLease(tenant.ID); err != nil || current.HouseID != from.ID {
+				t.Errorf("tenant holds %v (%v) after the refused transfer, want their lease in house %d", current, err, from.ID)
+			}
+		})
+	}
+}
+
+// saveHouseAt saves a house at the point given, geocoded as validation would
+func saveHouseAt(t *testing.T, store *Store, landlord *models.User, location string, lat, lng float64) uint {
+	t.Helper()
+	house := &models.House{HouseType: "Flat", State: "Lagos", Description: "Flat", Location: location,
+		Rooms: 1, Price: 1200000, LongLat: fmt.Sprintf("%v,%v", lng, lat), UserID: landlord.ID, Version: 1}
+	if err := house.ParseLongLat(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := store.Houses.SaveHouse(house)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return saved.ID
+}
+
+// TestGetHousesNearby searches around points where the bounding box that
+// narrows the rows is at its widest, or given up
+func TestGetHousesNearby(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			landlord := saveTestLandlord(t, s)
+			yaba := saveHouseAt(t, s, landlord, "Yaba", 6.5095, 3.3792)
+			ikeja := saveHouseAt(t, s, landlord, "Ikeja", 6.6018, 3.3515)
+			saveHouseAt(t, s, landlord, "Abuja", 9.0765, 7.3986)
+			north := saveHouseAt(t, s, landlord, "9.99 km north", 60.0898, 10)
+			saveHouseAt(t, s, landlord, "10.02 km north", 60.0901, 10)
+			east := saveHouseAt(t, s, landlord, "9.9 km east", 60, 10.178)
+			west := saveHouseAt(t, s, landlord, "across the antimeridian", 0, -179.99)
+			pole := saveHouseAt(t, s, landlord, "over the pole", 89.99, 180)
+
+			// a house whose LongLat was never parsed has the zero coordinates
+			legacy, err := s.Houses.SaveHouse(&models.House{HouseType: "Flat", State: "Lagos", Description: "Flat", Location: "legacy",
+				Rooms: 1, Price: 1200000, LongLat: "somewhere", UserID: landlord.ID, Version: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			nearLegacy := saveHouseAt(t, s, landlord, "near the legacy house", 0.05, 0)
+
+			tests := []struct {
+				name     string
+				lat, lng float64
+				radiusKm float64
+				limit    int
+				want     []uint
+			}{
+				{"closest first", 6.5095, 3.3792, 20, 20, []uint{yaba, ikeja}},
+				{"within the radius only", 6.5095, 3.3792, 5, 20, []uint{yaba}},
+				{"up to the limit", 6.5095, 3.3792, 20, 1, []uint{yaba}},
+				{"edge of the radius", 60, 10, 10, 20, []uint{east, north}},
+				{"across the antimeridian", 0, 179.99, 10, 20, []uint{west}},
+				{"over the pole", 89.99, 0, 10, 20, []uint{pole}},
+				{"houses never geocoded are left out", 0, 0, 10, 20, []uint{nearLegacy}},
+			}
+			for _, tt := range tests {
+				houses, err := s.Houses.GetHousesNearby(tt.lat, tt.lng, tt.radiusKm, tt.limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := []uint{}
+				for _, house := range *houses {
+					got = append(got, house.ID)
+					if house.DistanceKm > tt.radiusKm {
+						t.Errorf("%s: house %d is %v km away, past %v", tt.name, house.ID, house.DistanceKm, tt.radiusKm)
+					}
+				}
+				if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+					t.Errorf("%s: got houses %v, want %v (legacy house is %d)", tt.name, got, tt.want, legacy.ID)
+				}
+			}
+		})
+	}
+}
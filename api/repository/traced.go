@@ -0,0 +1,415 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/khelechy/rielzapi/api/models"
+	"github.com/khelechy/rielzapi/api/tracing"
+)
+
+// TracedHouses records a span, a child of any span in ctx, for each call to houses
+func TracedHouses(ctx context.Context, houses HouseRepository) HouseRepository {
+	return tracedHouses{ctx: ctx, next: houses}
+}
+
+// TracedAmenities records a span, a child of any span in ctx, for each call to amenities
+func TracedAmenities(ctx context.Context, amenities AmenityRepository) AmenityRepository {
+	return tracedAmenities{ctx: ctx, next: amenities}
+}
+
+// TracedUsers records a span, a child of any span in ctx, for each call to users
+func TracedUsers(ctx context.Context, users UserRepository) UserRepository {
+	return tracedUsers{ctx: ctx, next: users}
+}
+
+// TracedTenants records a span, a child of any span in ctx, for each call to tenants
+func TracedTenants(ctx context.Context, tenants TenantRepository) TenantRepository {
+	return tracedTenants{ctx: ctx, next: tenants}
+}
+
+// TracedTokens records a span, a child of any span in ctx, for each call to tokens
+func TracedTokens(ctx context.Context, tokens TokenRepository) TokenRepository {
+	return tracedTokens{ctx: ctx, next: tokens}
+}
+
+// end closes a repository span. A missing record is an answer, not a failure,
+// so it does not mark the span as an error.
+func end(span trace.Span, err error) {
+	if gorm.IsRecordNotFoundError(err) {
+		span.AddEvent("record not found")
+		err = nil
+	}
+	tracing.End(span, err)
+}
+
+type tracedHouses struct {
+	ctx  context.Context
+	next HouseRepository
+}
+
+type tracedAmenities struct {
+	ctx  context.Context
+	next AmenityRepository
+}
+
+type tracedUsers struct {
+	ctx  context.Context
+	next UserRepository
+}
+
+type tracedTenants struct {
+	ctx  context.Context
+	next TenantRepository
+}
+
+type tracedTokens struct {
+	ctx  context.Context
+	next TokenRepository
+}
+
+func (t tracedHouses) GetHouses(filter models.HouseFilter) (*[]models.House, int, error) {
+	_, span := tracing.Start(t.ctx, "HouseRepository.GetHouses")
+	houses, total, err := t.next.GetHouses(filter)
+	end(span, err)
+	return houses, total, err
+}
+
+func (t tracedHouses) GetHouseById(id int) (*models.House, error) {
+	_, span := tracing.Start(t.ctx, "HouseRepository.GetHouseById")
+	house, err := t.next.GetHouseById(id)
+	end(span, err)
+	return house, err
+}
+
+func (t tracedHouses) GetHousesByLandlord(landlordID uint) (*[]models.House, error) {
+	_, span := tracing.Start(t.ctx, "HouseRepository.GetHousesByLandlord")
+	houses, err := t.next.GetHousesByLandlord(landlordID)
+	end(span, err)
+	return houses, err
+}
+
+func (t tracedHouses) GetHousesByState(state string) (*[]models.House, error) {
+	_, span := tracing.Start(t.ctx, "HouseRepository.GetHousesByState")
+	houses, err := t.next.GetHousesByState(state)
+	end(span, err)
+	return houses, err
+}
+
+func (t tracedHouses) GetHousesNearby(lat, lng, radiusKm float64, limit int) (*[]models.NearbyHouse, error) {
+	_, span := tracing.Start(t.ctx, "HouseRepository.GetHousesNearby")
+	nearbyHouses, err := t.next.GetHousesNearby(lat, lng, radiusKm, limit)
+	end(span, err)
+	return nearbyHouses, err
+}
+
+func (t tracedHouses) SaveHouse(house *models.House) (*models.House, error) {
+	_, span := tracing.Start(t.ctx, "HouseRepository.SaveHouse")
+	house, err := t.next.SaveHouse(house)
+	end(span, err)
+	return house, err
+}
+
+func (t tracedHouses) UpdateHouse(id int, house *models.House) (*models.House, error) {
+	_, span := tracing.Start(t.ctx, "HouseRepository.UpdateHouse")
+	house, err := t.next.UpdateHouse(id, house)
+	end(span, err)
+	return house, err
+}
+
+func (t tracedHouses) DeleteHouse(id int, version uint) error {
+	_, span := tracing.Start(t.ctx, "HouseRepository.DeleteHouse")
+	err := t.next.DeleteHouse(id, version)
+	end(span, err)
+	return err
+}
+
+func (t tracedHouses) GetStateStats() (*[]models.StateStats, error) {
+	_, span := tracing.Start(t.ctx, "HouseRepository.GetStateStats")
+	stats, err := t.next.GetStateStats()
+	end(span, err)
+	return stats, err
+}
+
+func (t tracedHouses) SaveHouseMedia(media *models.HouseMedia) (*models.HouseMedia, error) {
+	_, span := tracing.Start(t.ctx, "HouseRepository.SaveHouseMedia")
+	media, err := t.next.SaveHouseMedia(media)
+	end(span, err)
+	return media, err
+}
+
+func (t tracedHouses) GetHouseMediaById(houseID uint, id int) (*models.HouseMedia, error) {
+	_, span := tracing.Start(t.ctx, "HouseRepository.GetHouseMediaById")
+	media, err := t.next.GetHouseMediaById(houseID, id)
+	end(span, err)
+	return media, err
+}
+
+func (t tracedHouses) ReorderHouseMedia(houseID uint, ids []uint) (*[]models.HouseMedia, error) {
+	_, span := tracing.Start(t.ctx, "HouseRepository.ReorderHouseMedia")
+	media, err := t.next.ReorderHouseMedia(houseID, ids)
+	end(span, err)
+	return media, err
+}
+
+func (t tracedHouses) SetHouseCover(media *models.HouseMedia) (*models.HouseMedia, error) {
+	_, span := tracing.Start(t.ctx, "HouseRepository.SetHouseCover")
+	media, err := t.next.SetHouseCover(media)
+	end(span, err)
+	return media, err
+}
+
+func (t tracedHouses) DeleteHouseMedia(media *models.HouseMedia) error {
+	_, span := tracing.Start(t.ctx, "HouseRepository.DeleteHouseMedia")
+	err := t.next.DeleteHouseMedia(media)
+	end(span, err)
+	return err
+}
+
+func (t tracedAmenities) GetAmenities() (*[]models.Amenity, error) {
+	_, span := tracing.Start(t.ctx, "AmenityRepository.GetAmenities")
+	amenities, err := t.next.GetAmenities()
+	end(span, err)
+	return amenities, err
+}
+
+func (t tracedAmenities) GetAmenityById(id int) (*models.Amenity, error) {
+	_, span := tracing.Start(t.ctx, "AmenityRepository.GetAmenityById")
+	amenity, err := t.next.GetAmenityById(id)
+	end(span, err)
+	return amenity, err
+}
+
+func (t tracedAmenities) SaveAmenity(amenity *models.Amenity) (*models.Amenity, error) {
+	_, span := tracing.Start(t.ctx, "AmenityRepository.SaveAmenity")
+	amenity, err := t.next.SaveAmenity(amenity)
+	end(span, err)
+	return amenity, err
+}
+
+func (t tracedAmenities) UpdateAmenity(id int, amenity *models.Amenity) (*models.Amenity, error) {
+	_, span := tracing.Start(t.ctx, "AmenityRepository.UpdateAmenity")
+	amenity, err := t.next.UpdateAmenity(id, amenity)
+	end(span, err)
+	return amenity, err
+}
+
+func (t tracedAmenities) DeleteAmenity(id int) error {
+	_, span := tracing.Start(t.ctx, "AmenityRepository.DeleteAmenity")
+	err := t.next.DeleteAmenity(id)
+	end(span, err)
+	return err
+}
+
+func (t tracedUsers) GetUsers() (*[]models.User, error) {
+	_, span := tracing.Start(t.ctx, "UserRepository.GetUsers")
+	users, err := t.next.GetUsers()
+	end(span, err)
+	return users, err
+}
+
+func (t tracedUsers) GetUserById(id int) (*models.User, error) {
+	_, span := tracing.Start(t.ctx, "UserRepository.GetUserById")
+	user, err := t.next.GetUserById(id)
+	end(span, err)
+	return user, err
+}
+
+func (t tracedUsers) GetUserByEmail(email string) (*models.User, error) {
+	_, span := tracing.Start(t.ctx, "UserRepository.GetUserByEmail")
+	user, err := t.next.GetUserByEmail(email)
+	end(span, err)
+	return user, err
+}
+
+func (t tracedUsers) SaveUser(user *models.User) (*models.User, error) {
+	_, span := tracing.Start(t.ctx, "UserRepository.SaveUser")
+	user, err := t.next.SaveUser(user)
+	end(span, err)
+	return user, err
+}
+
+func (t tracedUsers) UpdateUser(id int, user *models.User) (*models.User, error) {
+	_, span := tracing.Start(t.ctx, "UserRepository.UpdateUser")
+	user, err := t.next.UpdateUser(id, user)
+	end(span, err)
+	return user, err
+}
+
+func (t tracedUsers) SetRole(id int, role string) error {
+	_, span := tracing.Start(t.ctx, "UserRepository.SetRole")
+	err := t.next.SetRole(id, role)
+	end(span, err)
+	return err
+}
+
+func (t tracedUsers) SetSuspended(id int, suspended bool) error {
+	_, span := tracing.Start(t.ctx, "UserRepository.SetSuspended")
+	err := t.next.SetSuspended(id, suspended)
+	end(span, err)
+	return err
+}
+
+func (t tracedUsers) LinkAgent(agentID, landlordID uint) (*models.AgentLink, error) {
+	_, span := tracing.Start(t.ctx, "UserRepository.LinkAgent")
+	agentLink, err := t.next.LinkAgent(agentID, landlordID)
+	end(span, err)
+	return agentLink, err
+}
+
+func (t tracedUsers) UnlinkAgent(agentID, landlordID uint) error {
+	_, span := tracing.Start(t.ctx, "UserRepository.UnlinkAgent")
+	err := t.next.UnlinkAgent(agentID, landlordID)
+	end(span, err)
+	return err
+}
+
+func (t tracedUsers) GetAgentsByLandlord(landlordID uint) (*[]models.User, error) {
+	_, span := tracing.Start(t.ctx, "UserRepository.GetAgentsByLandlord")
+	users, err := t.next.GetAgentsByLandlord(landlordID)
+	end(span, err)
+	return users, err
+}
+
+func (t tracedUsers) GetLinkedLandlordIDs(agentID uint) ([]uint, error) {
+	_, span := tracing.Start(t.ctx, "UserRepository.GetLinkedLandlordIDs")
+	ids, err := t.next.GetLinkedLandlordIDs(agentID)
+	end(span, err)
+	return ids, err
+}
+
+func (t tracedTenants) GetTenantById(id int) (*models.Tenant, error) {
+	_, span := tracing.Start(t.ctx, "TenantRepository.GetTenantById")
+	tenant, err := t.next.GetTenantById(id)
+	end(span, err)
+	return tenant, err
+}
+
+func (t tracedTenants) AddTenant(tenant *models.Tenant, lease *models.Lease) (*models.Tenant, *models.Lease, error) {
+	_, span := tracing.Start(t.ctx, "TenantRepository.AddTenant")
+	tenant, lease, err := t.next.AddTenant(tenant, lease)
+	end(span, err)
+	return tenant, lease, err
+}
+
+func (t tracedTenants) UpdateTenant(id int, tenant *models.Tenant) (*models.Tenant, error) {
+	_, span := tracing.Start(t.ctx, "TenantRepository.UpdateTenant")
+	tenant, err := t.next.UpdateTenant(id, tenant)
+	end(span, err)
+	return tenant, err
+}
+
+func (t tracedTenants) DeleteTenant(tenant *models.Tenant) error {
+	_, span := tracing.Start(t.ctx, "TenantRepository.DeleteTenant")
+	err := t.next.DeleteTenant(tenant)
+	end(span, err)
+	return err
+}
+
+func (t tracedTenants) TransferTenant(tenant *models.Tenant, houseID int, lease *models.Lease) (*models.Lease, error) {
+	_, span := tracing.Start(t.ctx, "TenantRepository.TransferTenant")
+	lease, err := t.next.TransferTenant(tenant, houseID, lease)
+	end(span, err)
+	return lease, err
+}
+
+func (t tracedTenants) GetLeaseById(id int) (*models.Lease, error) {
+	_, span := tracing.Start(t.ctx, "TenantRepository.GetLeaseById")
+	lease, err := t.next.GetLeaseById(id)
+	end(span, err)
+	return lease, err
+}
+
+func (t tracedTenants) GetActiveLease(tenantID uint) (*models.Lease, error) {
+	_, span := tracing.Start(t.ctx, "TenantRepository.GetActiveLease")
+	lease, err := t.next.GetActiveLease(tenantID)
+	end(span, err)
+	return lease, err
+}
+
+func (t tracedTenants) GetLeasesByHouse(houseID uint, status string) (*[]models.Lease, error) {
+	_, span := tracing.Start(t.ctx, "TenantRepository.GetLeasesByHouse")
+	leases, err := t.next.GetLeasesByHouse(houseID, status)
+	end(span, err)
+	return leases, err
+}
+
+func (t tracedTenants) GetLeasesByTenantEmail(email string) (*[]models.Lease, error) {
+	_, span := tracing.Start(t.ctx, "TenantRepository.GetLeasesByTenantEmail")
+	leases, err := t.next.GetLeasesByTenantEmail(email)
+	end(span, err)
+	return leases, err
+}
+
+func (t tracedTenants) EndLease(lease *models.Lease, status string, endDate time.Time) (*models.Lease, error) {
+	_, span := tracing.Start(t.ctx, "TenantRepository.EndLease")
+	lease, err := t.next.EndLease(lease, status, endDate)
+	end(span, err)
+	return lease, err
+}
+
+func (t tracedTokens) SaveRefreshToken(userID uint, tokenHash string, expiresAt time.Time) (*models.RefreshToken, error) {
+	_, span := tracing.Start(t.ctx, "TokenRepository.SaveRefreshToken")
+	refreshToken, err := t.next.SaveRefreshToken(userID, tokenHash, expiresAt)
+	end(span, err)
+	return refreshToken, err
+}
+
+func (t tracedTokens) RotateRefreshToken(tokenHash, newHash string, expiresAt time.Time) (*models.User, error) {
+	_, span := tracing.Start(t.ctx, "TokenRepository.RotateRefreshToken")
+	user, err := t.next.RotateRefreshToken(tokenHash, newHash, expiresAt)
+	end(span, err)
+	return user, err
+}
+
+func (t tracedTokens) RevokeRefreshToken(userID uint, tokenHash string) error {
+	_, span := tracing.Start(t.ctx, "TokenRepository.RevokeRefreshToken")
+	err := t.next.RevokeRefreshToken(userID, tokenHash)
+	end(span, err)
+	return err
+}
+
+func (t tracedTokens) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	_, span := tracing.Start(t.ctx, "TokenRepository.RevokeAccessToken")
+	err := t.next.RevokeAccessToken(jti, expiresAt)
+	end(span, err)
+	return err
+}
+
+func (t tracedTokens) RevokeAllSessions(userID uint) error {
+	_, span := tracing.Start(t.ctx, "TokenRepository.RevokeAllSessions")
+	err := t.next.RevokeAllSessions(userID)
+	end(span, err)
+	return err
+}
+
+func (t tracedTokens) IsAccessTokenRevoked(jti string, userID uint, issuedAt int64) (bool, error) {
+	_, span := tracing.Start(t.ctx, "TokenRepository.IsAccessTokenRevoked")
+	ok, err := t.next.IsAccessTokenRevoked(jti, userID, issuedAt)
+	end(span, err)
+	return ok, err
+}
+
+func (t tracedTokens) SaveUserToken(userID uint, email, purpose, tokenHash string, expiresAt time.Time) (*models.UserToken, error) {
+	_, span := tracing.Start(t.ctx, "TokenRepository.SaveUserToken")
+	userToken, err := t.next.SaveUserToken(userID, email, purpose, tokenHash, expiresAt)
+	end(span, err)
+	return userToken, err
+}
+
+func (t tracedTokens) ResetPassword(tokenHash, password string) error {
+	_, span := tracing.Start(t.ctx, "TokenRepository.ResetPassword")
+	err := t.next.ResetPassword(tokenHash, password)
+	end(span, err)
+	return err
+}
+
+func (t tracedTokens) VerifyEmail(tokenHash string) error {
+	_, span := tracing.Start(t.ctx, "TokenRepository.VerifyEmail")
+	err := t.next.VerifyEmail(tokenHash)
+	end(span, err)
+	return err
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/khelechy/rielzapi/api/models"
+)
+
+// TestTracedHouses records a span for each call, treating a missing house as an
+// answer and a refused change as a failure
+func TestTracedHouses(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	store := NewMemoryStore()
+	house := saveTestHouse(t, store, saveTestLandlord(t, store))
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "GET /api/houses/{id}")
+	houses := TracedHouses(ctx, store.Houses)
+
+	houses.GetHouseById(int(house.ID))
+	houses.GetHouseById(int(house.ID) + 1)
+	houses.DeleteHouse(int(house.ID), house.Version+1)
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("recorded %d spans, want the three calls and their parent", len(spans))
+	}
+	tests := []struct {
+		name   string
+		status codes.Code
+		events int
+	}{
+		{"HouseRepository.GetHouseById", codes.Unset, 0},
+		{"HouseRepository.GetHouseById", codes.Unset, 1}, // record not found
+		{"HouseRepository.DeleteHouse", codes.Error, 1},  // the error
+	}
+	for i, tt := range tests {
+		span := spans[i]
+		if span.Name() != tt.name || span.Status().Code != tt.status || len(span.Events()) != tt.events {
+			t.Errorf("span %d is %q with status %v and %d events, want %q with %v and %d", i, span.Name(), span.Status().Code, len(span.Events()), tt.name, tt.status, tt.events)
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %d is not a child of the request span", i)
+		}
+	}
+	if message := spans[2].Status().Description; message != models.ErrHouseModified.Error() {
+		t.Errorf("failed span says %q", message)
+	}
+}
@@ -5,6 +5,11 @@ import (
     "encoding/json"
     "fmt"
     "net/http"
+
+    "go.uber.org/zap"
+
+    "github.com/khelechy/rielzapi/api/apperr"
+    "github.com/khelechy/rielzapi/api/logging"
 )
 
 // JSON returns a well formated response with a status code
@@ -16,15 +21,25 @@ func JSON(w http.ResponseWriter, statusCode int, data interface{}) {
     }
 }
 
-// ERROR returns a jsonified error response along with a status code.
-func ERROR(w http.ResponseWriter, statusCode int, err error) {
-    if err != nil {
-        JSON(w, statusCode, struct {
-            Error string `json:"error"`
-        }{
-            Error: err.Error(),
-        })
-        return
+// ERROR answers with err as application/problem+json. The status and code come
+// from the kind of err; errors without one are internal, their cause is logged
+// with the request and the client only gets a generic message.
+func ERROR(w http.ResponseWriter, r *http.Request, err error) {
+    e := apperr.From(err)
+    status := e.Status()
+    if status >= http.StatusInternalServerError {
+        logging.FromContext(r.Context()).Error("request failed", zap.String("code", e.Code), zap.Error(err))
     }
-    JSON(w, http.StatusBadRequest, nil)
-}
\ No newline at end of file
+
+    w.Header().Set("Content-Type", ProblemContentType)
+    JSON(w, status, Problem{
+        Type:      "about:blank",
+        Title:     http.StatusText(status),
+        Status:    status,
+        Detail:    e.Message,
+        Instance:  r.URL.Path,
+        Code:      e.Code,
+        RequestID: logging.RequestID(r.Context()),
+        Errors:    e.Fields,
+    })
+}
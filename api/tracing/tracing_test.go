@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/khelechy/rielzapi/api/logging"
+	"github.com/khelechy/rielzapi/config"
+)
+
+// recordSpans installs a tracer provider that keeps every span ended, for the
+// rest of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(propagator)
+	})
+	return recorder
+}
+
+// attributes returns the attributes of a span by key
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	values := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		values[kv.Key] = kv.Value
+	}
+	return values
+}
+
+// TestMiddleware names each request's span after its route, continues the
+// caller's trace and tags the request's logs with the trace ID
+func TestMiddleware(t *testing.T) {
+	recorder := recordSpans(t)
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/api/houses/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "HouseRepository.GetHouseById")
+		End(span, nil)
+		logging.FromContext(r.Context()).Info("house loaded")
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	caller := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest("GET", "/api/houses/12", nil)
+	req.Header.Set("traceparent", caller)
+	req = req.WithContext(logging.WithLogger(req.Context(), zap.New(core)))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want the repository call and the request", len(spans))
+	}
+	child, server := spans[0], spans[1]
+	if server.Name() != "GET /api/houses/{id:[0-9]+}" || server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("request span is %s %q, want a server span named after the route", server.SpanKind(), server.Name())
+	}
+	if traceID := server.SpanContext().TraceID().String(); traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("request span is in trace %s, want the caller's", traceID)
+	}
+	if parent := server.Parent().SpanID().String(); parent != "00f067aa0ba902b7" {
+		t.Errorf("request span has parent %s, want the caller's span", parent)
+	}
+	if status := attributes(server)["http.status_code"]; status.AsInt64() != http.StatusNotFound {
+		t.Errorf("request span has status %v, want 404", status.Emit())
+	}
+	if child.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("repository span is not a child of the request span")
+	}
+
+	entries := logs.TakeAll()
+	if len(entries) != 1 || entries[0].ContextMap()["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("request logged %v, want a line tagged with the trace ID", entries)
+	}
+}
+
+func TestEnd(t *testing.T) {
+	recorder := recordSpans(t)
+
+	_, span := Start(context.Background(), "Mailer.Send", attribute.String("mailer", "*mailer.SMTPMailer"))
+	End(span, errors.New("connection refused"))
+	_, span = Start(context.Background(), "Mailer.Send")
+	End(span, nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	failed := spans[0]
+	if failed.Status().Code != codes.Error || failed.Status().Description != "connection refused" || len(failed.Events()) != 1 {
+		t.Errorf("failed span has status %v and %d events, want the error recorded", failed.Status(), len(failed.Events()))
+	}
+	if mailer := attributes(failed)["mailer"]; mailer.AsString() != "*mailer.SMTPMailer" {
+		t.Errorf("span has mailer %q", mailer.AsString())
+	}
+	if spans[1].Status().Code == codes.Error {
+		t.Error("span that succeeded is marked as failed")
+	}
+}
+
+func TestSetup(t *testing.T) {
+	previous, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	flush, err := Setup(context.Background(), config.Tracing{Exporter: "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flush(context.Background()); err != nil {
+		t.Errorf("flushing without an exporter returned %v", err)
+	}
+	if _, err = Setup(context.Background(), config.Tracing{Exporter: "zipkin"}); err == nil {
+		t.Error("unknown exporter was accepted")
+	}
+}
@@ -0,0 +1,159 @@
+// Package uploads checks the files users upload for houses and makes the
+// thumbnails of images. The type of a file is sniffed from its content; the
+// name and Content-Type it was sent with are not trusted.
+package uploads
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png" // decodes PNG uploads
+	"net/http"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // decodes WebP uploads
+
+	"github.com/khelechy/rielzapi/api/apperr"
+	"github.com/khelechy/rielzapi/api/models"
+)
+
+const (
+	// ThumbnailWidth and ThumbnailHeight bound thumbnails, which keep the aspect of the image
+	ThumbnailWidth  = 320
+	ThumbnailHeight = 320
+	// MaxPixels bounds the images decoded, so a small file cannot claim a huge canvas
+	MaxPixels = 50_000_000
+)
+
+// extensions maps the content types accepted to the extensions files are stored with
+var extensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+// accepted lists the content types accepted for each kind of media
+var accepted = map[string][]string{
+	models.MediaPhoto:     {"image/jpeg", "image/png", "image/webp"},
+	models.MediaFloorPlan: {"image/jpeg", "image/png", "image/webp", "application/pdf"},
+	models.MediaDocument:  {"application/pdf"},
+}
+
+// File is an upload that passed the checks
+type File struct {
+	ContentType string
+	Extension   string
+	// Width, Height and Thumbnail are only set for images
+	Width     int
+	Height    int
+	Thumbnail []byte
+}
+
+// IsImage reports whether the file is an image
+func (f *File) IsImage() bool {
+	return strings.HasPrefix(f.ContentType, "image/")
+}
+
+// ValidKind reports whether media of the kind can be uploaded
+func ValidKind(kind string) bool {
+	_, ok := accepted[kind]
+	return ok
+}
+
+// Inspect checks that data is a file of a type accepted for the kind of media
+// and, for images, that it decodes, making its thumbnail
+func Inspect(kind string, data []byte) (*File, error) {
+	if len(data) == 0 {
+		return nil, apperr.InvalidField("file", "File is empty")
+	}
+	contentType := http.DetectContentType(data)
+	if !acceptedType(kind, contentType) {
+		return nil, apperr.InvalidField("file", fmt.Sprintf("A %s must be one of %s, not %s",
+			strings.Replace(kind, "_", " ", -1), strings.Join(accepted[kind], ", "), contentType))
+	}
+
+	file := &File{ContentType: contentType, Extension: extensions[contentType]}
+	if !file.IsImage() {
+		return file, nil
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, apperr.InvalidField("file", "Image cannot be read: "+err.Error())
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, apperr.InvalidField("file", fmt.Sprintf("Image must have at most %d pixels", MaxPixels))
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, apperr.InvalidField("file", "Image cannot be read: "+err.Error())
+	}
+	file.Width, file.Height = config.Width, config.Height
+	if file.Thumbnail, err = Thumbnail(img); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func acceptedType(kind, contentType string) bool {
+	for _, accepted := range accepted[kind] {
+		if accepted == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// Thumbnail scales img down to fit within ThumbnailWidth by ThumbnailHeight,
+// never up, and encodes it as a JPEG. Transparent areas become white.
+func Thumbnail(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := thumbnailSize(bounds.Dx(), bounds.Dy())
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(thumbnail, thumbnail.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.ApproxBiLinear.Scale(thumbnail, thumbnail.Bounds(), img, bounds, draw.Over, nil)
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, thumbnail, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// thumbnailSize is the size of the thumbnail of a width by height image
+func thumbnailSize(width, height int) (int, int) {
+	if width <= ThumbnailWidth && height <= ThumbnailHeight {
+		return width, height
+	}
+	// the side furthest over its bound sets the scale
+	if width*ThumbnailHeight >= height*ThumbnailWidth {
+		height = height * ThumbnailWidth / width
+		width = ThumbnailWidth
+	} else {
+		width = width * ThumbnailHeight / height
+		height = ThumbnailHeight
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	return width, height
+}
+
+// NewKey names a file stored under prefix with a random name that cannot be
+// guessed or collide with another
+func NewKey(prefix, extension string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + "/" + hex.EncodeToString(b) + extension, nil
+}
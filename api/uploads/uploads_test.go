@@ -0,0 +1,44 @@
+package uploads
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func TestThumbnailFitsBox(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height         int
+		wantWidth, wantHeight int
+	}{
+		{"small image is kept", 100, 50, 100, 50},
+		{"wide image", 1280, 960, 320, 240},
+		{"tall image", 960, 1280, 240, 320},
+		{"narrow and very tall image", 100, 500000, 1, 320},
+		{"wide and very short image", 500000, 100, 320, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height := thumbnailSize(tt.width, tt.height)
+			if width != tt.wantWidth || height != tt.wantHeight {
+				t.Errorf("thumbnail of %dx%d is %dx%d, want %dx%d", tt.width, tt.height, width, height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestThumbnailEncodesJPEG(t *testing.T) {
+	out, err := Thumbnail(image.NewRGBA(image.Rect(0, 0, 100, 5000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 6 || config.Height != ThumbnailHeight {
+		t.Errorf("thumbnail is %dx%d, want 6x%d", config.Width, config.Height, ThumbnailHeight)
+	}
+}
@@ -0,0 +1,127 @@
+// Package validation checks values against the rules in their validate struct
+// tags. Every broken rule is reported at once, named after the JSON field the
+// client sent, so a form can show all of its mistakes in one round trip.
+//
+// The rules are those of github.com/go-playground/validator plus any added
+// with RegisterRule. Models check their rules in Validate, which creates and
+// updates both call.
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+
+	"github.com/khelechy/rielzapi/api/apperr"
+)
+
+var (
+	validate = newValidator()
+	// messages explain the rules added with RegisterRule
+	messages = map[string]string{}
+)
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(fieldName)
+	return v
+}
+
+// RegisterRule adds a rule for string fields, used in tags by its name.
+// message explains a broken rule, such as "must be a valid postcode". Rules
+// are registered from init functions, before anything is validated.
+func RegisterRule(name, message string, valid func(value string) bool) {
+	err := validate.RegisterValidation(name, func(fl validator.FieldLevel) bool {
+		return valid(fl.Field().String())
+	})
+	if err != nil {
+		panic(err)
+	}
+	messages[name] = message
+}
+
+// Struct checks every rule of s, a struct or a pointer to one. It returns nil
+// or a validation *apperr.Error listing each field that broke a rule.
+func Struct(s interface{}) error {
+	return check(s, validate.Struct(s))
+}
+
+// Fields checks only the rules of the named struct fields, for requests such
+// as logging in that carry part of a model
+func Fields(s interface{}, fields ...string) error {
+	return check(s, validate.StructPartial(s, fields...))
+}
+
+func check(s interface{}, err error) error {
+	if err == nil {
+		return nil
+	}
+	invalid, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return err // a programming error, such as validating a non struct
+	}
+
+	fields := make([]apperr.FieldError, 0, len(invalid))
+	details := make([]string, 0, len(invalid))
+	for _, fe := range invalid {
+		message := describe(fe, reflect.Indirect(reflect.ValueOf(s)).Type())
+		fields = append(fields, apperr.FieldError{Field: fe.Field(), Message: message})
+		details = append(details, fe.Field()+" "+message)
+	}
+	return apperr.Validation(strings.Join(details, "; "), fields...)
+}
+
+// describe explains a broken rule of a field of struct type t in words
+func describe(fe validator.FieldError, t reflect.Type) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "e164":
+		return "must be a phone number in international format, such as +2348012345678"
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fe.Param()), ", ")
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "gte":
+		return "must be at least " + fe.Param()
+	case "lte":
+		return "must be at most " + fe.Param()
+	case "max":
+		if fe.Kind() == reflect.String {
+			return "must be at most " + fe.Param() + " characters"
+		}
+		return "must be at most " + fe.Param()
+	case "ltefield":
+		return "cannot be more than " + jsonName(t, fe.Param())
+	}
+	if message, ok := messages[fe.Tag()]; ok {
+		return message
+	}
+	return fmt.Sprintf("breaks the %s rule", fe.Tag())
+}
+
+// jsonName returns the JSON name of the field of t a cross field rule compares with
+func jsonName(t reflect.Type, field string) string {
+	if f, ok := t.FieldByName(field); ok {
+		return fieldName(f)
+	}
+	return field
+}
+
+// fieldName is the name clients know a field by: its JSON name when it has
+// one. Fields kept out of responses, such as passwords, are still sent in
+// requests under their lower case name.
+func fieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	switch name {
+	case "":
+		return field.Name
+	case "-":
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
@@ -0,0 +1,120 @@
+package validation
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/khelechy/rielzapi/api/apperr"
+)
+
+func init() {
+	RegisterRule("postcode", "must be a valid postcode", func(value string) bool {
+		return len(value) == 6 && strings.Trim(value, "0123456789") == ""
+	})
+}
+
+type listing struct {
+	Email     string `json:"email"     validate:"required,email"`
+	Name      string `json:"name,omitempty" validate:"max=5"`
+	Password  string `json:"-"         validate:"required"`
+	Phone     string `json:"phonenumber" validate:"omitempty,e164"`
+	Role      string `json:"role"      validate:"omitempty,oneof=admin landlord"`
+	Rooms     int    `json:"rooms"     validate:"gt=0"`
+	Available int    `json:"available_rooms" validate:"ltefield=Rooms"`
+	Postcode  string `json:"postcode"  validate:"omitempty,postcode"`
+	Notes     string `validate:"max=3"`
+}
+
+func valid() listing {
+	return listing{Email: "ada@example.com", Password: "secret", Rooms: 2, Available: 1}
+}
+
+// fields returns the field errors of a validation error, failing on any other error
+func fields(t *testing.T, err error) []apperr.FieldError {
+	t.Helper()
+	var e *apperr.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want an *apperr.Error", err)
+	}
+	if e.Status() != http.StatusUnprocessableEntity || e.Code != apperr.CodeValidationFailed {
+		t.Fatalf("got status %d and code %q, want 422 validation_failed", e.Status(), e.Code)
+	}
+	return e.Fields
+}
+
+func TestStructValid(t *testing.T) {
+	l := valid()
+	if err := Struct(&l); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if err := Struct(l); err != nil {
+		t.Fatalf("by value got %v, want nil", err)
+	}
+}
+
+// TestStructReportsEveryField names each broken field after its JSON name and
+// explains each rule, all in one error
+func TestStructReportsEveryField(t *testing.T) {
+	l := listing{
+		Email:     "not an email",
+		Name:      "Adaeze",
+		Phone:     "08012345678",
+		Role:      "tenant",
+		Rooms:     0,
+		Available: 3,
+		Postcode:  "ABC",
+		Notes:     "long",
+	}
+	got := fields(t, Struct(&l))
+	want := []apperr.FieldError{
+		{Field: "email", Message: "must be a valid email address"},
+		{Field: "name", Message: "must be at most 5 characters"},
+		{Field: "password", Message: "is required"},
+		{Field: "phonenumber", Message: "must be a phone number in international format, such as +2348012345678"},
+		{Field: "role", Message: "must be one of admin, landlord"},
+		{Field: "rooms", Message: "must be greater than 0"},
+		{Field: "available_rooms", Message: "cannot be more than rooms"},
+		{Field: "postcode", Message: "must be a valid postcode"},
+		{Field: "Notes", Message: "must be at most 3 characters"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got fields\n%v\nwant\n%v", got, want)
+	}
+	if err := Struct(&l); !strings.HasPrefix(err.Error(), "email must be a valid email address; name ") {
+		t.Errorf("got message %q", err.Error())
+	}
+}
+
+func TestFieldsChecksOnlyTheNamedFields(t *testing.T) {
+	l := listing{Email: "ada@example.com"} // no password and no rooms
+	got := fields(t, Fields(&l, "Email", "Password"))
+	want := []apperr.FieldError{{Field: "password", Message: "is required"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got fields %v, want %v", got, want)
+	}
+
+	l.Password = "secret"
+	if err := Fields(&l, "Email", "Password"); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+}
+
+func TestStructOfNonStruct(t *testing.T) {
+	err := Struct("listing")
+	var e *apperr.Error
+	if err == nil || errors.As(err, &e) {
+		t.Errorf("got %v, want the validator's own error", err)
+	}
+}
+
+func TestRegisterRuleWithoutNamePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a rule without a name did not panic")
+		}
+	}()
+	RegisterRule("", "never", func(string) bool { return true })
+}
@@ -0,0 +1,417 @@
+// Package config loads the settings of the API into typed structs. Values come
+// from defaults, then an optional YAML or TOML file, then the environment, where
+// a .env file in the working directory fills in variables that are not set.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v2"
+)
+
+// MinSecretLength is the shortest SECRET accepted for signing HS256 tokens
+const MinSecretLength = 32
+
+// Config is everything the API can be configured with. The env tag names the
+// variable that overrides a field; secret fields are masked by Redacted.
+type Config struct {
+	Server   Server   `yaml:"server"   toml:"server"`
+	Database Database `yaml:"database" toml:"database"`
+	Auth     Auth     `yaml:"auth"     toml:"auth"`
+	Mail     Mail     `yaml:"mail"     toml:"mail"`
+	Log      Log      `yaml:"log"      toml:"log"`
+	Tracing  Tracing  `yaml:"tracing"  toml:"tracing"`
+	Storage  Storage  `yaml:"storage"  toml:"storage"`
+	AppURL   string   `yaml:"app_url"  toml:"app_url" env:"APP_URL"`
+}
+
+// Server configures the HTTP listener. TLS is served when both certificate
+// files are set.
+type Server struct {
+	Port              string        `yaml:"port"                toml:"port"                env:"PORT"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"        toml:"read_timeout"        env:"SERVER_READ_TIMEOUT"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"       toml:"write_timeout"       env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"        toml:"idle_timeout"        env:"SERVER_IDLE_TIMEOUT"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"    toml:"shutdown_timeout"    env:"SERVER_SHUTDOWN_TIMEOUT"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes"    toml:"max_header_bytes"    env:"SERVER_MAX_HEADER_BYTES"`
+	MaxBodyBytes      int           `yaml:"max_body_bytes"      toml:"max_body_bytes"      env:"SERVER_MAX_BODY_BYTES"`
+	TLSCertFile       string        `yaml:"tls_cert_file"       toml:"tls_cert_file"       env:"TLS_CERT_FILE"`
+	TLSKeyFile        string        `yaml:"tls_key_file"        toml:"tls_key_file"        env:"TLS_KEY_FILE"`
+}
+
+// Database selects the storage backend and tunes its connection pool
+type Database struct {
+	Driver          string        `yaml:"driver"            toml:"driver"            env:"DB_DRIVER"`
+	Host            string        `yaml:"host"              toml:"host"              env:"DB_HOST"`
+	Port            string        `yaml:"port"              toml:"port"              env:"DB_PORT"`
+	User            string        `yaml:"user"              toml:"user"              env:"DB_USER"`
+	Name            string        `yaml:"name"              toml:"name"              env:"DB_NAME"`
+	Password        string        `yaml:"password"          toml:"password"          env:"DB_PASSWORD" secret:"true"`
+	SSLMode         string        `yaml:"sslmode"           toml:"sslmode"           env:"DB_SSLMODE"`
+	MaxOpenConns    int           `yaml:"max_open_conns"    toml:"max_open_conns"    env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"    toml:"max_idle_conns"    env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+}
+
+// Auth holds the keys that sign and verify access tokens
+type Auth struct {
+	Secret         string   `yaml:"secret"           toml:"secret"           env:"SECRET"          secret:"true"`
+	SecretPrevious []string `yaml:"secret_previous"  toml:"secret_previous"  env:"SECRET_PREVIOUS" secret:"true"`
+	PrivateKeyFile string   `yaml:"private_key_file" toml:"private_key_file" env:"JWT_PRIVATE_KEY_FILE"`
+	PublicKeyFiles []string `yaml:"public_key_files" toml:"public_key_files" env:"JWT_PUBLIC_KEY_FILES"`
+	Issuer         string   `yaml:"issuer"           toml:"issuer"           env:"JWT_ISSUER"`
+	Audience       string   `yaml:"audience"         toml:"audience"         env:"JWT_AUDIENCE"`
+}
+
+// Mail selects how emails to users are delivered
+type Mail struct {
+	Mailer       string `yaml:"mailer"        toml:"mailer"        env:"MAILER"`
+	From         string `yaml:"from"          toml:"from"          env:"MAIL_FROM"`
+	Dir          string `yaml:"dir"           toml:"dir"           env:"MAIL_DIR"`
+	SMTPHost     string `yaml:"smtp_host"     toml:"smtp_host"     env:"SMTP_HOST"`
+	SMTPPort     string `yaml:"smtp_port"     toml:"smtp_port"     env:"SMTP_PORT"`
+	SMTPUsername string `yaml:"smtp_username" toml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" toml:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+}
+
+// Log sets how much the API logs and in which format. SQL statements are
+// logged at the debug level.
+type Log struct {
+	Level  string `yaml:"level"  toml:"level"  env:"LOG_LEVEL"`
+	Format string `yaml:"format" toml:"format" env:"LOG_FORMAT"`
+}
+
+// Tracing selects where OpenTelemetry spans are exported. Nothing is recorded
+// when the exporter is none.
+type Tracing struct {
+	Exporter    string  `yaml:"exporter"     toml:"exporter"     env:"TRACING_EXPORTER"`
+	Endpoint    string  `yaml:"endpoint"     toml:"endpoint"     env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	ServiceName string  `yaml:"service_name" toml:"service_name" env:"OTEL_SERVICE_NAME"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+// Storage selects where uploaded house photos and documents are kept: local,
+// a directory the API serves under /media, or s3, a bucket of any
+// S3-compatible service. PublicURL is the base URL files are linked from, for
+// a CDN or a public bucket; by default links point at the API or the bucket.
+type Storage struct {
+	Driver         string `yaml:"driver"           toml:"driver"           env:"STORAGE_DRIVER"`
+	Dir            string `yaml:"dir"              toml:"dir"              env:"STORAGE_DIR"`
+	PublicURL      string `yaml:"public_url"       toml:"public_url"       env:"STORAGE_PUBLIC_URL"`
+	MaxUploadBytes int    `yaml:"max_upload_bytes" toml:"max_upload_bytes" env:"UPLOAD_MAX_BYTES"`
+	S3Endpoint     string `yaml:"s3_endpoint"      toml:"s3_endpoint"      env:"S3_ENDPOINT"`
+	S3Region       string `yaml:"s3_region"        toml:"s3_region"        env:"S3_REGION"`
+	S3Bucket       string `yaml:"s3_bucket"        toml:"s3_bucket"        env:"S3_BUCKET"`
+	S3AccessKey    string `yaml:"s3_access_key"    toml:"s3_access_key"    env:"S3_ACCESS_KEY_ID"`
+	S3SecretKey    string `yaml:"s3_secret_key"    toml:"s3_secret_key"    env:"S3_SECRET_ACCESS_KEY" secret:"true"`
+}
+
+// Default returns the configuration used for anything left unset
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:              "5000",
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			MaxBodyBytes:      1 << 20,
+		},
+		Database: Database{
+			Driver:          "postgres",
+			Host:            "localhost",
+			Port:            "5432",
+			SSLMode:         "disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+		},
+		Auth: Auth{Issuer: "rielzapi", Audience: "rielzapi"},
+		Mail: Mail{
+			Mailer:   "file",
+			From:     "RielzAPI <no-reply@rielzapi.local>",
+			Dir:      "mail",
+			SMTPPort: "587",
+		},
+		Log: Log{Level: "info", Format: "json"},
+		Tracing: Tracing{
+			Exporter:    "none",
+			Endpoint:    "http://localhost:4318",
+			ServiceName: "rielzapi",
+			SampleRatio: 1,
+		},
+		Storage: Storage{
+			Driver:         "local",
+			Dir:            "uploads",
+			MaxUploadBytes: 10 << 20,
+			S3Region:       "us-east-1",
+		},
+		AppURL: "http://localhost:5000",
+	}
+}
+
+// Load reads the configuration. file may be empty, in which case CONFIG_FILE
+// names the file, if any. A missing .env is not an error, so containers can
+// pass everything through the environment.
+func Load(file string) (*Config, error) {
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf(".env: %v", err)
+	}
+
+	cfg := Default()
+	if file == "" {
+		file = os.Getenv("CONFIG_FILE")
+	}
+	if file != "" {
+		if err := cfg.readFile(file); err != nil {
+			return nil, err
+		}
+	}
+	if err := overlayEnv(reflect.ValueOf(cfg).Elem()); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readFile decodes a YAML or TOML file, chosen by its extension, over cfg
+func (c *Config) readFile(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, c)
+	case ".toml":
+		var meta toml.MetaData
+		if meta, err = toml.Decode(string(data), c); err == nil && len(meta.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown settings %v", meta.Undecoded())
+		}
+	default:
+		return fmt.Errorf("%s: config files must be .yaml, .yml or .toml", file)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	return nil
+}
+
+// overlayEnv sets every field with an env tag whose variable is set and not empty
+func overlayEnv(v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		field, spec := v.Field(i), v.Type().Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := overlayEnv(field); err != nil {
+				return err
+			}
+			continue
+		}
+		name := spec.Tag.Get("env")
+		value := strings.TrimSpace(os.Getenv(name))
+		if name == "" || value == "" {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetFloat(f)
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 30s or 5m", value)
+		}
+		field.SetInt(int64(d))
+	case []string:
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate reports every setting the API cannot start with
+func (c *Config) Validate() error {
+	problems := []string{}
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		problem("PORT must be a port number, got %q", c.Server.Port)
+	}
+	server := c.Server
+	if server.ReadTimeout < 0 || server.ReadHeaderTimeout < 0 || server.WriteTimeout < 0 || server.IdleTimeout < 0 || server.ShutdownTimeout < 0 {
+		problem("server timeouts cannot be negative")
+	}
+	if server.MaxHeaderBytes <= 0 || server.MaxBodyBytes <= 0 {
+		problem("SERVER_MAX_HEADER_BYTES and SERVER_MAX_BODY_BYTES must be positive")
+	}
+	if (server.TLSCertFile == "") != (server.TLSKeyFile == "") {
+		problem("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	db := c.Database
+	switch db.Driver {
+	case "postgres":
+		if db.Host == "" || db.User == "" || db.Name == "" {
+			problem("DB_HOST, DB_USER and DB_NAME are required for postgres")
+		}
+		if _, err := strconv.Atoi(db.Port); err != nil {
+			problem("DB_PORT must be a port number, got %q", db.Port)
+		}
+		switch db.SSLMode {
+		case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+		default:
+			problem("DB_SSLMODE must be disable, allow, prefer, require, verify-ca or verify-full, got %q", db.SSLMode)
+		}
+	case "sqlite", "memory":
+	default:
+		problem("DB_DRIVER must be postgres, sqlite or memory, got %q", db.Driver)
+	}
+	if db.MaxOpenConns < 0 || db.MaxIdleConns < 0 || db.ConnMaxLifetime < 0 {
+		problem("database pool settings cannot be negative")
+	}
+
+	if c.Auth.PrivateKeyFile == "" && c.Auth.Secret == "" {
+		problem("SECRET or JWT_PRIVATE_KEY_FILE must be set to sign tokens")
+	}
+	if c.Auth.Secret != "" && len(c.Auth.Secret) < MinSecretLength {
+		problem("SECRET must be at least %d characters, generate one with `openssl rand -base64 32`", MinSecretLength)
+	}
+
+	switch c.Mail.Mailer {
+	case "smtp":
+		if c.Mail.SMTPHost == "" {
+			problem("SMTP_HOST must be set when MAILER is smtp")
+		}
+	case "file", "memory":
+	default:
+		problem("MAILER must be smtp, file or memory, got %q", c.Mail.Mailer)
+	}
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		problem("LOG_LEVEL must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	switch c.Log.Format {
+	case "json", "console":
+	default:
+		problem("LOG_FORMAT must be json or console, got %q", c.Log.Format)
+	}
+
+	tracing := c.Tracing
+	switch tracing.Exporter {
+	case "otlp":
+		if u, err := url.Parse(tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("OTEL_EXPORTER_OTLP_ENDPOINT must be an http or https URL, got %q", tracing.Endpoint)
+		}
+	case "none", "stdout":
+	default:
+		problem("TRACING_EXPORTER must be none, stdout or otlp, got %q", tracing.Exporter)
+	}
+	if tracing.ServiceName == "" {
+		problem("OTEL_SERVICE_NAME cannot be empty")
+	}
+	if tracing.SampleRatio < 0 || tracing.SampleRatio > 1 {
+		problem("TRACING_SAMPLE_RATIO must be between 0 and 1, got %v", tracing.SampleRatio)
+	}
+
+	storage := c.Storage
+	switch storage.Driver {
+	case "s3":
+		if u, err := url.Parse(storage.S3Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("S3_ENDPOINT must be an http or https URL, got %q", storage.S3Endpoint)
+		}
+		if storage.S3Bucket == "" || storage.S3AccessKey == "" || storage.S3SecretKey == "" {
+			problem("S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set when STORAGE_DRIVER is s3")
+		}
+	case "local":
+		if storage.Dir == "" {
+			problem("STORAGE_DIR cannot be empty")
+		}
+	default:
+		problem("STORAGE_DRIVER must be local or s3, got %q", storage.Driver)
+	}
+	if storage.PublicURL != "" {
+		if u, err := url.Parse(storage.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("STORAGE_PUBLIC_URL must be an http or https URL, got %q", storage.PublicURL)
+		}
+	}
+	if storage.MaxUploadBytes <= 0 {
+		problem("UPLOAD_MAX_BYTES must be positive")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with secret values masked
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redacted.Auth.SecretPrevious = append([]string(nil), c.Auth.SecretPrevious...)
+	redact(reflect.ValueOf(&redacted).Elem())
+	return &redacted
+}
+
+func redact(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct:
+			redact(field)
+		case v.Type().Field(i).Tag.Get("secret") != "true":
+		case field.Kind() == reflect.String && field.Len() > 0:
+			field.SetString("REDACTED")
+		case field.Kind() == reflect.Slice:
+			for j := 0; j < field.Len(); j++ {
+				field.Index(j).SetString("REDACTED")
+			}
+		}
+	}
+}
+
+// YAML renders the configuration in the format Load reads
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
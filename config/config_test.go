@@ -0,0 +1,191 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789012345678901234567890123456789"
+
+// clearEnv unsets, for the rest of the test, every variable these tests set,
+// so that the environment running them cannot leak in
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"CONFIG_FILE", "PORT", "SERVER_READ_TIMEOUT", "DB_DRIVER", "DB_NAME", "DB_MAX_OPEN_CONNS", "SECRET", "SECRET_PREVIOUS", "TRACING_SAMPLE_RATIO", "LOG_LEVEL"} {
+		t.Setenv(name, "")
+	}
+}
+
+// writeConfig writes a config file with the given name to a temporary directory
+func writeConfig(t *testing.T, name, body string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// TestLoadPrecedence reads settings from the defaults, then the file, then the
+// environment
+func TestLoadPrecedence(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": "server:\n  port: \"6000\"\n  read_timeout: 20s\ndatabase:\n  driver: sqlite\n  name: file.db\nauth:\n  secret: " + testSecret + "\nlog:\n  level: debug\n",
+		"config.toml": "[server]\nport = \"6000\"\nread_timeout = \"20s\"\n[database]\ndriver = \"sqlite\"\nname = \"file.db\"\n[auth]\nsecret = \"" + testSecret + "\"\n[log]\nlevel = \"debug\"\n",
+	}
+	for name, body := range files {
+		t.Run(name, func(t *testing.T) {
+			clearEnv(t)
+			file := writeConfig(t, name, body)
+			t.Setenv("PORT", "7000")
+			t.Setenv("DB_NAME", " env.db ")
+			t.Setenv("SECRET_PREVIOUS", "old-one, old-two,,")
+			t.Setenv("TRACING_SAMPLE_RATIO", "0.25")
+
+			cfg, err := Load(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tests := []struct {
+				setting   string
+				got, want interface{}
+			}{
+				{"port from the environment over the file", cfg.Server.Port, "7000"},
+				{"database name from the environment, trimmed", cfg.Database.Name, "env.db"},
+				{"driver from the file", cfg.Database.Driver, "sqlite"},
+				{"read timeout from the file", cfg.Server.ReadTimeout, 20 * time.Second},
+				{"log level from the file", cfg.Log.Level, "debug"},
+				{"previous secrets from a list", cfg.Auth.SecretPrevious, []string{"old-one", "old-two"}},
+				{"sample ratio from the environment", cfg.Tracing.SampleRatio, 0.25},
+				{"write timeout by default", cfg.Server.WriteTimeout, 30 * time.Second},
+				{"pool size by default", cfg.Database.MaxOpenConns, 25},
+			}
+			for _, tt := range tests {
+				if !reflect.DeepEqual(tt.got, tt.want) {
+					t.Errorf("%s: got %v, want %v", tt.setting, tt.got, tt.want)
+				}
+			}
+			if err = cfg.Validate(); err != nil {
+				t.Errorf("loaded settings are invalid: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadFileFromEnvironment(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CONFIG_FILE", writeConfig(t, "config.yml", "server:\n  port: \"6000\"\n"))
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != "6000" {
+		t.Errorf("port is %s, want 6000 from CONFIG_FILE", cfg.Server.Port)
+	}
+}
+
+func TestLoadRefuses(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		body string
+		env  map[string]string
+	}{
+		{"unknown YAML setting", "config.yaml", "server:\n  prot: \"6000\"\n", nil},
+		{"unknown TOML setting", "config.toml", "[server]\nprot = \"6000\"\n", nil},
+		{"unknown file format", "config.json", `{"server":{"port":"6000"}}`, nil},
+		{"malformed YAML", "config.yaml", "server: [", nil},
+		{"duration that is not one", "config.yaml", "", map[string]string{"SERVER_READ_TIMEOUT": "soon"}},
+		{"number that is not one", "config.yaml", "", map[string]string{"DB_MAX_OPEN_CONNS": "many"}},
+		{"missing file", "", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			file := filepath.Join(t.TempDir(), "missing.yaml")
+			if tt.file != "" {
+				file = writeConfig(t, tt.file, tt.body)
+			}
+			if _, err := Load(file); err == nil {
+				t.Error("settings were loaded")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		cfg := Default()
+		cfg.Database.Driver = "sqlite"
+		cfg.Auth.Secret = testSecret
+		return cfg
+	}
+	tests := []struct {
+		name   string
+		change func(*Config)
+		want   string // part of the error, empty when the settings are valid
+	}{
+		{"valid", func(*Config) {}, ""},
+		{"secret of the minimum length", func(c *Config) { c.Auth.Secret = testSecret[:MinSecretLength] }, ""},
+		{"weak secret", func(c *Config) { c.Auth.Secret = testSecret[:MinSecretLength-1] }, "SECRET must be at least 32 characters"},
+		{"private key without a secret", func(c *Config) { c.Auth.Secret, c.Auth.PrivateKeyFile = "", "private.pem" }, ""},
+		{"nothing to sign with", func(c *Config) { c.Auth.Secret = "" }, "SECRET or JWT_PRIVATE_KEY_FILE must be set"},
+		{"port out of range", func(c *Config) { c.Server.Port = "70000" }, "PORT must be a port number"},
+		{"postgres without a host", func(c *Config) {
+			c.Database.Driver, c.Database.User, c.Database.Name = "postgres", "rielz", "rielz"
+			c.Database.Host = ""
+		}, "DB_HOST, DB_USER and DB_NAME are required"},
+		{"certificate without a key", func(c *Config) { c.Server.TLSCertFile = "cert.pem" }, "TLS_CERT_FILE and TLS_KEY_FILE must be set together"},
+		{"smtp without a host", func(c *Config) { c.Mail.Mailer = "smtp" }, "SMTP_HOST must be set"},
+		{"s3 without a bucket", func(c *Config) { c.Storage.Driver, c.Storage.S3Endpoint = "s3", "http://localhost:9000" }, "S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set"},
+		{"sample ratio above one", func(c *Config) { c.Tracing.SampleRatio = 1.5 }, "TRACING_SAMPLE_RATIO must be between 0 and 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.change(cfg)
+			err := cfg.Validate()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("got %v, want valid settings", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("got %v, want an error about %q", err, tt.want)
+			}
+		})
+	}
+
+	cfg := valid()
+	cfg.Server.Port, cfg.Log.Level = "none", "loud"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "PORT") || !strings.Contains(err.Error(), "LOG_LEVEL") {
+		t.Errorf("got %v, want every problem reported at once", err)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.Secret = testSecret
+	cfg.Auth.SecretPrevious = []string{"old-one", "old-two"}
+	cfg.Database.Password = "hunter2"
+
+	redacted := cfg.Redacted()
+	if redacted.Auth.Secret != "REDACTED" || redacted.Database.Password != "REDACTED" ||
+		!reflect.DeepEqual(redacted.Auth.SecretPrevious, []string{"REDACTED", "REDACTED"}) {
+		t.Errorf("secrets are shown as %q, %q and %v", redacted.Auth.Secret, redacted.Database.Password, redacted.Auth.SecretPrevious)
+	}
+	if redacted.Storage.S3SecretKey != "" {
+		t.Errorf("an unset secret is shown as %q, want it left empty", redacted.Storage.S3SecretKey)
+	}
+	if redacted.Database.Host != "localhost" {
+		t.Errorf("a setting that is not secret is shown as %q", redacted.Database.Host)
+	}
+	if cfg.Auth.Secret != testSecret || cfg.Auth.SecretPrevious[0] != "old-one" || cfg.Database.Password != "hunter2" {
+		t.Error("redacting changed the settings it copied")
+	}
+}
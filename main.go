@@ -5,11 +5,7 @@ import (
     "log"
     "os"
 
-    "github.com/joho/godotenv"
-
-
-
-    "github.com/khelechy/rielzapi/api/controllers"
+    "github.com/khelechy/rielzapi/api/commands"
 )
 
 // @title Rielz API
@@ -23,17 +19,7 @@ import (
 // @host localhost:5000
 // @BasePath /
 func main() {
-    if err := godotenv.Load(); err != nil {
-        log.Fatal("Error loading .env file")
+    if err := commands.App().Run(os.Args); err != nil {
+        log.Fatal(err)
     }
-
-    app := controllers.App{}
-    app.Initialize(
-        os.Getenv("DB_HOST"),
-        os.Getenv("DB_PORT"),
-        os.Getenv("DB_USER"),
-        os.Getenv("DB_NAME"),
-        os.Getenv("DB_PASSWORD"))
-
-    app.RunServer()
-}
\ No newline at end of file
+}
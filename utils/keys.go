@@ -0,0 +1,254 @@
+package utils
+
+import (
+    "crypto"
+    "crypto/ed25519"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "math/big"
+    "sort"
+    "sync"
+
+    "github.com/golang-jwt/jwt/v5"
+
+    "github.com/khelechy/rielzapi/config"
+)
+
+// SigningKey is a key that signs or verifies tokens, looked up by the kid header
+type SigningKey struct {
+    ID     string
+    Method jwt.SigningMethod
+    // Private signs new tokens; it is nil for keys kept only to verify tokens issued before a rotation
+    Private interface{}
+    Public  interface{}
+}
+
+// KeyRing holds the key new tokens are signed with and the older keys still accepted
+type KeyRing struct {
+    Issuer   string
+    Audience string
+    active   *SigningKey
+    keys     map[string]*SigningKey
+}
+
+// NewKeyRing builds a key ring that signs with active and also verifies with previous
+func NewKeyRing(issuer, audience string, active *SigningKey, previous ...*SigningKey) (*KeyRing, error) {
+    if active == nil || active.Private == nil {
+        return nil, errors.New("the active signing key must have a private key")
+    }
+    ring := &KeyRing{Issuer: issuer, Audience: audience, active: active, keys: map[string]*SigningKey{}}
+    for _, key := range append([]*SigningKey{active}, previous...) {
+        if _, exists := ring.keys[key.ID]; exists {
+            return nil, fmt.Errorf("duplicate signing key id %s", key.ID)
+        }
+        ring.keys[key.ID] = key
+    }
+    return ring, nil
+}
+
+// Active returns the key new tokens are signed with
+func (k *KeyRing) Active() *SigningKey {
+    return k.active
+}
+
+// Key returns the key with the given id
+func (k *KeyRing) Key(id string) (*SigningKey, bool) {
+    key, ok := k.keys[id]
+    return key, ok
+}
+
+// HMACKey turns a shared secret into an HS256 key. The kid is derived from a hash of the
+// secret so that it identifies the key without revealing it.
+func HMACKey(secret string) *SigningKey {
+    sum := sha256.Sum256([]byte("rielzapi-kid:" + secret))
+    return &SigningKey{
+        ID:      "hs-" + hex.EncodeToString(sum[:8]),
+        Method:  jwt.SigningMethodHS256,
+        Private: []byte(secret),
+        Public:  []byte(secret),
+    }
+}
+
+// PrivateKeyFromPEM loads an RSA (RS256) or Ed25519 (EdDSA) private key
+func PrivateKeyFromPEM(data []byte) (*SigningKey, error) {
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, errors.New("no PEM block found in private key")
+    }
+
+    private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+    if err != nil {
+        if private, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+            return nil, errors.New("private key must be a PKCS#8 or PKCS#1 RSA or Ed25519 key")
+        }
+    }
+
+    switch key := private.(type) {
+    case *rsa.PrivateKey:
+        return asymmetricKey(jwt.SigningMethodRS256, key, &key.PublicKey)
+    case ed25519.PrivateKey:
+        return asymmetricKey(jwt.SigningMethodEdDSA, key, key.Public())
+    }
+    return nil, errors.New("private key must be an RSA or Ed25519 key")
+}
+
+// PublicKeyFromPEM loads an RSA or Ed25519 public key that only verifies tokens
+func PublicKeyFromPEM(data []byte) (*SigningKey, error) {
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, errors.New("no PEM block found in public key")
+    }
+    public, err := x509.ParsePKIXPublicKey(block.Bytes)
+    if err != nil {
+        return nil, err
+    }
+
+    switch key := public.(type) {
+    case *rsa.PublicKey:
+        return asymmetricKey(jwt.SigningMethodRS256, nil, key)
+    case ed25519.PublicKey:
+        return asymmetricKey(jwt.SigningMethodEdDSA, nil, key)
+    }
+    return nil, errors.New("public key must be an RSA or Ed25519 key")
+}
+
+func asymmetricKey(method jwt.SigningMethod, private interface{}, public crypto.PublicKey) (*SigningKey, error) {
+    key := &SigningKey{Method: method, Private: private, Public: public}
+    jwk, err := key.JWK()
+    if err != nil {
+        return nil, err
+    }
+    key.ID = thumbprint(jwk)
+    return key, nil
+}
+
+// JWK returns the public half of an RSA or Ed25519 key as a JSON Web Key
+func (s *SigningKey) JWK() (map[string]string, error) {
+    enc := base64.RawURLEncoding
+    switch key := s.Public.(type) {
+    case *rsa.PublicKey:
+        return map[string]string{
+            "kty": "RSA",
+            "kid": s.ID,
+            "use": "sig",
+            "alg": s.Method.Alg(),
+            "n":   enc.EncodeToString(key.N.Bytes()),
+            "e":   enc.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+        }, nil
+    case ed25519.PublicKey:
+        return map[string]string{
+            "kty": "OKP",
+            "kid": s.ID,
+            "use": "sig",
+            "alg": s.Method.Alg(),
+            "crv": "Ed25519",
+            "x":   enc.EncodeToString(key),
+        }, nil
+    }
+    return nil, errors.New("shared secrets cannot be published")
+}
+
+// thumbprint computes the RFC 7638 thumbprint of a JWK, which makes a stable kid
+func thumbprint(jwk map[string]string) string {
+    var members string
+    switch jwk["kty"] {
+    case "RSA":
+        members = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk["e"], jwk["n"])
+    case "OKP":
+        members = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, jwk["crv"], jwk["x"])
+    }
+    sum := sha256.Sum256([]byte(members))
+    return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWKS returns the public keys of the ring in JSON Web Key Set form. Shared HMAC
+// secrets are never included, so the set is empty for HS256 only deployments.
+func (k *KeyRing) JWKS() map[string]interface{} {
+    keys := []map[string]string{}
+    for _, key := range k.keys {
+        if jwk, err := key.JWK(); err == nil {
+            keys = append(keys, jwk)
+        }
+    }
+    sort.Slice(keys, func(i, j int) bool { return keys[i]["kid"] < keys[j]["kid"] })
+    return map[string]interface{}{"keys": keys}
+}
+
+// LoadKeyRing builds the key ring from the auth settings: the private key file
+// signs when set, with the shared secret kept to verify older HS256 tokens;
+// otherwise the secret signs HS256. Public key files and previous secrets are
+// rotated out keys that still verify.
+func LoadKeyRing(cfg config.Auth) (*KeyRing, error) {
+    var active *SigningKey
+    previous := []*SigningKey{}
+
+    if cfg.PrivateKeyFile != "" {
+        data, err := ioutil.ReadFile(cfg.PrivateKeyFile)
+        if err != nil {
+            return nil, err
+        }
+        if active, err = PrivateKeyFromPEM(data); err != nil {
+            return nil, err
+        }
+        // the shared secret keeps verifying HS256 tokens issued before the switch
+        if cfg.Secret != "" {
+            previous = append(previous, verifyOnly(HMACKey(cfg.Secret)))
+        }
+    } else {
+        if cfg.Secret == "" {
+            return nil, errors.New("SECRET or JWT_PRIVATE_KEY_FILE must be set to sign tokens")
+        }
+        active = HMACKey(cfg.Secret)
+    }
+
+    for _, file := range cfg.PublicKeyFiles {
+        data, err := ioutil.ReadFile(file)
+        if err != nil {
+            return nil, err
+        }
+        key, err := PublicKeyFromPEM(data)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %v", file, err)
+        }
+        previous = append(previous, key)
+    }
+    for _, secret := range cfg.SecretPrevious {
+        previous = append(previous, verifyOnly(HMACKey(secret)))
+    }
+
+    return NewKeyRing(cfg.Issuer, cfg.Audience, active, previous...)
+}
+
+func verifyOnly(key *SigningKey) *SigningKey {
+    key.Private = nil
+    return key
+}
+
+var (
+    keyRingMu sync.RWMutex
+    keyRing   *KeyRing
+)
+
+// SetKeyRing replaces the keys used to sign and verify tokens
+func SetKeyRing(ring *KeyRing) {
+    keyRingMu.Lock()
+    defer keyRingMu.Unlock()
+    keyRing = ring
+}
+
+// Keys returns the key ring set by SetKeyRing
+func Keys() (*KeyRing, error) {
+    keyRingMu.RLock()
+    defer keyRingMu.RUnlock()
+    if keyRing == nil {
+        return nil, errors.New("token signing keys have not been loaded")
+    }
+    return keyRing, nil
+}
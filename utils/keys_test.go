@@ -0,0 +1,314 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/khelechy/rielzapi/config"
+)
+
+const (
+	testSecret    = "0123456789012345678901234567890123456789"
+	testOldSecret = "9876543210987654321098765432109876543210"
+)
+
+// useKeyRing loads the key ring for the auth settings and signs and verifies
+// tokens with it for the rest of the test
+func useKeyRing(t *testing.T, cfg config.Auth) *KeyRing {
+	t.Helper()
+	if cfg.Issuer == "" {
+		cfg.Issuer, cfg.Audience = "rielzapi", "rielzapi"
+	}
+	ring, err := LoadKeyRing(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyRing(ring)
+	t.Cleanup(func() { SetKeyRing(nil) })
+	return ring
+}
+
+// writePEM writes a PEM block to a file in a temporary directory
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// rsaKeyFile writes a new RSA private key in PKCS#8 form
+func rsaKeyFile(t *testing.T) (string, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "private.pem", "PRIVATE KEY", der), key
+}
+
+// ed25519PublicKeyFile writes a new Ed25519 public key
+func ed25519PublicKeyFile(t *testing.T) (string, ed25519.PublicKey) {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "public.pem", "PUBLIC KEY", der), public
+}
+
+func TestLoadKeyRing(t *testing.T) {
+	privateFile, _ := rsaKeyFile(t)
+	publicFile, _ := ed25519PublicKeyFile(t)
+
+	tests := []struct {
+		name   string
+		cfg    config.Auth
+		active string // algorithm new tokens are signed with
+		keys   int
+		fails  bool
+	}{
+		{"secret", config.Auth{Secret: testSecret}, "HS256", 1, false},
+		{"secret with a previous one", config.Auth{Secret: testSecret, SecretPrevious: []string{testOldSecret}}, "HS256", 2, false},
+		{"private key keeps the secret to verify", config.Auth{Secret: testSecret, PrivateKeyFile: privateFile}, "RS256", 2, false},
+		{"private key with a rotated out public key", config.Auth{PrivateKeyFile: privateFile, PublicKeyFiles: []string{publicFile}}, "RS256", 2, false},
+		{"nothing to sign with", config.Auth{}, "", 0, true},
+		{"missing private key file", config.Auth{PrivateKeyFile: filepath.Join(t.TempDir(), "missing.pem")}, "", 0, true},
+		{"public key as the private key", config.Auth{PrivateKeyFile: publicFile}, "", 0, true},
+		{"same secret twice", config.Auth{Secret: testSecret, SecretPrevious: []string{testSecret}}, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring, err := LoadKeyRing(tt.cfg)
+			if (err != nil) != tt.fails {
+				t.Fatalf("got error %v, want failure %v", err, tt.fails)
+			}
+			if tt.fails {
+				return
+			}
+			if alg := ring.Active().Method.Alg(); alg != tt.active {
+				t.Errorf("tokens are signed with %s, want %s", alg, tt.active)
+			}
+			if len(ring.keys) != tt.keys {
+				t.Errorf("ring holds %d keys, want %d", len(ring.keys), tt.keys)
+			}
+			for id, key := range ring.keys {
+				if key != ring.Active() && key.Private != nil {
+					t.Errorf("previous key %s can still sign", id)
+				}
+			}
+		})
+	}
+}
+
+// TestJWKS publishes the public keys of the ring and never a shared secret
+func TestJWKS(t *testing.T) {
+	privateFile, private := rsaKeyFile(t)
+	publicFile, public := ed25519PublicKeyFile(t)
+	ring, err := LoadKeyRing(config.Auth{Secret: testSecret, PrivateKeyFile: privateFile, PublicKeyFiles: []string{publicFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := ring.JWKS()["keys"].([]map[string]string)
+	if len(keys) != 2 {
+		t.Fatalf("JWKS holds %v, want the RSA and Ed25519 keys only", keys)
+	}
+	if keys[0]["kid"] > keys[1]["kid"] {
+		t.Errorf("keys are not in order of kid: %s, %s", keys[0]["kid"], keys[1]["kid"])
+	}
+	enc := base64.RawURLEncoding
+	for _, jwk := range keys {
+		var members interface{}
+		switch jwk["kty"] {
+		case "RSA":
+			if jwk["alg"] != "RS256" || jwk["n"] != enc.EncodeToString(private.N.Bytes()) || jwk["e"] != "AQAB" {
+				t.Errorf("RSA key is published as %v", jwk)
+			}
+			members = map[string]string{"e": jwk["e"], "kty": "RSA", "n": jwk["n"]}
+		case "OKP":
+			if jwk["alg"] != "EdDSA" || jwk["crv"] != "Ed25519" || jwk["x"] != enc.EncodeToString(public) {
+				t.Errorf("Ed25519 key is published as %v", jwk)
+			}
+			members = map[string]string{"crv": "Ed25519", "kty": "OKP", "x": jwk["x"]}
+		default:
+			t.Errorf("unexpected key %v", jwk)
+			continue
+		}
+		// RFC 7638: the required members in lexicographic order, without spaces
+		data, _ := json.Marshal(members)
+		sum := sha256.Sum256(data)
+		if want := enc.EncodeToString(sum[:]); jwk["kid"] != want {
+			t.Errorf("%s key has kid %s, want its thumbprint %s", jwk["kty"], jwk["kid"], want)
+		}
+		if jwk["use"] != "sig" {
+			t.Errorf("%s key is for %q, want sig", jwk["kty"], jwk["use"])
+		}
+	}
+
+	hmacOnly, err := LoadKeyRing(config.Auth{Secret: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := hmacOnly.JWKS()["keys"].([]map[string]string); len(keys) != 0 {
+		t.Errorf("JWKS of a shared secret holds %v, want no keys", keys)
+	}
+}
+
+// TestAuthTokenRotation keeps tokens signed with a previous secret or key
+// verifying until it is dropped from the ring
+func TestAuthTokenRotation(t *testing.T) {
+	privateFile, _ := rsaKeyFile(t)
+
+	useKeyRing(t, config.Auth{Secret: testOldSecret})
+	old, err := EncodeAuthToken(7, "landlord")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name    string
+		cfg     config.Auth
+		verifys bool
+	}{
+		{"previous secret still verifies", config.Auth{Secret: testSecret, SecretPrevious: []string{testOldSecret}}, true},
+		{"secret kept beside a private key still verifies", config.Auth{Secret: testOldSecret, PrivateKeyFile: privateFile}, true},
+		{"dropped secret no longer verifies", config.Auth{Secret: testSecret}, false},
+	}
+	for _, step := range steps {
+		useKeyRing(t, step.cfg)
+		claims, err := ParseAuthToken(old)
+		if (err == nil) != step.verifys {
+			t.Errorf("%s: got error %v", step.name, err)
+			continue
+		}
+		if err == nil && (claims.UserID != 7 || claims.Role != "landlord") {
+			t.Errorf("%s: token is for user %d as %s, want user 7 as landlord", step.name, claims.UserID, claims.Role)
+		}
+
+		token, err := EncodeAuthToken(8, "agent")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseAuthToken(token); err != nil {
+			t.Errorf("%s: token signed with the active key returned %v", step.name, err)
+		}
+	}
+}
+
+// sign signs claims with the method and key given, under the kid given
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// TestParseAuthTokenRefuses checks every way an access token can be forged
+// or stale
+func TestParseAuthTokenRefuses(t *testing.T) {
+	privateFile, private := rsaKeyFile(t)
+	ring := useKeyRing(t, config.Auth{Secret: testSecret, PrivateKeyFile: privateFile})
+	rsaKey := ring.Active()
+	hmacKey := HMACKey(testSecret)
+	publicDER, _ := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	now := time.Now()
+	claims := func(change func(*AuthClaims)) *AuthClaims {
+		c := &AuthClaims{UserID: 7, Role: "landlord", RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
+			Issuer:    "rielzapi",
+			Audience:  jwt.ClaimStrings{"rielzapi"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+		}}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+
+	if _, err := ParseAuthToken(sign(t, jwt.SigningMethodRS256, private, rsaKey.ID, claims(nil))); err != nil {
+		t.Fatalf("valid RS256 token returned %v", err)
+	}
+	if _, err := ParseAuthToken(sign(t, jwt.SigningMethodHS256, []byte(testSecret), hmacKey.ID, claims(nil))); err != nil {
+		t.Fatalf("valid HS256 token under the kept secret returned %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"public key used as an HMAC secret", sign(t, jwt.SigningMethodHS256, publicPEM, rsaKey.ID, claims(nil))},
+		{"HS384 under an HS256 key", sign(t, jwt.SigningMethodHS384, []byte(testSecret), hmacKey.ID, claims(nil))},
+		{"RS512 under an RS256 key", sign(t, jwt.SigningMethodRS512, private, rsaKey.ID, claims(nil))},
+		{"unsigned", sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, rsaKey.ID, claims(nil))},
+		{"unknown kid", sign(t, jwt.SigningMethodHS256, []byte(testSecret), "hs-unknown", claims(nil))},
+		{"no kid", sign(t, jwt.SigningMethodRS256, private, "", claims(nil))},
+		{"wrong secret", sign(t, jwt.SigningMethodHS256, []byte(testOldSecret), hmacKey.ID, claims(nil))},
+		{"other issuer", sign(t, jwt.SigningMethodRS256, private, rsaKey.ID, claims(func(c *AuthClaims) { c.Issuer = "someone-else" }))},
+		{"other audience", sign(t, jwt.SigningMethodRS256, private, rsaKey.ID, claims(func(c *AuthClaims) { c.Audience = jwt.ClaimStrings{"other-api"} }))},
+		{"expired", sign(t, jwt.SigningMethodRS256, private, rsaKey.ID, claims(func(c *AuthClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+		}))},
+		{"no expiry", sign(t, jwt.SigningMethodRS256, private, rsaKey.ID, claims(func(c *AuthClaims) { c.ExpiresAt = nil }))},
+		{"not yet valid", sign(t, jwt.SigningMethodRS256, private, rsaKey.ID, claims(func(c *AuthClaims) {
+			c.NotBefore = jwt.NewNumericDate(now.Add(time.Minute))
+		}))},
+		{"issued in the future", sign(t, jwt.SigningMethodRS256, private, rsaKey.ID, claims(func(c *AuthClaims) {
+			c.IssuedAt = jwt.NewNumericDate(now.Add(time.Minute))
+		}))},
+		{"no jti", sign(t, jwt.SigningMethodRS256, private, rsaKey.ID, claims(func(c *AuthClaims) { c.ID = "" }))},
+		{"no iat", sign(t, jwt.SigningMethodRS256, private, rsaKey.ID, claims(func(c *AuthClaims) { c.IssuedAt = nil }))},
+	}
+	for _, tt := range tests {
+		if _, err := ParseAuthToken(tt.token); err == nil {
+			t.Errorf("%s: token was accepted", tt.name)
+		}
+	}
+}
+
+// TestRSAPublicExponent publishes e in its shortest big-endian form
+func TestRSAPublicExponent(t *testing.T) {
+	key := &SigningKey{ID: "kid", Method: jwt.SigningMethodRS256, Public: &rsa.PublicKey{N: big.NewInt(3233), E: 3}}
+	jwk, err := key.JWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwk["e"] != "Aw" {
+		t.Errorf("e of 3 is published as %q, want Aw", jwk["e"])
+	}
+	if _, err := HMACKey(testSecret).JWK(); err == nil {
+		t.Error("a shared secret was turned into a JWK")
+	}
+}
@@ -1,18 +1,130 @@
 package utils
 
 import (
-    "os"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
     "time"
 
-    jwt "github.com/dgrijalva/jwt-go"
+    "github.com/golang-jwt/jwt/v5"
 )
 
-// EncodeAuthToken signs authentication token
-func EncodeAuthToken(uid uint) (string, error) {
-    claims := jwt.MapClaims{}
-    claims["userID"] = uid
-    claims["IssuedAt"] = time.Now().Unix()
-    claims["ExpiresAt"] = time.Now().Add(time.Hour * 24).Unix()
-    token := jwt.NewWithClaims(jwt.GetSigningMethod("HS256"), claims)
-    return token.SignedString([]byte(os.Getenv("SECRET")))
-}
\ No newline at end of file
+const (
+    // AccessTokenTTL is how long an access token is accepted for
+    AccessTokenTTL = 15 * time.Minute
+    // RefreshTokenTTL is how long a refresh token can be exchanged for new tokens
+    RefreshTokenTTL = 30 * 24 * time.Hour
+    // PasswordResetTTL is how long a mailed password reset link works
+    PasswordResetTTL = time.Hour
+    // EmailVerificationTTL is how long a mailed email verification link works
+    EmailVerificationTTL = 48 * time.Hour
+    // clockSkew is the leeway given to exp, iat and nbf between servers
+    clockSkew = 30 * time.Second
+)
+
+// AuthClaims are the claims carried by an access token
+type AuthClaims struct {
+    UserID uint   `json:"userID"`
+    Role   string `json:"role"`
+    jwt.RegisteredClaims
+}
+
+// EncodeAuthToken signs a short lived access token identified by a random jti
+func EncodeAuthToken(uid uint, role string) (string, error) {
+    ring, err := Keys()
+    if err != nil {
+        return "", err
+    }
+    jti, err := randomToken(16)
+    if err != nil {
+        return "", err
+    }
+
+    now := time.Now()
+    claims := AuthClaims{
+        UserID: uid,
+        Role:   role,
+        RegisteredClaims: jwt.RegisteredClaims{
+            ID:        jti,
+            Issuer:    ring.Issuer,
+            Audience:  jwt.ClaimStrings{ring.Audience},
+            IssuedAt:  jwt.NewNumericDate(now),
+            NotBefore: jwt.NewNumericDate(now),
+            ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+        },
+    }
+
+    key := ring.Active()
+    token := jwt.NewWithClaims(key.Method, claims)
+    token.Header["kid"] = key.ID
+    return token.SignedString(key.Private)
+}
+
+// ParseAuthToken verifies an access token and returns its claims. The key is chosen by
+// the kid header and the token must use that key's algorithm; exp, iat, nbf, iss and
+// aud are all required and checked.
+func ParseAuthToken(tokenString string) (*AuthClaims, error) {
+    ring, err := Keys()
+    if err != nil {
+        return nil, err
+    }
+
+    var key *SigningKey
+    claims := &AuthClaims{}
+    _, err = jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+        kid, _ := token.Header["kid"].(string)
+        found, ok := ring.Key(kid)
+        if !ok {
+            return nil, errors.New("unknown signing key")
+        }
+        key = found
+        if token.Method.Alg() != key.Method.Alg() {
+            return nil, errors.New("unexpected signing method")
+        }
+        return key.Public, nil
+    },
+        jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}),
+        jwt.WithIssuer(ring.Issuer),
+        jwt.WithAudience(ring.Audience),
+        jwt.WithExpirationRequired(),
+        jwt.WithIssuedAt(),
+        jwt.WithLeeway(clockSkew),
+    )
+    if err != nil {
+        return nil, err
+    }
+    if claims.ID == "" || claims.IssuedAt == nil {
+        return nil, errors.New("token is missing its jti or iat claim")
+    }
+    return claims, nil
+}
+
+// NewRefreshToken returns a random refresh token for the client and the hash to store for it
+func NewRefreshToken() (token string, hash string, err error) {
+    return NewOpaqueToken()
+}
+
+// NewOpaqueToken returns a random token to hand out and the hash to store for it
+func NewOpaqueToken() (token string, hash string, err error) {
+    token, err = randomToken(32)
+    if err != nil {
+        return "", "", err
+    }
+    return token, HashToken(token), nil
+}
+
+// HashToken returns the hex encoded SHA-256 of an opaque token
+func HashToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+func randomToken(size int) (string, error) {
+    b := make([]byte, size)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+    "runtime"
+    "runtime/debug"
+)
+
+// Build details, set at link time:
+//   go build -ldflags "-X github.com/khelechy/rielzapi/utils.Version=v1.4.0
+//     -X github.com/khelechy/rielzapi/utils.Commit=$(git rev-parse HEAD)
+//     -X github.com/khelechy/rielzapi/utils.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+// Commit and BuildTime fall back to the VCS stamp Go embeds in binaries built from a checkout.
+var (
+    Version   = "dev"
+    Commit    = ""
+    BuildTime = ""
+)
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+    Version   string `json:"version"`
+    Commit    string `json:"commit"`
+    BuildTime string `json:"build_time"`
+    Modified  bool   `json:"modified"`
+    GoVersion string `json:"go_version"`
+}
+
+// Build returns the details of the running binary
+func Build() BuildInfo {
+    info := BuildInfo{Version: Version, Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+    if build, ok := debug.ReadBuildInfo(); ok {
+        for _, setting := range build.Settings {
+            switch setting.Key {
+            case "vcs.revision":
+                if info.Commit == "" {
+                    info.Commit = setting.Value
+                }
+            case "vcs.time":
+                if info.BuildTime == "" {
+                    info.BuildTime = setting.Value
+                }
+            case "vcs.modified":
+                info.Modified = setting.Value == "true"
+            }
+        }
+    }
+    return info
+}